@@ -4,40 +4,419 @@ Copyright © 2024 Kol Ratner kolratner@gmail.com
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"slices"
+	"strings"
 
-	"github.com/kol-ratner/tufin/internal/cluster"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kol-ratner/tufin/internal/cluster"
+	"github.com/kol-ratner/tufin/internal/deployments"
+	"github.com/kol-ratner/tufin/pkg/k8s"
 )
 
+// clusterOutputFormats are the values --output accepts, on top of the log lines every command prints
+var clusterOutputFormats = []string{"json", "yaml"}
+
 // clusterCmd represents the cluster command
 var clusterCmd = &cobra.Command{
 	Use:   "cluster",
-	Short: "Manage Kubernetes cluster configuration and resources",
-	Long: `The cluster command provides tools for managing your Kubernetes cluster setup.
+	Short: "Manage local k3d Kubernetes clusters",
+	Long: `The cluster command manages local Kubernetes clusters with k3d, which is embedded in tufin,
+so there's nothing else to install besides a container runtime such as Docker.
 
-Key Features:
-  - Create a new Kubernetes cluster
+Subcommands:
+  create  Create a cluster, with its ingress controller published on the host's ports 80 and 443
+  delete  Delete a cluster with all its nodes and data
+  list    List the clusters and the state of their nodes
+  start   Start a stopped cluster
+  stop    Stop a cluster, keeping its state for a later start
+
+create checks the container runtime, the host ports, memory, disk and the kubeconfig before
+creating a cluster, and fails with what to do about it when one of them isn't up to it.
+"tufin doctor" runs the same checks on their own, --skip-preflight skips them.
+
+Every subcommand works on the cluster given by --name, "k3s-default" unless set.
+Running "tufin cluster" on its own is the same as "tufin cluster create", and takes its flags too.
+
+With --output json or yaml, the outcome is also written to stdout in that format,
+for scripts; the progress log keeps going to stderr.
 
 Examples:
-  # Create a new Kubernetes cluster
-  tufin cluster`,
-	Run: clusterEntrypoint,
+  # Create the default cluster
+  tufin cluster create
+
+  # Create a second cluster, and report it as JSON
+  tufin cluster create --name review -o json
+
+  # Create a cluster with three agents, to see how MySQL's replicas get scheduled
+  tufin cluster create --name multi --agents 3
+
+  # Stop the cluster at the end of the day, and start it again the next morning
+  tufin cluster stop
+  tufin cluster start
+
+  # Delete a cluster without asking for confirmation
+  tufin cluster delete --name review --yes`,
+	Args: cobra.NoArgs,
+	Run:  clusterCreateEntrypoint,
+}
+
+var clusterCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a k3d cluster",
+	Long: `Create a k3d cluster, unless one of the same name exists already.
+
+The cluster's load balancer publishes Traefik, the ingress controller bundled with k3s,
+on the host's ports 80 and 443, so those have to be free. --port replaces that mapping.
+
+Topology:
+  --servers          number of server (control plane) nodes, 1 unless set
+  --agents           number of agent (worker) nodes, none unless set
+  --image            k3s image the nodes run, which picks the Kubernetes version
+  --port             host port mapping, [host:]hostPort:containerPort[/protocol][@nodefilter], repeatable
+  --volume           host path mounted into the nodes, source:destination[@nodefilter], repeatable
+  --k3s-arg          extra k3s argument, arg[@nodefilter], repeatable
+  --registry-mirror  registry mirror, registry=endpoint, repeatable
+
+The cluster's credentials are merged into the kubeconfig the other commands use, ~/.kube/config unless
+--kubeconfigPath is given, and its current context is switched to the new cluster, also when it existed already.
+--private-kubeconfig writes them to a kubeconfig of tufin's own instead, leaving ~/.kube/config alone.
+Every other command uses that file from then on, until the last cluster in it is deleted.
+
+--config takes a k3d config file (apiVersion k3d.io/v1alpha5, kind Simple) for anything the flags
+don't cover. Flags are applied on top of it, and its name is used when --name isn't given.
+
+Examples:
+  # Three agents for MySQL's replicas to spread over, and WordPress on http://localhost:8080
+  tufin cluster create --agents 3 --port 8080:80@loadbalancer
+
+  # Pin the Kubernetes version, and pull Docker Hub images through a mirror
+  tufin cluster create --image rancher/k3s:v1.31.2-k3s1 --registry-mirror docker.io=https://mirror.gcr.io
+
+  # Leave ingress to something else than Traefik
+  tufin cluster create --k3s-arg "--disable=traefik@server:*"
+
+  # Everything from a k3d config file
+  tufin cluster create --config k3d.yaml
+
+  # Keep the cluster out of ~/.kube/config, tufin deploys to it all the same
+  tufin cluster create --private-kubeconfig`,
+	Args: cobra.NoArgs,
+	Run:  clusterCreateEntrypoint,
+}
+
+var clusterDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a k3d cluster",
+	Long: `Delete a k3d cluster together with its nodes, network and volumes.
+Everything deployed to it, databases included, is lost. Deleting a cluster that doesn't exist does nothing.
+Its context is removed from the kubeconfig, and from tufin's private one.`,
+	Args: cobra.NoArgs,
+	Run:  clusterDeleteEntrypoint,
+}
+
+var clusterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the k3d clusters",
+	Long:  `List the k3d clusters on this host, with the number of servers and agents running and the cluster's status.`,
+	Args:  cobra.NoArgs,
+	Run:   clusterListEntrypoint,
+}
+
+var clusterStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start a stopped k3d cluster",
+	Args:  cobra.NoArgs,
+	Run:   clusterStartEntrypoint,
+}
+
+var clusterStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop a k3d cluster",
+	Long:  `Stop the nodes of a k3d cluster. Its state, and the data of everything deployed to it, is kept for the next start.`,
+	Args:  cobra.NoArgs,
+	Run:   clusterStopEntrypoint,
 }
 
 func init() {
 	rootCmd.AddCommand(clusterCmd)
+	clusterCmd.AddCommand(clusterCreateCmd, clusterDeleteCmd, clusterListCmd, clusterStartCmd, clusterStopCmd)
+
+	clusterCmd.PersistentFlags().String("name", "", `name of the cluster (default "k3s-default", or the name in --config)`)
+	clusterCmd.PersistentFlags().StringP("output", "o", "", "also write the outcome to stdout: json or yaml (list: table, json or yaml)")
+	clusterDeleteCmd.Flags().BoolP("yes", "y", false, "skip the confirmation prompt")
+
+	// tufin cluster on its own creates a cluster too, so it takes the same flags
+	addClusterCreateFlags(clusterCmd)
+	addClusterCreateFlags(clusterCreateCmd)
+}
+
+// addClusterCreateFlags adds the flags clusterCreateOptions reads to c
+func addClusterCreateFlags(c *cobra.Command) {
+	c.Flags().Int("servers", 0, "number of server nodes (default 1)")
+	c.Flags().Int("agents", 0, "number of agent nodes")
+	c.Flags().String("image", "", "k3s image of the nodes, e.g. rancher/k3s:v1.31.2-k3s1")
+	c.Flags().StringArray("port", nil, "map a host port into the cluster, e.g. 8080:80@loadbalancer (default 80:80@loadbalancer and 443:443@loadbalancer)")
+	c.Flags().StringArray("volume", nil, "mount a host path into the nodes, e.g. /data:/data@agent:*")
+	c.Flags().StringArray("k3s-arg", nil, "pass an extra argument to k3s, e.g. --disable=traefik@server:*")
+	c.Flags().StringArray("registry-mirror", nil, "pull a registry's images through a mirror, e.g. docker.io=https://mirror.gcr.io")
+	c.Flags().String("config", "", "k3d config file (k3d.io/v1alpha5 Simple) the flags are applied on top of")
+	c.Flags().Bool("private-kubeconfig", false, "write the cluster's kubeconfig to tufin's own file rather than ~/.kube/config, tufin uses it from then on")
+	c.Flags().Int("api-port", 0, "host port the API server is published on (default a free one k3d picks)")
+	c.Flags().Bool("skip-preflight", false, "create the cluster without checking the container runtime, ports, memory, disk and kubeconfig first")
+}
+
+func clusterCreateEntrypoint(cmd *cobra.Command, args []string) {
+	name, output := clusterFlags(cmd)
+	opts, err := clusterCreateOptions(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Name = name
+	if opts.Kubeconfig, err = clusterKubeconfig(cmd); err != nil {
+		log.Fatal(err)
+	}
+	// the preflight makes room for the stack tufin deploy ships by default
+	if opts.Requirements, err = stackRequirements(deployments.ShipOptions{}); err != nil {
+		log.Fatal(err)
+	}
+
+	runClusterOperation(cmd, output, func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+		return c.Create(msgs, opts)
+	})
+}
+
+// clusterCreateOptions reads the topology flags of the create command
+func clusterCreateOptions(cmd *cobra.Command) (cluster.CreateOptions, error) {
+	var opts cluster.CreateOptions
+	var err error
+	flags := cmd.Flags()
+
+	if opts.Servers, err = flags.GetInt("servers"); err != nil {
+		return opts, err
+	}
+	if opts.Agents, err = flags.GetInt("agents"); err != nil {
+		return opts, err
+	}
+	if opts.Image, err = flags.GetString("image"); err != nil {
+		return opts, err
+	}
+	if opts.ConfigFile, err = flags.GetString("config"); err != nil {
+		return opts, err
+	}
+	// only an explicit --port replaces the default mapping
+	if flags.Changed("port") {
+		if opts.Ports, err = flags.GetStringArray("port"); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Volumes, err = flags.GetStringArray("volume"); err != nil {
+		return opts, err
+	}
+	if opts.K3sArgs, err = flags.GetStringArray("k3s-arg"); err != nil {
+		return opts, err
+	}
+	if opts.APIPort, err = flags.GetInt("api-port"); err != nil {
+		return opts, err
+	}
+	if opts.SkipPreflight, err = flags.GetBool("skip-preflight"); err != nil {
+		return opts, err
+	}
+
+	mirrors, err := flags.GetStringArray("registry-mirror")
+	if err != nil {
+		return opts, err
+	}
+	for _, m := range mirrors {
+		registry, endpoint, ok := strings.Cut(m, "=")
+		if !ok {
+			return opts, fmt.Errorf("invalid registry mirror %q, expected registry=endpoint", m)
+		}
+		if opts.RegistryMirrors == nil {
+			opts.RegistryMirrors = map[string]string{}
+		}
+		opts.RegistryMirrors[registry] = endpoint
+	}
+	return opts, nil
+}
+
+// stackRequirements returns what the stack deployed with the options and configs asks of the cluster
+func stackRequirements(opts deployments.ShipOptions, configs ...deployments.DeploymentConfig) (cluster.Requirements, error) {
+	requests, err := deployments.GetRequests(opts, configs...)
+	if err != nil {
+		return cluster.Requirements{}, err
+	}
+	return cluster.Requirements{Memory: requests.Memory, Storage: requests.Storage}, nil
+}
+
+func clusterDeleteEntrypoint(cmd *cobra.Command, args []string) {
+	name, output := clusterFlags(cmd)
+	if !confirmClusterDelete(cmd, name) {
+		log.Println("aborted")
+		return
+	}
+
+	runClusterOperation(cmd, output, func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+		return deleteCluster(c, msgs, name)
+	})
+}
+
+// confirmClusterDelete asks whether to delete the cluster called name, unless --yes was given
+func confirmClusterDelete(cmd *cobra.Command, name string) bool {
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if name == "" {
+		name = cluster.DefaultName
+	}
+	return yes || confirm(cmd, fmt.Sprintf("This will permanently delete cluster %s and everything deployed to it. Continue?", name))
+}
+
+// deleteCluster deletes the cluster called name, and its context from the kubeconfigs
+func deleteCluster(c *cluster.Client, msgs chan<- string, name string) (cluster.Result, error) {
+	result, err := c.Delete(msgs, name)
+	if err != nil {
+		return result, err
+	}
+	return result, forgetKubeconfig(msgs, result.Name)
+}
+
+// clusterKubeconfig returns the kubeconfig cluster create writes to: the private one when asked for,
+// otherwise the one every other command uses
+func clusterKubeconfig(cmd *cobra.Command) (string, error) {
+	private, err := cmd.Flags().GetBool("private-kubeconfig")
+	if err != nil {
+		return "", err
+	}
+	if !private {
+		return kubeconfigPath, nil
+	}
+	path := k8s.PrivateKubeConfigPath()
+	if path == "" {
+		return "", fmt.Errorf("there is no config directory to keep a private kubeconfig in, use --kubeconfigPath instead")
+	}
+	return path, nil
+}
+
+// forgetKubeconfig removes the deleted cluster's context from the kubeconfig in use and from the private one.
+// The private kubeconfig goes away with its last context, so tufin falls back to ~/.kube/config.
+func forgetKubeconfig(msgs chan<- string, name string) error {
+	context := cluster.ContextName(name)
+	private := k8s.PrivateKubeConfigPath()
+
+	for _, path := range slices.Compact([]string{kubeconfigPath, private}) {
+		if path == "" {
+			continue
+		}
+		left, err := k8s.RemoveKubeConfigContext(path, context)
+		if err != nil {
+			return fmt.Errorf("failed to remove context %s from %s: %w", context, path, err)
+		}
+		if path == private && left == 0 {
+			err := os.Remove(path)
+			if err == nil {
+				msgs <- fmt.Sprintf("removed the private kubeconfig %s, it has no clusters left", path)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func clusterStartEntrypoint(cmd *cobra.Command, args []string) {
+	name, output := clusterFlags(cmd)
+	runClusterOperation(cmd, output, func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+		return c.Start(msgs, name)
+	})
 }
 
-func clusterEntrypoint(cmd *cobra.Command, args []string) {
+func clusterStopEntrypoint(cmd *cobra.Command, args []string) {
+	name, output := clusterFlags(cmd)
+	runClusterOperation(cmd, output, func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+		return c.Stop(msgs, name)
+	})
+}
+
+func clusterListEntrypoint(cmd *cobra.Command, args []string) {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if output == "" {
+		output = "table"
+	}
+	if output != "table" && !slices.Contains(clusterOutputFormats, output) {
+		log.Fatalf("unsupported output format %q, use table, json or yaml", output)
+	}
+
+	c, err := cluster.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	clusters, err := c.List()
+	if err != nil {
+		log.Println(err)
+		c.Close()
+		os.Exit(1)
+	}
+
+	if output == "table" {
+		writeClusterTable(cmd.OutOrStdout(), clusters)
+		return
+	}
+	if err := writeStructured(cmd.OutOrStdout(), output, clusters); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// clusterFlags returns the cluster name and the output format the command was given
+func clusterFlags(cmd *cobra.Command) (string, string) {
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		log.Fatal(err)
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if output != "" && !slices.Contains(clusterOutputFormats, output) {
+		log.Fatalf("unsupported output format %q, use json or yaml", output)
+	}
+	return name, output
+}
+
+// runClusterOperation runs op against the embedded k3d, logging its progress,
+// and writes its result to stdout when an output format is given
+func runClusterOperation(cmd *cobra.Command, output string, op func(*cluster.Client, chan<- string) (cluster.Result, error)) {
+	c, err := cluster.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
 	msgs := make(chan string)
-	// the done channel signals to the main goroutine that the cluster.Create() function has completed
-	// otherwise our program will continue trying to process messages from the cluster.Create() function and panic
+	// the done channel signals to the main goroutine that the cluster operation has completed
+	// otherwise our program will continue trying to process messages from the cluster operation and panic
 	done := make(chan bool)
+	// result and opErr are only read once done has been received, so there's no race on them
+	var result cluster.Result
+	var opErr error
 
 	go func() {
-		if err := cluster.Create(msgs); err != nil {
-			log.Println(err)
+		result, opErr = op(c, msgs)
+		if opErr != nil {
+			log.Println(opErr)
 		}
 		done <- true
 	}()
@@ -48,7 +427,50 @@ func clusterEntrypoint(cmd *cobra.Command, args []string) {
 			log.Println(msg)
 		case <-done:
 			close(msgs)
+			if opErr != nil {
+				// os.Exit skips the deferred Close, which removes the k3d binary
+				c.Close()
+				os.Exit(1)
+			}
+			if output != "" {
+				if err := writeStructured(cmd.OutOrStdout(), output, result); err != nil {
+					log.Fatal(err)
+				}
+			}
 			return
 		}
 	}
 }
+
+// writeStructured writes v to w as indented JSON or as YAML
+func writeStructured(w io.Writer, format string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if format == "yaml" {
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return err
+		}
+	} else {
+		data = append(data, '\n')
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writeClusterTable(w io.Writer, clusters []cluster.Info) {
+	t := table.NewWriter()
+	t.SetOutputMirror(w)
+	t.AppendHeader(table.Row{"NAME", "SERVERS", "AGENTS", "LOADBALANCER", "STATUS"})
+	for _, c := range clusters {
+		t.AppendRow(table.Row{
+			c.Name,
+			fmt.Sprintf("%d/%d", c.ServersRunning, c.Servers),
+			fmt.Sprintf("%d/%d", c.AgentsRunning, c.Agents),
+			c.HasLoadBalancer,
+			c.Status(),
+		})
+	}
+	t.Render()
+}
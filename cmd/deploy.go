@@ -6,13 +6,16 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"strconv"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 
 	"github.com/kol-ratner/tufin/internal/config"
 	"github.com/kol-ratner/tufin/internal/deployments"
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
 )
 
 // deployCmd represents the deploy command
@@ -24,6 +27,39 @@ var deployCmd = &cobra.Command{
 The deploy command supports customizing resource allocations for both WordPress and MySQL components
 using dot notation to specify which component gets which settings.
 
+Options can also be kept in a YAML or JSON values file passed with -f/--values, which uses
+the same keys grouped per component. Values files are applied in the order given and --set
+is applied on top of them:
+
+  wordpress:
+    replicas: 2
+    memory-request: 1Gi
+  mysql:
+    volume-size: 10Gi
+
+Components are deployed in dependency order: wordpress is only applied once mysql reports ready,
+so a fresh install doesn't leave wordpress crash-looping while the database starts up.
+Waiting for dependencies is bounded by --timeout.
+
+Resources are created and updated with server-side apply, so running deploy repeatedly is safe
+and leaves fields set by other controllers alone. If another tool owns a field tufin wants to set,
+the deploy fails with a conflict; use --force-conflicts to take ownership of those fields.
+
+Use --dry-run to see what deploy would apply without applying it. By default the objects are
+rendered locally and printed as YAML, with secret values redacted, which needs no cluster.
+--dry-run=server instead sends them to the API server as a server-side dry run, which validates
+them, including admission, without persisting anything.
+
+Config files can be mounted into the components with --set-file component.file=path,
+or under files in the values file, so settings can be tuned without building images:
+  - mysql    : my.cnf, loaded after the image's defaults
+  - wordpress: php.ini, loaded after the image's php.ini, and .htaccess
+Pods are restarted when a file changes.
+
+The MySQL password is generated on the first deploy and kept on every redeploy.
+Use --rotate-credentials to replace it; the new password is applied inside the running
+database before it is stored, and WordPress is restarted to pick it up.
+
 Available Components:
   - wordpress
   - mysql
@@ -35,6 +71,37 @@ Configuration Options:
   - cpu-limit     : Maximum CPU allowed (e.g. 500m, 1)
   - memory-limit  : Maximum memory allowed (e.g. 512Mi, 2Gi)
   - volume-size   : Persistent volume size (e.g. 5Gi, 10Gi)
+  - <probe>-probe.<field>: Tune the liveness, readiness or startup probe, where <field> is
+                    enabled (true or false), initial-delay-seconds, period-seconds,
+                    timeout-seconds or failure-threshold (e.g. startup-probe.failure-threshold=60)
+
+  - run-as-user, run-as-group, fs-group: User, group and volume group ids the pod runs with
+  - run-as-non-root: Refuse to start containers as root (true or false, default true)
+  - read-only-root-filesystem: Mount the container's root filesystem read-only (true or false, default true)
+  - seccomp-profile: RuntimeDefault (default) or Unconfined
+
+Every component is probed out of the box: mysql with "mysqladmin ping",
+wordpress with an HTTP GET on /wp-login.php.
+
+Pods meet the restricted Pod Security Standard: they run as the image's own user (mysql 999,
+www-data 33), drop every capability, can't escalate privileges, use the runtime's seccomp profile,
+have a read-only root filesystem and don't mount a service account token.
+
+WordPress-only Options:
+  - host          : Host the site is served at (default wordpress.localhost, with the namespace
+                    added outside the default namespace, e.g. wordpress.team-a.localhost)
+  - path          : Path the site is served under (default /)
+  - ingress-class : Ingress class to use (default the cluster's default class)
+  - tls-secret    : Existing TLS secret with the certificate for the host, serves the site over HTTPS
+  - service-type  : ClusterIP (default), NodePort or LoadBalancer
+  - service-port  : Port of the service (default 80)
+  - node-port     : Port on every node for NodePort and LoadBalancer services, 30000-32767 (default picked by the cluster)
+  - source-ranges : Space-separated CIDRs a LoadBalancer service accepts (e.g. "10.0.0.0/8 192.168.0.0/16")
+  - service-annotation.<name>: Annotation on the service, e.g. for the cloud provider's load balancer
+  - probe-path    : Path the HTTP probes request (default /wp-login.php)
+
+WordPress is exposed through an Ingress, and deploy prints the URL it is served at.
+On a cluster created with "tufin cluster", Traefik serves it on the host's ports 80 and 443.
 
 Examples:
   # Deploy WordPress with 2 replicas and MySQL with 3 replicas
@@ -44,8 +111,39 @@ Examples:
   tufin deploy --set wordpress.memory-request=1Gi,wordpress.volume-size=10Gi
 
   # Full deployment with multiple configurations
-  tufin deploy --set wordpress.replicas=2,wordpress.memory-request=1Gi,mysql.replicas=3,mysql.cpu-request=500m`,
-	Run: deployEntrypoint,
+  tufin deploy --set wordpress.replicas=2,wordpress.memory-request=1Gi,mysql.replicas=3,mysql.cpu-request=500m
+
+  # Deploy from a version-controlled values file, with a one-off override on top
+  tufin deploy -f values.yaml --set wordpress.replicas=3
+
+  # Deploy an isolated stack into its own namespace, creating it if needed
+  tufin deploy --namespace team-a
+
+  # Deploy a preview stack next to the main one, its objects are named preview-mysql and preview-wordpress
+  tufin deploy --release preview
+
+  # Deploy and fail unless everything is ready within 3 minutes, e.g. in CI
+  tufin deploy --wait --timeout 3m
+
+  # Print the manifests deploy would apply, e.g. to review an environment change
+  tufin deploy --dry-run -f values.yaml
+
+  # Check the manifests against the cluster without changing anything
+  tufin deploy --dry-run=server -f values.yaml
+
+  # Serve wordpress at a host of your own, over HTTPS
+  tufin deploy --set wordpress.host=blog.example.com,wordpress.tls-secret=blog-tls
+
+  # Expose wordpress directly through a load balancer on port 8080, e.g. k3d's servicelb
+  tufin deploy --set wordpress.service-type=LoadBalancer,wordpress.service-port=8080
+
+  # Tune mysql with a custom my.cnf
+  tufin deploy --set-file mysql.my.cnf=./my.cnf
+
+  # Redeploy and rotate the mysql password
+  tufin deploy --rotate-credentials`,
+	Annotations: map[string]string{usesClusterAnnotation: "true"},
+	Run:         deployEntrypoint,
 }
 
 func init() {
@@ -58,9 +156,35 @@ func init() {
   cpu-limit       - CPU limit (e.g. 500m, 1)
   memory-limit    - Memory limit (e.g. 512Mi, 2Gi)
   volume-size     - Volume size (e.g. 5Gi, 10Gi)
+  host            - Host wordpress is served at (e.g. blog.localhost)
+  path            - Path wordpress is served under (e.g. /)
+  ingress-class   - Ingress class for wordpress (e.g. traefik)
+  tls-secret      - TLS secret for wordpress' host (e.g. blog-tls)
+  service-type    - Service type for wordpress (ClusterIP, NodePort or LoadBalancer)
+  service-port    - Service port for wordpress (e.g. 8080)
+  node-port       - Node port for wordpress, 30000-32767 (e.g. 30080)
+  source-ranges   - CIDRs a wordpress LoadBalancer accepts, space-separated
+  service-annotation.<name> - Annotation on wordpress' service
+  <probe>-probe.<field> - Probe setting, e.g. readiness-probe.period-seconds=10 or liveness-probe.enabled=false
+  probe-path      - Path wordpress' HTTP probes request (e.g. /healthz)
+  run-as-user     - User id the pods run as (e.g. 1000)
+  run-as-group    - Group id the pods run as (e.g. 1000)
+  fs-group        - Group id that owns the volumes (e.g. 1000)
+  run-as-non-root - Refuse to run as root (true or false)
+  read-only-root-filesystem - Read-only root filesystem (true or false)
+  seccomp-profile - Seccomp profile (RuntimeDefault or Unconfined)
 
 Example: --set wordpress.replicas=2,wordpress.volume-size=1Gi,mysql.replicas=3
 `)
+	deployCmd.Flags().StringSliceP("values", "f", nil, "values file (YAML or JSON) with per-component options, can be repeated; --set overrides it")
+	deployCmd.Flags().StringSlice("set-file", nil, "config file for a component as component.file=path, e.g. mysql.my.cnf=./my.cnf, can be repeated; overrides the values file")
+	deployCmd.Flags().String("release", "", "release name prefixed to every object, allows several stacks per namespace")
+	deployCmd.Flags().Bool("force-conflicts", false, "take over fields that are managed by another tool instead of failing")
+	deployCmd.Flags().Bool("wait", false, "wait until the deployed pods are ready and fail if they don't get there")
+	deployCmd.Flags().Duration("timeout", deployments.DefaultTimeout, "how long to wait for dependencies, and with --wait for the deployment, to become ready")
+	deployCmd.Flags().Bool("rotate-credentials", false, "generate a new mysql password and change it inside the running database")
+	deployCmd.Flags().String("dry-run", "none", `"client" prints the manifests without applying them, "server" validates them with the API server without persisting`)
+	deployCmd.Flags().Lookup("dry-run").NoOptDefVal = "client"
 }
 
 func deployEntrypoint(cmd *cobra.Command, args []string) {
@@ -68,30 +192,85 @@ func deployEntrypoint(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	rotateCredentials, err := cmd.Flags().GetBool("rotate-credentials")
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+	forceConflicts, err := cmd.Flags().GetBool("force-conflicts")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wait, err := cmd.Flags().GetBool("wait")
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	componentOpts, err := ParseSetFlag(setValue)
+	dryRun, err := cmd.Flags().GetString("dry-run")
 	if err != nil {
 		log.Fatal(err)
 	}
+	if dryRun != "none" && dryRun != "client" && dryRun != "server" {
+		log.Fatalf(`invalid --dry-run value %q, must be "client" or "server"`, dryRun)
+	}
 
-	// Convert to configs slice
-	var deploymentConfigs []deployments.DeploymentConfig
-	for component, opts := range componentOpts {
-		deploymentConfigs = append(deploymentConfigs, deployments.DeploymentConfig{
-			Component: component,
-			Options:   opts,
-		})
+	valueFiles, err := cmd.Flags().GetStringSlice("values")
+	if err != nil {
+		log.Fatal(err)
+	}
+	setFiles, err := cmd.Flags().GetStringSlice("set-file")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deploymentConfigs, err := deploymentConfigs(valueFiles, setFiles, setValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := deployments.ShipOptions{
+		Namespace:         namespace,
+		Release:           release,
+		ForceConflicts:    forceConflicts,
+		Wait:              wait,
+		Timeout:           timeout,
+		RotateCredentials: rotateCredentials,
+		DryRun:            dryRun == "server",
+	}
+
+	if dryRun == "client" {
+		objs, err := deployments.Manifests(opts, deploymentConfigs...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := k8sapp.WriteManifests(cmd.OutOrStdout(), objs, true); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	msgs := make(chan string)
 	// the done channel signals to the main goroutine that the apps.Deploy() function has completed
 	// otherwise our program will continue trying to process messages from the apps.Deploy() function and panic
 	done := make(chan bool)
+	// shipErr is only read once done has been received, so there's no race on it
+	var shipErr error
 
 	go func() {
 		// FYI the k8sClient is initialized in the rootCmd.PersistentPreRun function
-		if err := deployments.Ship(msgs, k8sClient, deploymentConfigs...); err != nil {
+		if err := deployments.Ship(msgs, k8sClient, opts, deploymentConfigs...); err != nil {
+			shipErr = err
 			log.Println(err)
+			if apierrors.IsConflict(err) {
+				log.Println("some fields are managed by another tool, rerun with --force-conflicts to take them over")
+			}
 		}
 		done <- true
 	}()
@@ -102,32 +281,89 @@ func deployEntrypoint(cmd *cobra.Command, args []string) {
 			log.Println(msg)
 		case <-done:
 			close(msgs)
+			// a failed deploy has to fail the process, so CI pipelines notice
+			if shipErr != nil {
+				os.Exit(1)
+			}
 			return
 		}
 	}
 }
 
+// deploymentConfigs merges the values files, the --set-file files and the --set overrides into one config per component.
+// Values files apply in the order given, and --set-file and --set go on top of all of them.
+func deploymentConfigs(valueFiles, setFiles []string, setValue string) ([]deployments.DeploymentConfig, error) {
+	var components []string
+	componentOpts := make(map[string][]config.Option)
+	add := func(values map[string][]config.Option) {
+		for _, component := range deployments.Components() {
+			opts, ok := values[component]
+			if !ok {
+				continue
+			}
+			if _, seen := componentOpts[component]; !seen {
+				components = append(components, component)
+			}
+			componentOpts[component] = append(componentOpts[component], opts...)
+		}
+	}
+
+	for _, file := range valueFiles {
+		values, err := config.LoadValues(file, deployments.Components())
+		if err != nil {
+			return nil, err
+		}
+		add(values)
+	}
+
+	fileOpts, err := ParseSetFileFlag(setFiles)
+	if err != nil {
+		return nil, err
+	}
+	add(fileOpts)
+
+	setOpts, err := ParseSetFlag(setValue)
+	if err != nil {
+		return nil, err
+	}
+	add(setOpts)
+
+	var configs []deployments.DeploymentConfig
+	for _, component := range components {
+		configs = append(configs, deployments.DeploymentConfig{
+			Component: component,
+			Options:   componentOpts[component],
+		})
+	}
+	return configs, nil
+}
+
+// ParseSetFlag parses the --set value, a comma separated list of component.key=value pairs.
+// Every pair is checked up front: malformed pairs, unknown components or keys
+// and invalid values are reported instead of being skipped.
 func ParseSetFlag(setValue string) (map[string][]config.Option, error) {
 	componentOpts := make(map[string][]config.Option)
-	pairs := strings.Split(setValue, ",")
+	if strings.TrimSpace(setValue) == "" {
+		return componentOpts, nil
+	}
 
-	for _, pair := range pairs {
-		parts := strings.Split(pair, ".")
-		if len(parts) != 2 {
-			continue
+	for _, pair := range strings.Split(setValue, ",") {
+		pair = strings.TrimSpace(pair)
+
+		// split on the first "." and "=" only, values like 0.5 contain a dot themselves
+		target, value, hasValue := strings.Cut(pair, "=")
+		component, key, hasKey := strings.Cut(target, ".")
+		if !hasValue || !hasKey || component == "" || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid --set entry %q: expected component.key=value, e.g. wordpress.replicas=2", pair)
 		}
 
-		component := parts[0]
-		// Parse the key=value part
-		kvPair := strings.Split(parts[1], "=")
-		if len(kvPair) != 2 {
-			continue
+		if !slices.Contains(deployments.Components(), component) {
+			return nil, fmt.Errorf("invalid --set entry %q: %w", pair, config.UnknownComponentError(component, deployments.Components()))
 		}
 
-		key, value := kvPair[0], kvPair[1]
-		opt, err := parseOption(key, value)
+		opt, err := config.ParseOption(key, value)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid --set entry %q: %w", pair, err)
 		}
 		componentOpts[component] = append(componentOpts[component], opt)
 	}
@@ -135,26 +371,31 @@ func ParseSetFlag(setValue string) (map[string][]config.Option, error) {
 	return componentOpts, nil
 }
 
-func parseOption(key, value string) (config.Option, error) {
-	switch key {
-	case "replicas":
-		replicaInt, err := strconv.Atoi(value)
+// ParseSetFileFlag reads the files given with --set-file, each a component.file=path pair such as mysql.my.cnf=./my.cnf.
+// Whether the component supports the file is checked when its config is built.
+func ParseSetFileFlag(entries []string) (map[string][]config.Option, error) {
+	componentOpts := make(map[string][]config.Option)
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+
+		// file names like my.cnf contain a dot themselves, so only the first one separates the component
+		target, path, hasPath := strings.Cut(entry, "=")
+		component, file, hasFile := strings.Cut(target, ".")
+		if !hasPath || !hasFile || component == "" || file == "" || path == "" {
+			return nil, fmt.Errorf("invalid --set-file entry %q: expected component.file=path, e.g. mysql.my.cnf=./my.cnf", entry)
+		}
+
+		if !slices.Contains(deployments.Components(), component) {
+			return nil, fmt.Errorf("invalid --set-file entry %q: %w", entry, config.UnknownComponentError(component, deployments.Components()))
+		}
+
+		content, err := os.ReadFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("invalid value type for replicas: %s", value)
+			return nil, fmt.Errorf("invalid --set-file entry %q: %w", entry, err)
 		}
-		replicas := int32(replicaInt)
-		return config.WithReplicas(replicas), nil
-	case "cpu-request":
-		return config.WithCPURequest(value), nil
-	case "memory-request":
-		return config.WithMemoryRequest(value), nil
-	case "cpu-limit":
-		return config.WithCPULimit(value), nil
-	case "memory-limit":
-		return config.WithMemoryLimit(value), nil
-	case "volume-size":
-		return config.WithVolumeSize(value), nil
-	default:
-		return nil, fmt.Errorf("invalid option: %s", key)
+		componentOpts[component] = append(componentOpts[component], config.WithFile(file, string(content)))
 	}
+
+	return componentOpts, nil
 }
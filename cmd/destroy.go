@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Kol Ratner kolratner@gmail.com
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kol-ratner/tufin/internal/deployments"
+)
+
+// destroyCmd represents the destroy command
+var destroyCmd = &cobra.Command{
+	Use:   "destroy [component...]",
+	Short: "Remove applications deployed by tufin from kubernetes",
+	Long: `Remove the WordPress and MySQL resources created by the deploy command.
+
+The destroy command deletes the workload (wordpress' Deployment, mysql's StatefulSet), Service,
+Secret and persistent volume claims of each component, in the reverse order they were created. When no component is given,
+both wordpress and mysql are removed.
+
+Available Components:
+  - wordpress
+  - mysql
+
+Examples:
+  # Remove everything tufin deployed
+  tufin destroy
+
+  # Remove only wordpress, without asking for confirmation
+  tufin destroy wordpress --yes
+
+  # Remove everything but keep the persistent volume claims and their data
+  tufin destroy --keep-data
+
+  # Remove the stack deployed with --release preview
+  tufin destroy --release preview`,
+	Annotations: map[string]string{usesClusterAnnotation: "true"},
+	Run:         destroyEntrypoint,
+}
+
+func init() {
+	rootCmd.AddCommand(destroyCmd)
+
+	destroyCmd.Flags().String("release", "", "release name the components were deployed under")
+	destroyCmd.Flags().Bool("keep-data", false, "preserve persistent volume claims and the data they hold")
+	destroyCmd.Flags().BoolP("yes", "y", false, "skip the confirmation prompt")
+}
+
+func destroyEntrypoint(cmd *cobra.Command, args []string) {
+	keepData, err := cmd.Flags().GetBool("keep-data")
+	if err != nil {
+		log.Fatal(err)
+	}
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !yes {
+		target := "wordpress and mysql"
+		if len(args) > 0 {
+			target = strings.Join(args, ", ")
+		}
+		if release != "" {
+			target = fmt.Sprintf("%s of release %s", target, release)
+		}
+		if !confirm(cmd, fmt.Sprintf("This will permanently delete %s. Continue?", target)) {
+			log.Println("aborted")
+			return
+		}
+	}
+
+	msgs := make(chan string)
+	// the done channel signals to the main goroutine that the deployments.Destroy() function has completed
+	// otherwise our program will continue trying to process messages from the deployments.Destroy() function and panic
+	done := make(chan bool)
+
+	go func() {
+		// FYI the k8sClient is initialized in the rootCmd.PersistentPreRun function
+		opts := deployments.DestroyOptions{
+			Namespace: namespace,
+			Release:   release,
+			KeepData:  keepData,
+		}
+		if err := deployments.Destroy(msgs, k8sClient, opts, args...); err != nil {
+			log.Println(err)
+		}
+		done <- true
+	}()
+
+	for {
+		select {
+		case msg := <-msgs:
+			log.Println(msg)
+		case <-done:
+			close(msgs)
+			return
+		}
+	}
+}
+
+// confirm asks the user a yes/no question on the command's input and
+// reports whether they answered yes. Anything other than y/yes is a no.
+func confirm(cmd *cobra.Command, question string) bool {
+	fmt.Fprintf(cmd.OutOrStdout(), "%s [y/N]: ", question)
+
+	answer, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 Kol Ratner kolratner@gmail.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/spf13/cobra"
+
+	"github.com/kol-ratner/tufin/internal/deployments"
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what deploy would change in the cluster",
+	Long: `Compare the objects deploy would apply with the ones running in the cluster.
+
+The diff command builds the desired Deployment, PersistentVolumeClaim, Service and Secret of each
+component from the same --set and -f/--values options deploy takes, and prints a unified diff
+against the live objects. Lines starting with - are live, lines starting with + are what deploy would set.
+
+Only the fields deploy sets are compared. Status, server-managed metadata, defaults and fields
+owned by other controllers are ignored, and secret values are never printed.
+
+The exit status is 0 when the cluster matches, 1 when there are differences and 2 on errors,
+so CI pipelines can gate on drift.
+
+Examples:
+  # Show what a redeploy with the defaults would change
+  tufin diff
+
+  # Check an environment's values file against the cluster, e.g. in CI
+  tufin diff -f values.yaml --no-color
+
+  # Preview a scaling change
+  tufin diff --set wordpress.replicas=3`,
+	Annotations: map[string]string{usesClusterAnnotation: "true"},
+	Run:         diffEntrypoint,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().String("set", "", "component options as comma-separated component.key=value pairs, like deploy --set")
+	diffCmd.Flags().StringSliceP("values", "f", nil, "values file (YAML or JSON) with per-component options, can be repeated; --set overrides it")
+	diffCmd.Flags().StringSlice("set-file", nil, "config file for a component as component.file=path, like deploy --set-file")
+	diffCmd.Flags().String("release", "", "release name the components are deployed under")
+	diffCmd.Flags().Bool("no-color", false, "print the diff without colors")
+}
+
+func diffEntrypoint(cmd *cobra.Command, args []string) {
+	setValue, err := cmd.Flags().GetString("set")
+	if err != nil {
+		log.Fatal(err)
+	}
+	valueFiles, err := cmd.Flags().GetStringSlice("values")
+	if err != nil {
+		log.Fatal(err)
+	}
+	setFiles, err := cmd.Flags().GetStringSlice("set-file")
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+	noColor, err := cmd.Flags().GetBool("no-color")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if noColor {
+		text.DisableColors()
+	}
+
+	// exit code 1 means drift, so errors have to exit with something else
+	fail := func(err error) {
+		log.Println(err)
+		os.Exit(2)
+	}
+
+	deploymentConfigs, err := deploymentConfigs(valueFiles, setFiles, setValue)
+	if err != nil {
+		fail(err)
+	}
+
+	// FYI the k8sClient is initialized in the rootCmd.PersistentPreRun function
+	if k8sClient == nil {
+		fail(fmt.Errorf("diff needs a connection to the cluster"))
+	}
+
+	opts := deployments.ShipOptions{
+		Namespace: namespace,
+		Release:   release,
+	}
+	diffs, err := deployments.Diff(k8sClient, opts, deploymentConfigs...)
+	if err != nil {
+		fail(err)
+	}
+
+	if len(diffs) == 0 {
+		log.Println("no differences, the cluster matches the desired state")
+		return
+	}
+
+	printDiffs(cmd.OutOrStdout(), diffs)
+	os.Exit(1)
+}
+
+// printDiffs writes the diffs to w, colored like git diff
+func printDiffs(w io.Writer, diffs []k8sapp.ObjectDiff) {
+	for _, d := range diffs {
+		for _, line := range strings.Split(strings.TrimSuffix(d.Diff, "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+				line = text.Bold.Sprint(line)
+			case strings.HasPrefix(line, "@@"):
+				line = text.FgCyan.Sprint(line)
+			case strings.HasPrefix(line, "-"):
+				line = text.FgRed.Sprint(line)
+			case strings.HasPrefix(line, "+"):
+				line = text.FgGreen.Sprint(line)
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
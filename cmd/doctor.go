@@ -0,0 +1,152 @@
+/*
+Copyright © 2024 Kol Ratner kolratner@gmail.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/kol-ratner/tufin/internal/cluster"
+	"github.com/kol-ratner/tufin/internal/deployments"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that this machine can run a local cluster with WordPress and MySQL",
+	Long: `Run the checks "tufin cluster create" and "tufin up" run before creating a cluster, and report
+what to do about the ones that fail:
+
+  container runtime  Docker, or another runtime serving its API, is running and can be used
+  ports              the host ports the cluster would publish are free
+  memory             the runtime has the memory the nodes and the stack's requests need
+  disk               there's room for the images and the stack's volumes
+  kubeconfig         the cluster's kubeconfig can be written
+
+A cluster that exists already has its ports to itself, so they're not checked for it.
+doctor takes the topology flags of "tufin cluster create", and the --set, --values, --set-file and
+--release flags of "tufin deploy" to size the stack; the default stack is checked without them.
+It exits with status 1 when a check failed, warnings only mean something couldn't be checked.
+
+Examples:
+  # Check the machine before the first tufin up
+  tufin doctor
+
+  # Check there's room for three MySQL replicas, and that port 8080 is free
+  tufin doctor --set mysql.replicas=3 --port 8080:80@loadbalancer
+
+  # Report the checks as JSON
+  tufin doctor -o json`,
+	Args: cobra.NoArgs,
+	Run:  doctorEntrypoint,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().String("name", "", `name of the cluster (default "k3s-default", or the name in --config)`)
+	addClusterCreateFlags(doctorCmd)
+	doctorCmd.Flags().String("set", "", "component options as comma-separated component.key=value pairs, see tufin deploy --help")
+	doctorCmd.Flags().StringSliceP("values", "f", nil, "values file (YAML or JSON) with per-component options, can be repeated; --set overrides it")
+	doctorCmd.Flags().StringSlice("set-file", nil, "config file for a component as component.file=path, e.g. mysql.my.cnf=./my.cnf, can be repeated")
+	doctorCmd.Flags().String("release", "", "release name prefixed to every object, allows several stacks per namespace")
+	doctorCmd.Flags().StringP("output", "o", "table", "output format: table, json or yaml")
+}
+
+func doctorEntrypoint(cmd *cobra.Command, args []string) {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if output != "table" && !slices.Contains(clusterOutputFormats, output) {
+		log.Fatalf("unsupported output format %q, use table, json or yaml", output)
+	}
+
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts, err := clusterCreateOptions(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Name = name
+	if opts.Kubeconfig, err = clusterKubeconfig(cmd); err != nil {
+		log.Fatal(err)
+	}
+
+	setValue, err := cmd.Flags().GetString("set")
+	if err != nil {
+		log.Fatal(err)
+	}
+	valueFiles, err := cmd.Flags().GetStringSlice("values")
+	if err != nil {
+		log.Fatal(err)
+	}
+	setFiles, err := cmd.Flags().GetStringSlice("set-file")
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+	configs, err := deploymentConfigs(valueFiles, setFiles, setValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.Requirements, err = stackRequirements(deployments.ShipOptions{Release: release}, configs...); err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := cluster.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	checks, err := c.Preflight(opts)
+	if err != nil {
+		log.Println(err)
+		c.Close()
+		os.Exit(1)
+	}
+
+	if output == "table" {
+		writeChecks(cmd.OutOrStdout(), checks)
+	} else if err := writeStructured(cmd.OutOrStdout(), output, checks); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, check := range checks {
+		if check.Status == cluster.CheckFailed {
+			// os.Exit skips the deferred Close, which removes the k3d binary
+			c.Close()
+			os.Exit(1)
+		}
+	}
+}
+
+// writeChecks writes the checks as a table, followed by what to do about those that didn't pass
+func writeChecks(w io.Writer, checks []cluster.Check) {
+	t := table.NewWriter()
+	t.SetOutputMirror(w)
+	t.AppendHeader(table.Row{"CHECK", "STATUS", "MESSAGE"})
+	for _, check := range checks {
+		t.AppendRow(table.Row{check.Name, check.Status, check.Message})
+	}
+	t.Render()
+
+	for _, check := range checks {
+		if check.Remedy != "" {
+			fmt.Fprintf(w, "\n%s: %s\n", check.Name, check.Remedy)
+		}
+	}
+}
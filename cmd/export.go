@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Kol Ratner kolratner@gmail.com
+*/
+package cmd
+
+import (
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kol-ratner/tufin/internal/deployments"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the manifests as plain YAML, a Helm chart or a Kustomize base",
+	Long: `Write the objects deploy would apply to a directory, for clusters that are managed through GitOps.
+
+The export command takes the same --set and -f/--values options as deploy and renders them
+without contacting the cluster, in one of these formats:
+
+  yaml       one multi-document manifest per component, e.g. mysql.yaml
+  helm       a chart whose values.yaml uses the same per-component keys as a values file,
+             so it can be passed back to tufin with -f. The templates keep the credentials
+             of an existing release and generate new ones on the first install.
+             Object names are fixed to --release, so the chart installs once per namespace.
+  kustomize  a base/ with the manifests and an overlays/<namespace>/ that deploys it into the
+             target namespace. Credentials come from a secretGenerator reading .env files,
+             which are git-ignored.
+
+The yaml format and the kustomize .env files contain the generated MySQL password,
+don't commit them without encrypting them first.
+
+Examples:
+  # Export a Helm chart for the preview release
+  tufin export --format helm --release preview -o charts/
+
+  # Export a kustomize base and a team-a overlay from a values file
+  tufin export --format kustomize -f values.yaml -n team-a -o deploy/
+
+  # Export plain manifests
+  tufin export -o manifests/`,
+	Run: exportEntrypoint,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().String("format", string(deployments.ExportYAML), "output format, one of: "+strings.Join(deployments.ExportFormats(), ", "))
+	exportCmd.Flags().StringP("output", "o", "", "directory to write the exported files to")
+	exportCmd.Flags().String("set", "", "component options as comma-separated component.key=value pairs, like deploy --set")
+	exportCmd.Flags().StringSliceP("values", "f", nil, "values file (YAML or JSON) with per-component options, can be repeated; --set overrides it")
+	exportCmd.Flags().StringSlice("set-file", nil, "config file for a component as component.file=path, like deploy --set-file")
+	exportCmd.Flags().String("release", "", "release name prefixed to every object, like deploy --release")
+	_ = exportCmd.MarkFlagRequired("output")
+}
+
+func exportEntrypoint(cmd *cobra.Command, args []string) {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		log.Fatal(err)
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatal(err)
+	}
+	setValue, err := cmd.Flags().GetString("set")
+	if err != nil {
+		log.Fatal(err)
+	}
+	valueFiles, err := cmd.Flags().GetStringSlice("values")
+	if err != nil {
+		log.Fatal(err)
+	}
+	setFiles, err := cmd.Flags().GetStringSlice("set-file")
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deploymentConfigs, err := deploymentConfigs(valueFiles, setFiles, setValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := deployments.ShipOptions{
+		Namespace: namespace,
+		Release:   release,
+	}
+	files, err := deployments.Export(deployments.ExportFormat(format), output, opts, deploymentConfigs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		log.Printf("wrote %s", file)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright © 2024 Kol Ratner kolratner@gmail.com
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kol-ratner/tufin/internal/deployments"
+)
+
+// portForwardCmd represents the port-forward command
+var portForwardCmd = &cobra.Command{
+	Use:   "port-forward [wordpress|mysql]",
+	Short: "Forward a local port to a WordPress or MySQL pod",
+	Long: `Forward a local port to a ready pod of a deployed component, through the Kubernetes API server.
+
+The pod is picked by the labels deploy gives it, so there's no need to look up pod names.
+When the pod is replaced, by a rollout or because it was deleted, the forward moves over
+to a new ready pod on the same local port. It runs until interrupted with Ctrl+C.
+MySQL is always forwarded to its primary, the pod ending in -0, as the replicas are read-only.
+
+WordPress is forwarded to local port 8080 and MySQL to 3306, unless --port says otherwise.
+When no component is given, wordpress is forwarded.
+
+Examples:
+  # Reach WordPress at http://localhost:8080
+  tufin port-forward
+
+  # Reach WordPress on another port, and open it in the browser
+  tufin port-forward wordpress --port 9000 --open
+
+  # Reach MySQL at localhost:3306
+  tufin port-forward mysql
+
+  # Reach the MySQL of the stack deployed with --release preview
+  tufin port-forward mysql --release preview --port 3307`,
+	Args:        cobra.MaximumNArgs(1),
+	ValidArgs:   deployments.Components(),
+	Annotations: map[string]string{usesClusterAnnotation: "true"},
+	Run:         portForwardEntrypoint,
+}
+
+// openCmd represents the open command
+var openCmd = &cobra.Command{
+	Use:   "open",
+	Short: "Open WordPress in the browser through a port forward",
+	Long: `Forward a local port to WordPress and open it in the default browser.
+
+This is port-forward wordpress --open: the forward keeps running, and follows pod
+replacements, until interrupted with Ctrl+C.
+
+Examples:
+  # Open WordPress at http://localhost:8080
+  tufin open
+
+  # Open the WordPress of the stack deployed with --release preview
+  tufin open --release preview --port 9000`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{usesClusterAnnotation: "true"},
+	Run:         portForwardEntrypoint,
+}
+
+func init() {
+	rootCmd.AddCommand(portForwardCmd)
+	rootCmd.AddCommand(openCmd)
+
+	for _, c := range []*cobra.Command{portForwardCmd, openCmd} {
+		c.Flags().IntP("port", "p", 0, "local port to listen on (defaults to 8080 for wordpress, 3306 for mysql)")
+		c.Flags().String("address", "localhost", "local address to listen on")
+		c.Flags().String("release", "", "release name the component was deployed under")
+	}
+	portForwardCmd.Flags().Bool("open", false, "open the forwarded address in the browser (wordpress only)")
+}
+
+func portForwardEntrypoint(cmd *cobra.Command, args []string) {
+	port, err := cmd.Flags().GetInt("port")
+	if err != nil {
+		log.Fatal(err)
+	}
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	component := "wordpress"
+	if len(args) > 0 {
+		component = args[0]
+	}
+	// open is port-forward wordpress --open, so it has no --open flag of its own
+	open := cmd.Name() == "open"
+	if !open {
+		if open, err = cmd.Flags().GetBool("open"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if open && component != "wordpress" {
+		log.Fatalf("--open only works for wordpress, %s isn't served over HTTP", component)
+	}
+	if port < 0 || port > 65535 {
+		log.Fatalf("invalid port %d: must be between 1 and 65535", port)
+	}
+
+	// FYI the k8sClient is initialized in the rootCmd.PersistentPreRun function
+	if k8sClient == nil {
+		log.Fatal("port forwarding needs a connection to the cluster")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the browser opens once, reconnecting to a replaced pod keeps the same address
+	var once sync.Once
+	opts := deployments.ForwardOptions{
+		Namespace: namespace,
+		Release:   release,
+		LocalPort: port,
+		Address:   address,
+		Ready: func(addr string) {
+			once.Do(func() {
+				log.Printf("%s is available at %s, press Ctrl+C to stop", component, addr)
+				if open {
+					if err := openBrowser(addr); err != nil {
+						log.Printf("failed to open the browser, visit %s instead: %v", addr, err)
+					}
+				}
+			})
+		},
+	}
+
+	msgs := make(chan string)
+	// the done channel signals to the main goroutine that the deployments.Forward() function has completed
+	// otherwise our program will continue trying to process messages from the deployments.Forward() function and panic
+	done := make(chan bool)
+	var forwardErr error
+
+	go func() {
+		if err := deployments.Forward(ctx, msgs, k8sClient, component, opts); err != nil {
+			forwardErr = err
+			log.Println(err)
+		}
+		done <- true
+	}()
+
+	for {
+		select {
+		case msg := <-msgs:
+			log.Println(msg)
+		case <-done:
+			close(msgs)
+			if forwardErr != nil {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+}
+
+// openBrowser opens url in the user's default browser
+func openBrowser(url string) error {
+	var c *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		c = exec.Command("open", url)
+	case "windows":
+		c = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		c = exec.Command("xdg-open", url)
+	}
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("%s: %w", c.Path, err)
+	}
+	// don't leave a zombie behind while the forward keeps running
+	go func() {
+		_ = c.Wait()
+	}()
+	return nil
+}
@@ -10,9 +10,14 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// usesClusterAnnotation marks the commands that work on the cluster of the current kubeconfig context,
+// they print which context that is before they start
+const usesClusterAnnotation = "tufin/uses-cluster"
+
 var (
 	k8sClient      *k8s.Client
 	kubeconfigPath string
+	namespace      string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -20,6 +25,22 @@ var rootCmd = &cobra.Command{
 	Use:   "tufin",
 	Short: "Kubernetes deployment tool for WordPress and MySQL applications",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// an explicit --kubeconfigPath wins, then the private kubeconfig tufin cluster create may have written
+		if kubeconfigPath == "" {
+			kubeconfigPath = k8s.DefaultKubeConfigPath()
+		}
+
+		// an explicit --namespace wins, otherwise we follow the kubeconfig's current context.
+		// This is resolved even without a working client, commands like deploy --dry-run don't need one
+		if namespace == "" {
+			if ns, err := k8s.GetNamespaceFromHost(kubeconfigPath); err == nil {
+				namespace = ns
+			}
+		}
+		if namespace == "" {
+			namespace = "default"
+		}
+
 		kconf, err := k8s.GetKubeConfigFromHost(kubeconfigPath)
 		if err != nil {
 			cmd.PrintErrf("failed to fetch kubeconfig: %v\n", err)
@@ -31,6 +52,13 @@ var rootCmd = &cobra.Command{
 			return
 		}
 		k8sClient = client
+
+		// say which cluster we're about to work on, so nobody deploys to the wrong one by accident
+		if cmd.Annotations[usesClusterAnnotation] == "true" {
+			if context, err := k8s.GetContextFromHost(kubeconfigPath); err == nil {
+				cmd.PrintErrf("Using context %s (namespace %s) from %s\n", context, namespace, kubeconfigPath)
+			}
+		}
 	},
 	Long: `Tufin is a powerful CLI tool for deploying and managing WordPress and MySQL on Kubernetes.
 
@@ -40,13 +68,30 @@ Key Features:
   - Real-time deployment status monitoring
 
 Core Commands:
-  deploy    Deploy applications with custom configurations
-  status    Monitor deployment health and status
-  cluster   Manage Kubernetes cluster settings
+  up            Create a local cluster and deploy WordPress and MySQL to it, in one go
+  down          Delete, or stop, the local cluster again
+  deploy        Deploy applications with custom configurations
+  diff          Show what deploy would change in the cluster
+  export        Export the manifests as plain YAML, a Helm chart or a Kustomize base
+  destroy       Remove deployed applications and their resources
+  status        Monitor deployment health and status
+  port-forward  Forward a local port to WordPress or MySQL
+  open          Open WordPress in the browser through a port forward
+  cluster       Create, list, start, stop and delete local k3d clusters
+  doctor        Check that this machine can run a local cluster with the stack
+
+Every command that talks to the cluster uses the current context of the kubeconfig given by --kubeconfigPath,
+falling back to the private kubeconfig "tufin cluster create --private-kubeconfig" writes, once there is one,
+and then to ~/.kube/config. It prints the context it uses before it starts.
+It works in the namespace given by --namespace, falling back to the namespace of that context, and then to "default".
 
 Getting started:
-  tufin cluster
-  tufin deploy --set wordpress.replicas=2
+  tufin up --set wordpress.replicas=2
+  tufin status
+
+Or one step at a time:
+  tufin cluster create
+  tufin deploy --set wordpress.replicas=2 --wait
   tufin status`,
 }
 
@@ -60,5 +105,6 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.PersistentFlags().String("kubeconfigPath", "", "path to kubeconfig file")
+	rootCmd.PersistentFlags().StringVar(&kubeconfigPath, "kubeconfigPath", "", "path to kubeconfig file (defaults to tufin's private kubeconfig if there is one, or ~/.kube/config)")
+	rootCmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "namespace to operate in (defaults to the kubeconfig context's namespace, or \"default\")")
 }
@@ -25,8 +25,12 @@ Examples:
   tufin status
 
   # View detailed resource usage
-  tufin status`,
-	Run: statusEntrypoint,
+  tufin status
+
+  # Get status of the deployments in another namespace
+  tufin status --namespace team-a`,
+	Annotations: map[string]string{usesClusterAnnotation: "true"},
+	Run:         statusEntrypoint,
 }
 
 func init() {
@@ -41,7 +45,7 @@ func statusEntrypoint(cmd *cobra.Command, args []string) {
 
 	go func() {
 		// FYI the k8sClient is initialized in the rootCmd.PersistentPreRun function
-		if err := reporting.Status(msgs, k8sClient); err != nil {
+		if err := reporting.Status(msgs, k8sClient, namespace); err != nil {
 			log.Println(err)
 		}
 		done <- true
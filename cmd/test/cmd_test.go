@@ -1,6 +1,9 @@
 package cmd_test
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/kol-ratner/tufin/cmd"
@@ -30,6 +33,13 @@ func TestParseSetFlag(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "value with a dot",
+			setValue: "mysql.cpu-limit=0.5",
+			want: map[string][]config.Option{
+				"mysql": {config.WithCPULimit("0.5")},
+			},
+		},
 		{
 			name:     "multiple components",
 			setValue: "wordpress.replicas=2,mysql.replicas=3",
@@ -42,7 +52,13 @@ func TestParseSetFlag(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _ := cmd.ParseSetFlag(tt.setValue)
+			got, err := cmd.ParseSetFlag(tt.setValue)
+			if err != nil {
+				t.Fatalf("ParseSetFlag() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got options for %d components, want %d", len(got), len(tt.want))
+			}
 			// We'll need to compare the results by applying the options and checking the resulting overrides
 			for component, options := range got {
 				wantOptions := tt.want[component]
@@ -53,3 +69,101 @@ func TestParseSetFlag(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSetFlag_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		setValue  string
+		wantError string
+	}{
+		{
+			name:      "missing value",
+			setValue:  "wordpress.replicas",
+			wantError: `invalid --set entry "wordpress.replicas": expected component.key=value, e.g. wordpress.replicas=2`,
+		},
+		{
+			name:      "missing component",
+			setValue:  "replicas=2",
+			wantError: `invalid --set entry "replicas=2": expected component.key=value, e.g. wordpress.replicas=2`,
+		},
+		{
+			name:      "empty pair",
+			setValue:  "wordpress.replicas=2,,mysql.replicas=1",
+			wantError: `invalid --set entry "": expected component.key=value, e.g. wordpress.replicas=2`,
+		},
+		{
+			name:      "unknown component",
+			setValue:  "wordpres.replicas=2",
+			wantError: `invalid --set entry "wordpres.replicas=2": unknown component "wordpres", did you mean "wordpress"?`,
+		},
+		{
+			name:      "unknown key",
+			setValue:  "mysql.replica=2",
+			wantError: `invalid --set entry "mysql.replica=2": unknown option "replica", did you mean "replicas"?`,
+		},
+		{
+			name:      "invalid quantity",
+			setValue:  "mysql.memory-limit=2GB",
+			wantError: `invalid --set entry "mysql.memory-limit=2GB": invalid quantity for memory-limit: "2GB" (expected e.g. 500m, 1, 256Mi or 1Gi)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cmd.ParseSetFlag(tt.setValue)
+			if err == nil || err.Error() != tt.wantError {
+				t.Errorf("ParseSetFlag() error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestParseSetFileFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tuning.cnf")
+	if err := os.WriteFile(path, []byte("[mysqld]\nmax_connections=500\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cmd.ParseSetFileFlag([]string{"mysql.my.cnf=" + path})
+	if err != nil {
+		t.Fatalf("ParseSetFileFlag() error = %v", err)
+	}
+	overrides := config.DeploymentOverrides{}
+	for _, opt := range got["mysql"] {
+		opt(&overrides)
+	}
+	if want := map[string]string{"my.cnf": "[mysqld]\nmax_connections=500\n"}; !reflect.DeepEqual(overrides.Files, want) {
+		t.Errorf("ParseSetFileFlag() files = %v, want %v", overrides.Files, want)
+	}
+
+	errTests := []struct {
+		name      string
+		entry     string
+		wantError string
+	}{
+		{
+			name:      "missing path",
+			entry:     "mysql.my.cnf",
+			wantError: `invalid --set-file entry "mysql.my.cnf": expected component.file=path, e.g. mysql.my.cnf=./my.cnf`,
+		},
+		{
+			name:      "unknown component",
+			entry:     "mysq.my.cnf=" + path,
+			wantError: `invalid --set-file entry "mysq.my.cnf=` + path + `": unknown component "mysq", did you mean "mysql"?`,
+		},
+		{
+			name:      "missing file",
+			entry:     "mysql.my.cnf=./does-not-exist.cnf",
+			wantError: `invalid --set-file entry "mysql.my.cnf=./does-not-exist.cnf": open ./does-not-exist.cnf: no such file or directory`,
+		},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cmd.ParseSetFileFlag([]string{tt.entry})
+			if err == nil || err.Error() != tt.wantError {
+				t.Errorf("ParseSetFileFlag() error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright © 2024 Kol Ratner kolratner@gmail.com
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kol-ratner/tufin/internal/cluster"
+	"github.com/kol-ratner/tufin/internal/deployments"
+	"github.com/kol-ratner/tufin/pkg/k8s"
+)
+
+// upCmd represents the up command
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Create a local cluster and deploy WordPress and MySQL to it",
+	Long: `Create a local k3d cluster, or start it if it exists already, and deploy WordPress and MySQL to it,
+in one go. This is the same as running these, each waiting for the one before to be done:
+
+  tufin cluster create
+  tufin deploy --wait
+
+up waits for the cluster's API server to answer and its nodes to be ready before deploying,
+then for mysql and wordpress to be ready, and prints where WordPress is served and the
+MySQL credentials at the end. It fails if any of it doesn't happen within --timeout.
+
+The cluster's kubeconfig is written as by "tufin cluster create", and up deploys to that cluster
+whatever the kubeconfig's current context was before. up takes the topology flags of
+"tufin cluster create" and the --set, --values, --set-file and --release flags of "tufin deploy".
+Running up again is safe: the cluster and the deployment are left as they are, or brought up to date.
+
+Use "tufin down" to delete the cluster again, or "tufin down --stop" to stop it until the next up.
+
+Examples:
+  # Start the day
+  tufin up
+
+  # Three agents to spread MySQL's replicas over, WordPress on http://wordpress.localhost:8080
+  tufin up --agents 3 --port 8080:80@loadbalancer --set mysql.replicas=3
+
+  # A stack from a values file, with a cluster kept out of ~/.kube/config
+  tufin up -f values.yaml --private-kubeconfig`,
+	Args: cobra.NoArgs,
+	Run:  upEntrypoint,
+}
+
+// downCmd represents the down command
+var downCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Delete, or stop, the local cluster tufin up created",
+	Long: `Delete the local k3d cluster tufin up created, together with everything deployed to it.
+
+With --stop the cluster is stopped instead: everything deployed to it, the MySQL data included,
+is kept, and the next "tufin up" starts it again.
+
+Examples:
+  # End the day, keeping the stack for tomorrow
+  tufin down --stop
+
+  # Start over from scratch, without asking for confirmation
+  tufin down --yes`,
+	Args: cobra.NoArgs,
+	Run:  downEntrypoint,
+}
+
+func init() {
+	rootCmd.AddCommand(upCmd, downCmd)
+
+	upCmd.Flags().String("name", "", `name of the cluster (default "k3s-default", or the name in --config)`)
+	addClusterCreateFlags(upCmd)
+	upCmd.Flags().String("set", "", "component options as comma-separated component.key=value pairs, see tufin deploy --help")
+	upCmd.Flags().StringSliceP("values", "f", nil, "values file (YAML or JSON) with per-component options, can be repeated; --set overrides it")
+	upCmd.Flags().StringSlice("set-file", nil, "config file for a component as component.file=path, e.g. mysql.my.cnf=./my.cnf, can be repeated")
+	upCmd.Flags().String("release", "", "release name prefixed to every object, allows several stacks per namespace")
+	upCmd.Flags().Duration("timeout", deployments.DefaultTimeout, "how long to wait for the cluster, and then for the deployment, to become ready")
+
+	downCmd.Flags().String("name", "", `name of the cluster (default "k3s-default")`)
+	downCmd.Flags().Bool("stop", false, "stop the cluster instead of deleting it, keeping everything deployed to it")
+	downCmd.Flags().BoolP("yes", "y", false, "skip the confirmation prompt")
+}
+
+func upEntrypoint(cmd *cobra.Command, args []string) {
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		log.Fatal(err)
+	}
+	createOpts, err := clusterCreateOptions(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	createOpts.Name = name
+	if createOpts.Kubeconfig, err = clusterKubeconfig(cmd); err != nil {
+		log.Fatal(err)
+	}
+
+	setValue, err := cmd.Flags().GetString("set")
+	if err != nil {
+		log.Fatal(err)
+	}
+	valueFiles, err := cmd.Flags().GetStringSlice("values")
+	if err != nil {
+		log.Fatal(err)
+	}
+	setFiles, err := cmd.Flags().GetStringSlice("set-file")
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := cmd.Flags().GetString("release")
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	configs, err := deploymentConfigs(valueFiles, setFiles, setValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shipOpts := deployments.ShipOptions{
+		Release: release,
+		Wait:    true,
+		Timeout: timeout,
+	}
+	// an explicit --namespace wins, the one resolved from the kubeconfig belongs to the context before up
+	if cmd.Flags().Changed("namespace") {
+		shipOpts.Namespace = namespace
+	}
+	// invalid options shouldn't cost a cluster creation to find out about, and the preflight makes room for the stack
+	if createOpts.Requirements, err = stackRequirements(shipOpts, configs...); err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := cluster.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	msgs := make(chan string)
+	// the done channel signals to the main goroutine that bringing the stack up has completed
+	// otherwise our program will continue trying to process messages from it and panic
+	done := make(chan bool)
+	// access and upErr are only read once done has been received, so there's no race on them
+	var access deployments.Access
+	var upErr error
+
+	go func() {
+		access, upErr = up(c, msgs, createOpts, shipOpts, configs)
+		if upErr != nil {
+			log.Println(upErr)
+		}
+		done <- true
+	}()
+
+	for {
+		select {
+		case msg := <-msgs:
+			log.Println(msg)
+		case <-done:
+			close(msgs)
+			if upErr != nil {
+				// os.Exit skips the deferred Close, which removes the k3d binary
+				c.Close()
+				os.Exit(1)
+			}
+			writeAccess(cmd.OutOrStdout(), access, createOpts)
+			return
+		}
+	}
+}
+
+// up creates or starts the cluster, waits for it, and deploys the stack to it
+func up(c *cluster.Client, msgs chan<- string, createOpts cluster.CreateOptions, shipOpts deployments.ShipOptions, configs []deployments.DeploymentConfig) (deployments.Access, error) {
+	result, err := c.Create(msgs, createOpts)
+	if err != nil {
+		return deployments.Access{}, err
+	}
+	if result.Cluster != nil && result.Cluster.Status() != "running" {
+		if _, err := c.Start(msgs, result.Name); err != nil {
+			return deployments.Access{}, err
+		}
+	}
+
+	// the client the root command built follows the context from before, the cluster's own is in the kubeconfig just written
+	kconf, err := k8s.GetKubeConfigFromHost(result.Kubeconfig)
+	if err != nil {
+		return deployments.Access{}, err
+	}
+	cli, err := k8s.NewClient(kconf)
+	if err != nil {
+		return deployments.Access{}, err
+	}
+	if shipOpts.Namespace == "" {
+		if ns, err := k8s.GetNamespaceFromHost(result.Kubeconfig); err == nil {
+			shipOpts.Namespace = ns
+		}
+	}
+
+	timeout := shipOpts.Timeout
+	if timeout <= 0 {
+		timeout = deployments.DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	msgs <- fmt.Sprintf("waiting for cluster %s to be ready", result.Name)
+	if err := k8s.WaitForCluster(ctx, msgs, cli); err != nil {
+		return deployments.Access{}, fmt.Errorf("cluster %s: %w", result.Name, err)
+	}
+
+	if err := deployments.Ship(msgs, cli, shipOpts, configs...); err != nil {
+		return deployments.Access{}, err
+	}
+	return deployments.GetAccess(cli, shipOpts, configs...)
+}
+
+// writeAccess tells where the stack is served and how to log in to its database
+func writeAccess(w io.Writer, access deployments.Access, createOpts cluster.CreateOptions) {
+	fmt.Fprintln(w)
+	switch site := siteURL(access.URL, createOpts); {
+	case access.URL == "":
+		fmt.Fprintln(w, "WordPress is ready, it isn't exposed through an ingress: reach it with tufin open")
+	case site == "":
+		fmt.Fprintf(w, "WordPress is ready, the cluster doesn't publish %s on the host: reach it with tufin open\n", access.URL)
+	default:
+		fmt.Fprintf(w, "WordPress is ready at %s\n", site)
+		fmt.Fprintln(w, "  finish the installation there to create the admin account")
+	}
+
+	fmt.Fprintf(w, "MySQL is reachable in the cluster at %s, and locally with tufin port-forward mysql\n", access.DatabaseHost)
+	fmt.Fprintf(w, "  database: %s\n", access.Database)
+	fmt.Fprintf(w, "  user:     %s\n", access.User)
+	fmt.Fprintf(w, "  password: %s\n", access.Password)
+}
+
+// siteURL puts the host port the cluster publishes the ingress on into its URL,
+// it's empty when the cluster doesn't publish the port at all
+func siteURL(ingressURL string, createOpts cluster.CreateOptions) string {
+	u, err := url.Parse(ingressURL)
+	if err != nil {
+		return ingressURL
+	}
+	// a config file may map ports of its own, which we don't know about
+	if createOpts.Ports == nil && createOpts.ConfigFile != "" {
+		return ingressURL
+	}
+
+	port, defaultPort := 80, 80
+	if u.Scheme == "https" {
+		port, defaultPort = 443, 443
+	}
+	hostPort := createOpts.HostPort(port)
+	switch hostPort {
+	case 0:
+		return ""
+	case defaultPort:
+		return ingressURL
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(hostPort))
+	return u.String()
+}
+
+func downEntrypoint(cmd *cobra.Command, args []string) {
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		log.Fatal(err)
+	}
+	stop, err := cmd.Flags().GetBool("stop")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if stop {
+		runClusterOperation(cmd, "", func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+			return c.Stop(msgs, name)
+		})
+		return
+	}
+
+	if !confirmClusterDelete(cmd, name) {
+		log.Println("aborted")
+		return
+	}
+	runClusterOperation(cmd, "", func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+		return deleteCluster(c, msgs, name)
+	})
+}
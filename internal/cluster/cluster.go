@@ -2,8 +2,8 @@ package cluster
 
 import (
 	"bufio"
+	"bytes"
 	_ "embed"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 //go:embed bin/k3d-darwin-arm64
@@ -25,6 +26,14 @@ var k3dLinuxAmd64 []byte
 //go:embed bin/k3d-linux-arm64
 var k3dLinuxArm64 []byte
 
+var (
+	// ansiRegex matches the color codes k3d's logger wraps the log levels in
+	ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	infoRegex = regexp.MustCompile(`^INFO\[\d+\]\s+(.+)$`)
+	// fatalRegex matches k3d's fatal errors on any line of its output
+	fatalRegex = regexp.MustCompile(`(?m)^FATA\[\d+\]\s+(.+)$`)
+)
+
 type k3dHostInfo struct {
 	os   string
 	arch string
@@ -79,61 +88,40 @@ func getK3d() (*k3dHostInfo, error) {
 // strip the formatting of the k3d logger - this gives me complete control over the log output for this application
 func parseK3dOutput(line string) string {
 	// Strip ANSI color codes
-	var stripAnsi *regexp.Regexp = regexp.MustCompile(`\x1b\[[0-9;]*m`)
-	cleanLine := stripAnsi.ReplaceAllString(line, "")
-
-	var infoRegex *regexp.Regexp = regexp.MustCompile(`^INFO\[\d+\]\s+(.+)$`)
-	var fataRegex *regexp.Regexp = regexp.MustCompile(`^FATA\[\d+\]\s+(.+)$`)
+	cleanLine := ansiRegex.ReplaceAllString(line, "")
 
 	if matches := infoRegex.FindStringSubmatch(cleanLine); matches != nil {
 		return matches[1]
 	}
 
-	if matches := fataRegex.FindStringSubmatch(cleanLine); matches != nil {
+	if matches := fatalRegex.FindStringSubmatch(cleanLine); matches != nil {
 		return matches[1]
 	}
 	// Default to stdout for other messages
 	return line
 }
 
-// clusterExists checks if a k3d cluster exists
-func clusterExists(bin, clusterName string) (bool, error) {
-	cmd := exec.Command(bin, "cluster", "list", "-o", "json")
+// embeddedK3d runs the k3d binary getK3d wrote to disk
+type embeddedK3d struct {
+	bin string
+}
+
+func (k *embeddedK3d) Output(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(k.bin, args...)
+	cmd.Stderr = &stderr
 	output, err := cmd.Output()
 	if err != nil {
-		return false, err
-	}
-
-	var clusters []struct{ Name string }
-	if err := json.Unmarshal(output, &clusters); err != nil {
-		return false, err
-	}
-
-	for _, c := range clusters {
-		if c.Name == clusterName {
-			return true, nil
+		if msg := lastFatal(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("k3d %s: %s", strings.Join(args[:min(2, len(args))], " "), msg)
 		}
+		return nil, err
 	}
-	return false, nil
+	return output, nil
 }
 
-// create creates a k3d cluster
-func Create(msgChan chan<- string) error {
-	k3d, err := getK3d()
-	if err != nil {
-		return err
-	}
-	defer os.Remove(k3d.bin.Name())
-	msgChan <- fmt.Sprintf("Detected OS: %s, ARCH: %s", k3d.os, k3d.arch)
-
-	if exists, err := clusterExists(k3d.bin.Name(), "k3s-default"); err != nil {
-		return err
-	} else if exists {
-		msgChan <- "cluster already exists, skipping creation"
-		return nil
-	}
-
-	command := exec.Command(k3d.bin.Name(), "cluster", "create")
+func (k *embeddedK3d) Stream(msgChan chan<- string, keep func(line string) bool, args ...string) error {
+	command := exec.Command(k.bin, args...)
 	stdout, err := command.StdoutPipe()
 	if err != nil {
 		return err
@@ -144,28 +132,31 @@ func Create(msgChan chan<- string) error {
 	}
 
 	if err := command.Start(); err != nil {
-		// defer close(msgChan)
 		return err
 	}
 
 	// writing to the channel via these goroutines is necessary
 	// because of how exec.Command's StdoutPipe() and StderrPipe() work..
-	// they need to be continuously read to prevent the command from blocking
+	// they need to be continuously read to prevent the command from blocking,
+	// and read to the end before Wait closes them
+	var wg sync.WaitGroup
+	var fatal string
+	wg.Add(2)
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
 			text := scanner.Text()
 
 			// only sending specific status updates
-			if strings.Contains(text, "Starting cluster") {
-				msgChan <- parseK3dOutput(text)
-			} else if strings.Contains(text, "created successfully") {
+			if keep(text) {
 				msgChan <- parseK3dOutput(text)
 			}
 		}
 	}()
 
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
 			text := scanner.Text()
@@ -174,8 +165,27 @@ func Create(msgChan chan<- string) error {
 			if strings.Contains(text, "Failed") {
 				msgChan <- parseK3dOutput(text)
 			}
+			if msg := lastFatal(text); msg != "" {
+				fatal = msg
+			}
 		}
 	}()
+	wg.Wait()
 
-	return command.Wait()
+	if err := command.Wait(); err != nil {
+		if fatal != "" {
+			return fmt.Errorf("k3d %s: %s", strings.Join(args[:min(2, len(args))], " "), fatal)
+		}
+		return err
+	}
+	return nil
+}
+
+// lastFatal returns the message of the last fatal error k3d logged in output, if any
+func lastFatal(output string) string {
+	matches := fatalRegex.FindAllStringSubmatch(ansiRegex.ReplaceAllString(output, ""), -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1][1]
 }
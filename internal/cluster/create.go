@@ -0,0 +1,256 @@
+package cluster
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultPorts publish Traefik, k3s' bundled ingress controller, on the host's HTTP and HTTPS ports
+// through the load balancer in front of the cluster, so ingresses are reachable at their host name.
+var DefaultPorts = []string{"80:80@loadbalancer", "443:443@loadbalancer"}
+
+// configAPIVersion and configKind identify the k3d config files Create accepts
+const (
+	configAPIVersion = "k3d.io/v1alpha5"
+	configKind       = "Simple"
+)
+
+// CreateOptions controls how Create sets up a cluster.
+// Everything but the name is optional and left to k3d's defaults, or to the config file, when unset.
+type CreateOptions struct {
+	// Name of the cluster. Empty takes the name from the config file, and then DefaultName.
+	Name string
+
+	// ConfigFile is a k3d.io/v1alpha5 Simple config file. The other options are applied on top of it.
+	ConfigFile string
+
+	// Servers run the control plane, and Agents only workloads. Zero leaves them to k3d, one server and no agents.
+	Servers int
+	Agents  int
+
+	// Image is the k3s image the nodes run, e.g. rancher/k3s:v1.31.2-k3s1, which picks the Kubernetes version.
+	Image string
+
+	// Ports map host ports into the cluster, in k3d's [host:]hostPort:containerPort[/protocol][@nodefilter] format.
+	// Nil means DefaultPorts, unless the config file maps ports of its own.
+	Ports []string
+
+	// Volumes mount host paths into the nodes, in k3d's source:destination[@nodefilter] format.
+	Volumes []string
+
+	// K3sArgs are extra k3s arguments, in k3d's arg[@nodefilter] format, e.g. --disable=traefik@server:*.
+	K3sArgs []string
+
+	// RegistryMirrors maps a registry, e.g. docker.io, to the endpoint of its mirror.
+	RegistryMirrors map[string]string
+
+	// Kubeconfig is the kubeconfig the cluster's credentials are merged into, switching its current context to the cluster.
+	// This happens for a cluster that exists already too. Empty leaves it to k3d, which updates ~/.kube/config.
+	Kubeconfig string
+
+	// APIPort is the host port the API server is published on. Zero leaves it to the config file, and then to k3d,
+	// which picks a free one.
+	APIPort int
+
+	// Requirements are what the stack deployed to the cluster will ask for, the preflight checks the host can take it.
+	Requirements Requirements
+
+	// SkipPreflight creates the cluster without checking the runtime and host first.
+	SkipPreflight bool
+}
+
+// configFile is the part of a k3d Simple config file Create needs to know about,
+// the file itself is passed to k3d as is
+type configFile struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Servers int `json:"servers"`
+	Agents  int `json:"agents"`
+	KubeAPI struct {
+		HostPort string `json:"hostPort"`
+	} `json:"kubeAPI"`
+	Ports []struct {
+		Port string `json:"port"`
+	} `json:"ports"`
+}
+
+var (
+	// portPattern matches k3d's port mappings, e.g. 8080:80@loadbalancer or 127.0.0.1:6445:6443/tcp
+	portPattern = regexp.MustCompile(`^(?:[^:@]+:)?(\d+)(?:-\d+)?:(\d+)(?:-\d+)?(?:/(?:tcp|udp|sctp))?(?:@([\w:*;,-]+))?$`)
+	// nodeFilterPattern matches the node filter a volume or k3s argument may end in, e.g. @server:0 or @agent:*
+	nodeFilterPattern = regexp.MustCompile(`@(?:servers?|agents?|loadbalancer|all)(?::[\d*,-]+)?(?:;[\w:*,-]+)*$`)
+)
+
+// validate checks the options before any of them reaches k3d, whose errors are harder to act on
+func (o *CreateOptions) validate() error {
+	if o.Servers < 0 || o.Agents < 0 {
+		return fmt.Errorf("server and agent counts must not be negative, got %d servers and %d agents", o.Servers, o.Agents)
+	}
+	if o.APIPort < 0 || o.APIPort > 65535 {
+		return fmt.Errorf("invalid API port %d: ports must be between 1 and 65535", o.APIPort)
+	}
+	for _, p := range o.Ports {
+		m := portPattern.FindStringSubmatch(p)
+		if m == nil {
+			return fmt.Errorf("invalid port mapping %q (expected e.g. 8080:80@loadbalancer)", p)
+		}
+		for _, port := range m[1:3] {
+			if n, _ := strconv.Atoi(port); n < 1 || n > 65535 {
+				return fmt.Errorf("invalid port mapping %q: ports must be between 1 and 65535", p)
+			}
+		}
+	}
+	for _, v := range o.Volumes {
+		spec := nodeFilterPattern.ReplaceAllString(v, "")
+		if src, dest, ok := strings.Cut(spec, ":"); !ok || src == "" || !strings.HasPrefix(dest, "/") {
+			return fmt.Errorf("invalid volume %q (expected source:/destination, optionally followed by a node filter like @server:0)", v)
+		}
+	}
+	for _, a := range o.K3sArgs {
+		if !strings.HasPrefix(a, "-") {
+			return fmt.Errorf("invalid k3s argument %q (expected a flag, e.g. --disable=traefik@server:*)", a)
+		}
+	}
+	for registry, endpoint := range o.RegistryMirrors {
+		if registry == "" {
+			return fmt.Errorf("registry mirror %s has no registry name", endpoint)
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid mirror endpoint for %s: %q (expected e.g. https://mirror.example.com)", registry, endpoint)
+		}
+	}
+	return nil
+}
+
+// HostPort returns the host port the options publish containerPort of the load balancer on, zero when they don't.
+// Without ports given that's DefaultPorts, ports mapped in the config file aren't looked at.
+func (o CreateOptions) HostPort(containerPort int) int {
+	ports := o.Ports
+	if ports == nil {
+		ports = DefaultPorts
+	}
+	for _, p := range ports {
+		m := portPattern.FindStringSubmatch(p)
+		// k3d maps ports without a node filter on the load balancer too
+		if m == nil || (m[3] != "" && m[3] != "loadbalancer") {
+			continue
+		}
+		if container, _ := strconv.Atoi(m[2]); container == containerPort {
+			host, _ := strconv.Atoi(m[1])
+			return host
+		}
+	}
+	return 0
+}
+
+// loadConfigFile reads the parts of the k3d config file at path we need, after checking it's a format we know
+func loadConfigFile(path string) (configFile, error) {
+	var cfg configFile
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read the cluster config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.APIVersion != configAPIVersion || cfg.Kind != configKind {
+		return cfg, fmt.Errorf("%s: unsupported cluster config %s %s, expected apiVersion %s and kind %s",
+			path, cfg.APIVersion, cfg.Kind, configAPIVersion, configKind)
+	}
+	return cfg, nil
+}
+
+// createArgs translates the options into the arguments of k3d cluster create.
+// Flags k3d merges with the config file, so they add to or override what the file sets.
+// cleanup removes the files written for k3d, and has to be called once it's done.
+func createArgs(name string, opts CreateOptions, file configFile) ([]string, func(), error) {
+	cleanup := func() {}
+	args := []string{"cluster", "create", name}
+
+	if opts.ConfigFile != "" {
+		args = append(args, "--config", opts.ConfigFile)
+	}
+	if opts.Servers > 0 {
+		args = append(args, "--servers", strconv.Itoa(opts.Servers))
+	}
+	if opts.Agents > 0 {
+		args = append(args, "--agents", strconv.Itoa(opts.Agents))
+	}
+	if opts.Image != "" {
+		args = append(args, "--image", opts.Image)
+	}
+	if opts.APIPort > 0 {
+		args = append(args, "--api-port", strconv.Itoa(opts.APIPort))
+	}
+
+	ports := opts.Ports
+	if ports == nil && len(file.Ports) == 0 {
+		ports = DefaultPorts
+	}
+	for _, p := range ports {
+		args = append(args, "--port", p)
+	}
+	for _, v := range opts.Volumes {
+		args = append(args, "--volume", v)
+	}
+	// we write the kubeconfig ourselves, which keeps ~/.kube/config untouched when it's not the one asked for
+	if opts.Kubeconfig != "" {
+		args = append(args, "--kubeconfig-update-default=false", "--kubeconfig-switch-context=false")
+	}
+	for _, a := range opts.K3sArgs {
+		// k3d takes the argument as the flag's value, so it has to be attached with =
+		args = append(args, "--k3s-arg="+a)
+	}
+
+	if len(opts.RegistryMirrors) > 0 {
+		path, err := writeRegistries(opts.RegistryMirrors)
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() { os.Remove(path) }
+		args = append(args, "--registry-config", path)
+	}
+
+	return args, cleanup, nil
+}
+
+// writeRegistries writes a k3s registries.yaml pointing every registry at its mirror, and returns its path
+func writeRegistries(mirrors map[string]string) (string, error) {
+	registries := make([]string, 0, len(mirrors))
+	for registry := range mirrors {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+
+	var b strings.Builder
+	b.WriteString("mirrors:\n")
+	for _, registry := range registries {
+		fmt.Fprintf(&b, "  %q:\n    endpoint:\n      - %q\n", registry, mirrors[registry])
+	}
+
+	f, err := os.CreateTemp("", "tufin-registries-*.yaml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(b.String()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package cluster
+
+import "errors"
+
+// freeDisk isn't implemented on Windows, where the runtime keeps its data in a virtual machine anyway
+func freeDisk(path string) (uint64, error) {
+	return 0, errors.New("checking free disk space isn't supported on Windows")
+}
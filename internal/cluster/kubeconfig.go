@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/kol-ratner/tufin/pkg/k8s"
+)
+
+// ContextName is the name k3d gives the kubeconfig context, cluster and user of the cluster called name
+func ContextName(name string) string {
+	return "k3d-" + name
+}
+
+// WriteKubeconfig merges the kubeconfig of the cluster called name into the kubeconfig at path,
+// creating the file when needed, and switches its current context to the cluster. It returns that context.
+func (c *Client) WriteKubeconfig(msgChan chan<- string, name, path string) (string, error) {
+	name, err := clusterName(name)
+	if err != nil {
+		return "", err
+	}
+
+	kubeconfig, err := c.k3d.Output("kubeconfig", "get", name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the kubeconfig of cluster %s: %w", name, err)
+	}
+	context, err := k8s.MergeKubeConfig(path, kubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to write the kubeconfig of cluster %s to %s: %w", name, path, err)
+	}
+
+	msgChan <- fmt.Sprintf("wrote the kubeconfig of cluster %s to %s, its current context is now %s", name, path, context)
+	return context, nil
+}
@@ -0,0 +1,346 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultName is the cluster k3d manages when no name is given
+const DefaultName = "k3s-default"
+
+// maxNameLength is the longest cluster name k3d accepts, it prefixes the names of the cluster's containers
+const maxNameLength = 32
+
+// Runner runs k3d with the given arguments.
+type Runner interface {
+	// Output runs k3d to completion and returns what it wrote to stdout.
+	Output(args ...string) ([]byte, error)
+
+	// Stream runs k3d to completion, sending the log lines keep accepts to msgChan as they're written.
+	Stream(msgChan chan<- string, keep func(line string) bool, args ...string) error
+}
+
+// Client manages k3d clusters.
+type Client struct {
+	k3d Runner
+
+	// host describes the platform the embedded binary was picked for, it's empty for other runners
+	host string
+	// cleanup removes the embedded binary from disk
+	cleanup func()
+
+	// runtime and machine are what Create checks before creating a cluster, there's no preflight without them
+	runtime Runtime
+	machine Host
+}
+
+// NewClient returns a Client that runs the k3d binary embedded for the host's platform.
+// Close removes the binary again.
+func NewClient() (*Client, error) {
+	k3d, err := getK3d()
+	if err != nil {
+		return nil, err
+	}
+	bin := k3d.bin.Name()
+
+	return &Client{
+		k3d:     &embeddedK3d{bin: bin},
+		host:    fmt.Sprintf("OS: %s, ARCH: %s", k3d.os, k3d.arch),
+		cleanup: func() { os.Remove(bin) },
+		runtime: newDockerRuntime(),
+		machine: localHost{},
+	}, nil
+}
+
+// NewClientWithRunner returns a Client that runs k3d through r, e.g. a fake in tests.
+// It skips the preflight checks, unless WithPreflight gives it something to check.
+func NewClientWithRunner(r Runner) *Client {
+	return &Client{k3d: r}
+}
+
+// Close releases what the client holds on to.
+func (c *Client) Close() {
+	if c.cleanup != nil {
+		c.cleanup()
+	}
+}
+
+// Info describes a k3d cluster, as reported by k3d cluster list.
+type Info struct {
+	Name            string `json:"name"`
+	Servers         int    `json:"servers"`
+	ServersRunning  int    `json:"serversRunning"`
+	Agents          int    `json:"agents"`
+	AgentsRunning   int    `json:"agentsRunning"`
+	HasLoadBalancer bool   `json:"hasLoadBalancer"`
+}
+
+// Status sums up the state of the cluster's nodes: running, stopped, or degraded when only some of them run.
+func (i Info) Status() string {
+	running := i.ServersRunning + i.AgentsRunning
+	switch {
+	case running == 0:
+		return "stopped"
+	case running == i.Servers+i.Agents:
+		return "running"
+	default:
+		return "degraded"
+	}
+}
+
+// MarshalJSON adds the status to the node counts, so structured output carries it too
+func (i Info) MarshalJSON() ([]byte, error) {
+	type info Info
+	return json.Marshal(struct {
+		info
+		Status string `json:"status"`
+	}{info(i), i.Status()})
+}
+
+// Result reports what an operation did to a cluster.
+type Result struct {
+	Name string `json:"name"`
+
+	// Action is what was asked for: create, delete, start or stop
+	Action string `json:"action"`
+
+	// Changed is false when the cluster was already in the state asked for
+	Changed bool `json:"changed"`
+
+	// Cluster is the cluster's state once the operation is done, nil when it doesn't exist
+	Cluster *Info `json:"cluster,omitempty"`
+
+	// Kubeconfig and Context are where the cluster's credentials were written to, when they were
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+}
+
+// List returns every k3d cluster on the host.
+func (c *Client) List() ([]Info, error) {
+	output, err := c.k3d.Output("cluster", "list", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	// the subset of k3d's cluster type we report
+	var clusters []struct {
+		Name            string `json:"name"`
+		ServersCount    int    `json:"serversCount"`
+		ServersRunning  int    `json:"serversRunning"`
+		AgentsCount     int    `json:"agentsCount"`
+		AgentsRunning   int    `json:"agentsRunning"`
+		HasLoadBalancer bool   `json:"hasLoadbalancer"`
+	}
+	if err := json.Unmarshal(output, &clusters); err != nil {
+		return nil, fmt.Errorf("failed to parse the k3d cluster list: %w", err)
+	}
+
+	infos := make([]Info, 0, len(clusters))
+	for _, cl := range clusters {
+		infos = append(infos, Info{
+			Name:            cl.Name,
+			Servers:         cl.ServersCount,
+			ServersRunning:  cl.ServersRunning,
+			Agents:          cl.AgentsCount,
+			AgentsRunning:   cl.AgentsRunning,
+			HasLoadBalancer: cl.HasLoadBalancer,
+		})
+	}
+	return infos, nil
+}
+
+// Get returns the cluster called name, or nil if there is no such cluster.
+func (c *Client) Get(name string) (*Info, error) {
+	clusters, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, cl := range clusters {
+		if cl.Name == name {
+			return &cl, nil
+		}
+	}
+	return nil, nil
+}
+
+// Create creates a k3d cluster, unless one of that name exists already.
+// Before creating one it runs the preflight checks, failing with a PreflightError when the host can't take the cluster.
+func (c *Client) Create(msgChan chan<- string, opts CreateOptions) (Result, error) {
+	if err := opts.validate(); err != nil {
+		return Result{}, err
+	}
+	fileConfig, err := loadConfigFile(opts.ConfigFile)
+	if err != nil {
+		return Result{}, err
+	}
+	// the name given wins over the one in the config file
+	name := opts.Name
+	if name == "" {
+		name = fileConfig.Metadata.Name
+	}
+	name, err = clusterName(name)
+	if err != nil {
+		return Result{}, err
+	}
+	if c.host != "" {
+		msgChan <- fmt.Sprintf("Detected %s", c.host)
+	}
+
+	// k3d fails obscurely without a runtime, so that's checked before asking it about the cluster
+	preflight := !opts.SkipPreflight && c.runtime != nil && c.machine != nil
+	var runtimeCheck Check
+	var info RuntimeInfo
+	if preflight {
+		runtimeCheck, info = c.checkRuntime()
+		if runtimeCheck.Status == CheckFailed {
+			return Result{}, &PreflightError{Checks: []Check{runtimeCheck}}
+		}
+	}
+
+	existing, err := c.Get(name)
+	if err != nil {
+		return Result{}, err
+	}
+	if existing != nil {
+		msgChan <- fmt.Sprintf("cluster %s already exists, skipping creation", name)
+		return c.withKubeconfig(msgChan, Result{Name: name, Action: "create", Cluster: existing}, opts.Kubeconfig)
+	}
+
+	if preflight {
+		msgChan <- "running preflight checks"
+		checks := c.preflight(opts, fileConfig, runtimeCheck, info, nil)
+		for _, check := range checks {
+			if check.Status == CheckWarning {
+				msgChan <- fmt.Sprintf("preflight warning: %s: %s", check.Name, check.Message)
+			}
+		}
+		if failed := failedChecks(checks); len(failed) > 0 {
+			return Result{}, &PreflightError{Checks: failed}
+		}
+	}
+
+	args, cleanup, err := createArgs(name, opts, fileConfig)
+	if err != nil {
+		return Result{}, err
+	}
+	defer cleanup()
+
+	keep := func(line string) bool {
+		return strings.Contains(line, "Starting cluster") || strings.Contains(line, "created successfully")
+	}
+	if err := c.k3d.Stream(msgChan, keep, args...); err != nil {
+		return Result{}, err
+	}
+	result, err := c.result(name, "create")
+	if err != nil {
+		return Result{}, err
+	}
+	return c.withKubeconfig(msgChan, result, opts.Kubeconfig)
+}
+
+// withKubeconfig writes the kubeconfig of the cluster the result is about to path, unless path is empty
+func (c *Client) withKubeconfig(msgChan chan<- string, result Result, path string) (Result, error) {
+	if path == "" {
+		return result, nil
+	}
+	context, err := c.WriteKubeconfig(msgChan, result.Name, path)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Kubeconfig, result.Context = path, context
+	return result, nil
+}
+
+// Delete deletes the k3d cluster called name, together with its containers, network and volumes.
+// Deleting a cluster that doesn't exist is not an error.
+func (c *Client) Delete(msgChan chan<- string, name string) (Result, error) {
+	name, err := clusterName(name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	existing, err := c.Get(name)
+	if err != nil {
+		return Result{}, err
+	}
+	if existing == nil {
+		msgChan <- fmt.Sprintf("cluster %s doesn't exist, nothing to delete", name)
+		return Result{Name: name, Action: "delete"}, nil
+	}
+
+	if err := c.k3d.Stream(msgChan, isInfo, "cluster", "delete", name); err != nil {
+		return Result{}, err
+	}
+	return Result{Name: name, Action: "delete", Changed: true}, nil
+}
+
+// Start starts the nodes of the stopped k3d cluster called name.
+func (c *Client) Start(msgChan chan<- string, name string) (Result, error) {
+	return c.setRunning(msgChan, name, "start", true)
+}
+
+// Stop stops the nodes of the k3d cluster called name, keeping its state for a later Start.
+func (c *Client) Stop(msgChan chan<- string, name string) (Result, error) {
+	return c.setRunning(msgChan, name, "stop", false)
+}
+
+func (c *Client) setRunning(msgChan chan<- string, name, action string, running bool) (Result, error) {
+	name, err := clusterName(name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	existing, err := c.Get(name)
+	if err != nil {
+		return Result{}, err
+	}
+	if existing == nil {
+		return Result{}, fmt.Errorf("cluster %s doesn't exist, create it with tufin cluster create --name %s", name, name)
+	}
+	// a degraded cluster is started or stopped again, k3d takes care of the nodes that are already there
+	want := "stopped"
+	if running {
+		want = "running"
+	}
+	if existing.Status() == want {
+		msgChan <- fmt.Sprintf("cluster %s is already %s", name, want)
+		return Result{Name: name, Action: action, Cluster: existing}, nil
+	}
+
+	if err := c.k3d.Stream(msgChan, isInfo, "cluster", action, name); err != nil {
+		return Result{}, err
+	}
+	return c.result(name, action)
+}
+
+// result reports the cluster's state after an operation changed it
+func (c *Client) result(name, action string) (Result, error) {
+	info, err := c.Get(name)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Name: name, Action: action, Changed: true, Cluster: info}, nil
+}
+
+// isInfo accepts the informational lines of k3d's log
+func isInfo(line string) bool {
+	return strings.Contains(line, "INFO")
+}
+
+// clusterName checks that name can be used for a k3d cluster, empty means DefaultName
+func clusterName(name string) (string, error) {
+	if name == "" {
+		return DefaultName, nil
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid cluster name %q: %s", name, strings.Join(errs, ", "))
+	}
+	if len(name) > maxNameLength {
+		return "", fmt.Errorf("invalid cluster name %q: must be no more than %d characters", name, maxNameLength)
+	}
+	return name, nil
+}
@@ -0,0 +1,312 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	// nodeMemory is what k3s itself takes on every node, on top of the workloads' requests
+	nodeMemory = resource.MustParse("512Mi")
+	// imageDisk leaves room for the k3s, mysql and wordpress images, and the nodes' own state
+	imageDisk = resource.MustParse("3Gi")
+)
+
+// CheckStatus is the outcome of a preflight check.
+type CheckStatus string
+
+const (
+	CheckPassed  CheckStatus = "ok"
+	CheckWarning CheckStatus = "warning"
+	CheckFailed  CheckStatus = "failed"
+)
+
+// Check is the outcome of one preflight check, with what to do about it when it didn't pass.
+type Check struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+	Remedy  string      `json:"remedy,omitempty"`
+}
+
+// Requirements are what the workloads deployed to the cluster ask for, the preflight checks the host has room for them.
+type Requirements struct {
+	Memory  resource.Quantity
+	Storage resource.Quantity
+}
+
+// PreflightError is returned by Create when a preflight check failed, it carries every check that did.
+type PreflightError struct {
+	Checks []Check
+}
+
+func (e *PreflightError) Error() string {
+	var b strings.Builder
+	b.WriteString("preflight checks failed, fix these or rerun with --skip-preflight:")
+	for _, check := range e.Checks {
+		fmt.Fprintf(&b, "\n  %s: %s", check.Name, check.Message)
+		if check.Remedy != "" {
+			fmt.Fprintf(&b, "\n    %s", check.Remedy)
+		}
+	}
+	return b.String()
+}
+
+// WithPreflight has Create check the runtime and host before creating a cluster, NewClient sets up the real ones.
+func (c *Client) WithPreflight(rt Runtime, host Host) *Client {
+	c.runtime, c.machine = rt, host
+	return c
+}
+
+// Preflight checks whether Create could set up a cluster with the options: whether the container runtime answers,
+// the host ports are free, there's enough memory and disk for the nodes and the requirements,
+// and the kubeconfig can be written. A cluster that exists already has its ports to itself.
+func (c *Client) Preflight(opts CreateOptions) ([]Check, error) {
+	if c.runtime == nil || c.machine == nil {
+		return nil, errors.New("preflight checks need a container runtime and a host to check")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	file, err := loadConfigFile(opts.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	name := opts.Name
+	if name == "" {
+		name = file.Metadata.Name
+	}
+	if name, err = clusterName(name); err != nil {
+		return nil, err
+	}
+
+	runtimeCheck, info := c.checkRuntime()
+	var existing *Info
+	if runtimeCheck.Status != CheckFailed {
+		// k3d itself needs the runtime to list the clusters
+		if existing, err = c.Get(name); err != nil {
+			return nil, err
+		}
+	}
+	return c.preflight(opts, file, runtimeCheck, info, existing), nil
+}
+
+// preflight runs the checks that follow the runtime's
+func (c *Client) preflight(opts CreateOptions, file configFile, runtimeCheck Check, info RuntimeInfo, existing *Info) []Check {
+	checks := []Check{runtimeCheck}
+	runtimeUp := runtimeCheck.Status == CheckPassed
+
+	if existing != nil {
+		checks = append(checks, Check{
+			Name:    "ports",
+			Status:  CheckPassed,
+			Message: fmt.Sprintf("cluster %s exists already, the ports are its own", existing.Name),
+		})
+	} else {
+		for _, port := range hostPorts(opts, file) {
+			checks = append(checks, c.checkPort(port))
+		}
+	}
+
+	if runtimeUp {
+		checks = append(checks, checkMemory(opts, file, info))
+		checks = append(checks, c.checkDisk(opts, info))
+	}
+	if opts.Kubeconfig != "" {
+		checks = append(checks, c.checkKubeconfig(opts.Kubeconfig))
+	}
+	return checks
+}
+
+// failedChecks returns the checks that failed
+func failedChecks(checks []Check) []Check {
+	var failed []Check
+	for _, check := range checks {
+		if check.Status == CheckFailed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+func (c *Client) checkRuntime() (Check, RuntimeInfo) {
+	check := Check{Name: "container runtime"}
+	socket := c.runtime.Socket()
+
+	info, err := c.runtime.Info()
+	switch {
+	case err == nil:
+		check.Status = CheckPassed
+		check.Message = fmt.Sprintf("version %s at %s, %d CPUs and %s of memory", info.Version, socket, info.CPUs, formatBytes(info.Memory))
+	case errors.Is(err, os.ErrPermission):
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("no permission to use %s", socket)
+		check.Remedy = "add your user to the docker group with: sudo usermod -aG docker $USER, then log out and back in"
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, syscall.ECONNREFUSED):
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("nothing is running at %s", socket)
+		check.Remedy = "start Docker: open Docker Desktop, or run sudo systemctl start docker; or point DOCKER_HOST at a running runtime"
+	case errors.Is(err, ErrRuntimeUnhealthy):
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("%s isn't healthy: %v", socket, err)
+		check.Remedy = "check that Docker is running and healthy, e.g. with docker info, and restart it if it isn't"
+	default:
+		// k3d may well reach a runtime we can't, over ssh or TLS for instance, so it's left to k3d
+		check.Status = CheckWarning
+		check.Message = fmt.Sprintf("couldn't check the container runtime at %s: %v", socket, err)
+	}
+	return check, info
+}
+
+func (c *Client) checkPort(port int) Check {
+	check := Check{Name: fmt.Sprintf("port %d", port)}
+
+	inUse, err := c.machine.PortInUse(port)
+	switch {
+	case err != nil:
+		check.Status = CheckWarning
+		check.Message = fmt.Sprintf("couldn't check whether port %d is free: %v", port, err)
+	case inUse:
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("port %d is in use by another program", port)
+		check.Remedy = fmt.Sprintf("stop what listens on it (lsof -i :%d shows what), or map another host port, e.g. --port 8080:80@loadbalancer or --api-port 6550", port)
+	default:
+		check.Status = CheckPassed
+		check.Message = fmt.Sprintf("port %d is free", port)
+	}
+	return check
+}
+
+func checkMemory(opts CreateOptions, file configFile, info RuntimeInfo) Check {
+	check := Check{Name: "memory"}
+	if info.Memory == 0 {
+		check.Status = CheckWarning
+		check.Message = "the container runtime doesn't report its memory"
+		return check
+	}
+
+	servers, agents := nodeCounts(opts, file)
+	needed := opts.Requirements.Memory.DeepCopy()
+	for i := 0; i < servers+agents; i++ {
+		needed.Add(nodeMemory)
+	}
+
+	if uint64(needed.Value()) > info.Memory {
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("%s needed for %d nodes and the deployment's requests, the container runtime has %s",
+			formatBytes(uint64(needed.Value())), servers+agents, formatBytes(info.Memory))
+		check.Remedy = "give the runtime more memory (Docker Desktop: Settings > Resources), or lower the requests, " +
+			"e.g. --set mysql.memory-request=512Mi, or run fewer replicas or agents"
+		return check
+	}
+	check.Status = CheckPassed
+	check.Message = fmt.Sprintf("%s needed, %s available", formatBytes(uint64(needed.Value())), formatBytes(info.Memory))
+	return check
+}
+
+func (c *Client) checkDisk(opts CreateOptions, info RuntimeInfo) Check {
+	check := Check{Name: "disk"}
+
+	needed := opts.Requirements.Storage.DeepCopy()
+	needed.Add(imageDisk)
+
+	free, err := c.machine.FreeDisk(info.DataRoot)
+	if err != nil {
+		// Docker Desktop keeps its data root inside its virtual machine
+		check.Status = CheckWarning
+		check.Message = fmt.Sprintf("couldn't check the free space at %s, make sure %s are free: %v", info.DataRoot, formatBytes(uint64(needed.Value())), err)
+		return check
+	}
+
+	if uint64(needed.Value()) > free {
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("%s needed for the images and volumes, %s is free at %s", formatBytes(uint64(needed.Value())), formatBytes(free), info.DataRoot)
+		check.Remedy = "free up space, e.g. with docker system prune, or lower the volume sizes, e.g. --set mysql.volume-size=1Gi"
+		return check
+	}
+	check.Status = CheckPassed
+	check.Message = fmt.Sprintf("%s needed, %s free at %s", formatBytes(uint64(needed.Value())), formatBytes(free), info.DataRoot)
+	return check
+}
+
+func (c *Client) checkKubeconfig(path string) Check {
+	check := Check{Name: "kubeconfig"}
+	if err := c.machine.Writable(path); err != nil {
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("%s can't be written: %v", path, err)
+		check.Remedy = "fix the file's or its directory's permissions, or write the kubeconfig elsewhere with --kubeconfigPath or --private-kubeconfig"
+		return check
+	}
+	check.Status = CheckPassed
+	check.Message = fmt.Sprintf("%s can be written", path)
+	return check
+}
+
+// hostPorts returns the host ports the cluster would publish, sorted: the API server's, when it's pinned,
+// and those the port mappings publish. k3d picks a free port for the API server otherwise.
+func hostPorts(opts CreateOptions, file configFile) []int {
+	seen := map[int]bool{}
+	if opts.APIPort != 0 {
+		seen[opts.APIPort] = true
+	} else if port, err := strconv.Atoi(file.KubeAPI.HostPort); err == nil {
+		seen[port] = true
+	}
+
+	mappings := opts.Ports
+	if mappings == nil && len(file.Ports) == 0 {
+		mappings = DefaultPorts
+	}
+	for _, p := range file.Ports {
+		mappings = append(mappings, p.Port)
+	}
+	for _, p := range mappings {
+		if m := portPattern.FindStringSubmatch(p); m != nil {
+			if port, err := strconv.Atoi(m[1]); err == nil {
+				seen[port] = true
+			}
+		}
+	}
+
+	ports := make([]int, 0, len(seen))
+	for port := range seen {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// nodeCounts returns the number of servers and agents the cluster would have, the options winning over the config file
+func nodeCounts(opts CreateOptions, file configFile) (int, int) {
+	servers, agents := opts.Servers, opts.Agents
+	if servers == 0 {
+		servers = max(file.Servers, 1)
+	}
+	if agents == 0 {
+		agents = file.Agents
+	}
+	return servers, agents
+}
+
+// formatBytes formats n in binary units, e.g. 1.5Gi
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, suffix := float64(n), ""
+	for _, s := range []string{"Ki", "Mi", "Gi", "Ti"} {
+		if value < unit {
+			break
+		}
+		value /= unit
+		suffix = s
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + suffix
+}
@@ -0,0 +1,280 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// runtimeTimeout bounds every request to the container runtime, one that doesn't answer in time isn't usable
+const runtimeTimeout = 5 * time.Second
+
+// Runtime is the container runtime k3d runs the cluster's nodes in.
+type Runtime interface {
+	// Socket is the address the runtime is reached at, e.g. unix:///var/run/docker.sock
+	Socket() string
+
+	// Info asks the runtime about itself, it fails when the runtime can't be reached
+	Info() (RuntimeInfo, error)
+}
+
+// RuntimeInfo describes the container runtime and the resources it has to offer.
+type RuntimeInfo struct {
+	Version string
+
+	// CPUs and Memory, in bytes, are what containers can use, the virtual machine's on Docker Desktop
+	CPUs   int
+	Memory uint64
+
+	// DataRoot is where the runtime keeps images and volumes
+	DataRoot string
+}
+
+// Host is the machine the cluster's ports are published on and its kubeconfig is written to.
+type Host interface {
+	// PortInUse reports whether something listens on the TCP port already
+	PortInUse(port int) (bool, error)
+
+	// FreeDisk returns the bytes available on the filesystem path is on
+	FreeDisk(path string) (uint64, error)
+
+	// Writable checks that the file at path can be written, or created along with its missing directories
+	Writable(path string) error
+}
+
+var (
+	// ErrRuntimeUnchecked is returned by Info when the runtime is set up in a way it can't be reached to check,
+	// which doesn't mean k3d can't use it
+	ErrRuntimeUnchecked = errors.New("the container runtime can't be checked")
+
+	// ErrRuntimeUnhealthy is returned by Info when the runtime answered, but not with its info
+	ErrRuntimeUnhealthy = errors.New("the container runtime isn't healthy")
+)
+
+// dockerRuntime talks to the Docker API, which Podman serves too
+type dockerRuntime struct {
+	host string
+
+	// tls is set when the daemon expects TLS, which we don't speak
+	tls bool
+
+	// context is the docker context host was taken from, empty for DOCKER_HOST and the default socket
+	context string
+	// contextErr is why the context's endpoint couldn't be found
+	contextErr error
+}
+
+// newDockerRuntime returns the runtime DOCKER_HOST points at, like k3d itself, then the current docker context's,
+// and then the default socket
+func newDockerRuntime() *dockerRuntime {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return &dockerRuntime{host: host, tls: os.Getenv("DOCKER_TLS_VERIFY") != ""}
+	}
+	if name := currentDockerContext(); name != "" && name != "default" {
+		host, tls, err := dockerContextEndpoint(name)
+		return &dockerRuntime{host: host, tls: tls, context: name, contextErr: err}
+	}
+
+	host := "unix:///var/run/docker.sock"
+	// recent Docker Desktops only link the default socket when allowed to, their own is in the home directory
+	if runtime.GOOS == "darwin" {
+		if _, err := os.Stat("/var/run/docker.sock"); err != nil {
+			if home, err := os.UserHomeDir(); err == nil {
+				desktop := filepath.Join(home, ".docker", "run", "docker.sock")
+				if _, err := os.Stat(desktop); err == nil {
+					host = "unix://" + desktop
+				}
+			}
+		}
+	}
+	return &dockerRuntime{host: host}
+}
+
+// dockerConfigDir is where the docker CLI keeps its config and contexts
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// currentDockerContext returns the docker context DOCKER_CONTEXT or the docker CLI's config selects, if any
+func currentDockerContext() string {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name
+	}
+	dir := dockerConfigDir()
+	if dir == "" {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var cfg struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if json.Unmarshal(data, &cfg) != nil {
+		return ""
+	}
+	return cfg.CurrentContext
+}
+
+// dockerContextEndpoint returns the docker endpoint of the context called name, and whether it's secured with TLS.
+// The docker CLI keeps a context's metadata and TLS material in directories named after the digest of its name.
+func dockerContextEndpoint(name string) (string, bool, error) {
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	contexts := filepath.Join(dockerConfigDir(), "contexts")
+
+	data, err := os.ReadFile(filepath.Join(contexts, "meta", digest, "meta.json"))
+	if err != nil {
+		return "", false, fmt.Errorf("docker context %s: %w", name, err)
+	}
+	var meta struct {
+		Endpoints map[string]struct {
+			Host string `json:"Host"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", false, fmt.Errorf("docker context %s: %w", name, err)
+	}
+	host := meta.Endpoints["docker"].Host
+	if host == "" {
+		return "", false, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+
+	_, err = os.Stat(filepath.Join(contexts, "tls", digest, "docker"))
+	return host, err == nil, nil
+}
+
+func (d *dockerRuntime) Socket() string {
+	if d.context != "" && d.host == "" {
+		return "docker context " + d.context
+	}
+	return d.host
+}
+
+func (d *dockerRuntime) Info() (RuntimeInfo, error) {
+	if d.contextErr != nil {
+		return RuntimeInfo{}, fmt.Errorf("%w: %v", ErrRuntimeUnchecked, d.contextErr)
+	}
+	u, err := url.Parse(d.host)
+	if err != nil {
+		return RuntimeInfo{}, fmt.Errorf("%w: invalid address %q: %v", ErrRuntimeUnchecked, d.host, err)
+	}
+
+	transport := &http.Transport{}
+	base := "http://docker"
+	switch {
+	case u.Scheme == "unix":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", u.Path)
+		}
+	// 2376 is the daemon's TLS port by convention
+	case u.Scheme == "tcp" && (d.tls || u.Port() == "2376"):
+		return RuntimeInfo{}, fmt.Errorf("%w: %s expects TLS, only plain unix and tcp addresses are checked", ErrRuntimeUnchecked, d.host)
+	case u.Scheme == "tcp":
+		base = "http://" + u.Host
+	default:
+		return RuntimeInfo{}, fmt.Errorf("%w: only unix and tcp addresses are checked, not %s", ErrRuntimeUnchecked, d.host)
+	}
+
+	client := &http.Client{Transport: transport, Timeout: runtimeTimeout}
+	resp, err := client.Get(base + "/info")
+	if err != nil {
+		return RuntimeInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RuntimeInfo{}, fmt.Errorf("%w: it answered %s", ErrRuntimeUnhealthy, resp.Status)
+	}
+
+	// the subset of Docker's system info we look at
+	var info struct {
+		ServerVersion string `json:"ServerVersion"`
+		NCPU          int    `json:"NCPU"`
+		MemTotal      uint64 `json:"MemTotal"`
+		DockerRootDir string `json:"DockerRootDir"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return RuntimeInfo{}, fmt.Errorf("failed to parse the container runtime's info: %w", err)
+	}
+	return RuntimeInfo{
+		Version:  info.ServerVersion,
+		CPUs:     info.NCPU,
+		Memory:   info.MemTotal,
+		DataRoot: info.DockerRootDir,
+	}, nil
+}
+
+// localHost is the machine tufin runs on
+type localHost struct{}
+
+func (localHost) PortInUse(port int) (bool, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err == nil {
+		l.Close()
+		return false, nil
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return true, nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return false, err
+	}
+
+	// ports below 1024 take root to listen on, which the runtime has and we don't need, so see whether anyone answers instead
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return false, nil
+	}
+	conn.Close()
+	return true, nil
+}
+
+func (localHost) FreeDisk(path string) (uint64, error) {
+	return freeDisk(path)
+}
+
+func (localHost) Writable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	// the file is created along with its missing directories, so the closest existing one has to take new files
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	probe, err := os.CreateTemp(dir, ".tufin-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
@@ -0,0 +1,724 @@
+package cluster_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/kol-ratner/tufin/internal/cluster"
+	"github.com/kol-ratner/tufin/pkg/k8s"
+)
+
+// fakeK3d answers k3d cluster list from its clusters, and applies create, delete, start and stop to them
+type fakeK3d struct {
+	clusters []map[string]interface{}
+	calls    [][]string
+	failWith error
+
+	// registries is the content of the --registry-config file create was given, read while k3d runs
+	registries string
+}
+
+func (f *fakeK3d) Output(args ...string) ([]byte, error) {
+	f.calls = append(f.calls, args)
+	if args[0] == "kubeconfig" {
+		return k3dKubeconfig(args[2]), nil
+	}
+	if f.clusters == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(f.clusters)
+}
+
+func (f *fakeK3d) Stream(msgChan chan<- string, keep func(line string) bool, args ...string) error {
+	f.calls = append(f.calls, args)
+	if f.failWith != nil {
+		return f.failWith
+	}
+
+	name := args[2]
+	switch args[1] {
+	case "create":
+		for i, arg := range args {
+			if arg == "--registry-config" {
+				data, err := os.ReadFile(args[i+1])
+				if err != nil {
+					return err
+				}
+				f.registries = string(data)
+			}
+		}
+		f.clusters = append(f.clusters, k3dCluster(name, 1, 1, true))
+	case "delete":
+		for i, c := range f.clusters {
+			if c["name"] == name {
+				f.clusters = append(f.clusters[:i], f.clusters[i+1:]...)
+				break
+			}
+		}
+	case "start", "stop":
+		for _, c := range f.clusters {
+			if c["name"] == name {
+				running := 0
+				if args[1] == "start" {
+					running = 1
+				}
+				c["serversRunning"] = running
+			}
+		}
+	}
+	return nil
+}
+
+// k3dCluster is a cluster the way k3d cluster list -o json reports it, trimmed to what we read
+func k3dCluster(name string, servers, serversRunning int, loadBalancer bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name":            name,
+		"serversCount":    servers,
+		"serversRunning":  serversRunning,
+		"agentsCount":     0,
+		"agentsRunning":   0,
+		"hasLoadbalancer": loadBalancer,
+		"nodes":           []interface{}{},
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	k3d := &fakeK3d{clusters: []map[string]interface{}{
+		k3dCluster("k3s-default", 1, 1, true),
+		k3dCluster("review", 3, 0, false),
+	}}
+	k3d.clusters[0]["agentsCount"], k3d.clusters[0]["agentsRunning"] = 2, 1
+
+	got, err := cluster.NewClientWithRunner(k3d).List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []cluster.Info{
+		{Name: "k3s-default", Servers: 1, ServersRunning: 1, Agents: 2, AgentsRunning: 1, HasLoadBalancer: true},
+		{Name: "review", Servers: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("List() = %+v, want %+v", got, want)
+	}
+	if got[0].Status() != "degraded" || got[1].Status() != "stopped" {
+		t.Errorf("statuses = %s and %s, want degraded and stopped", got[0].Status(), got[1].Status())
+	}
+
+	data, err := json.Marshal(got[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"status":"stopped"`) {
+		t.Errorf("JSON = %s, want it to carry the status", data)
+	}
+}
+
+func TestClient_Lifecycle(t *testing.T) {
+	tests := []struct {
+		name       string
+		clusters   []map[string]interface{}
+		run        func(c *cluster.Client, msgs chan<- string) (cluster.Result, error)
+		wantResult cluster.Result
+		wantCall   []string
+		wantError  string
+	}{
+		{
+			name: "create",
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Create(msgs, cluster.CreateOptions{})
+			},
+			wantResult: cluster.Result{Name: "k3s-default", Action: "create", Changed: true, Cluster: &cluster.Info{
+				Name: "k3s-default", Servers: 1, ServersRunning: 1, HasLoadBalancer: true,
+			}},
+			wantCall: []string{"cluster", "create", "k3s-default", "--port", "80:80@loadbalancer", "--port", "443:443@loadbalancer"},
+		},
+		{
+			name:     "create existing",
+			clusters: []map[string]interface{}{k3dCluster("review", 1, 1, true)},
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Create(msgs, cluster.CreateOptions{Name: "review"})
+			},
+			wantResult: cluster.Result{Name: "review", Action: "create", Cluster: &cluster.Info{
+				Name: "review", Servers: 1, ServersRunning: 1, HasLoadBalancer: true,
+			}},
+		},
+		{
+			name:     "delete",
+			clusters: []map[string]interface{}{k3dCluster("review", 1, 1, true)},
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Delete(msgs, "review")
+			},
+			wantResult: cluster.Result{Name: "review", Action: "delete", Changed: true},
+			wantCall:   []string{"cluster", "delete", "review"},
+		},
+		{
+			name: "delete missing",
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Delete(msgs, "review")
+			},
+			wantResult: cluster.Result{Name: "review", Action: "delete"},
+		},
+		{
+			name:     "stop",
+			clusters: []map[string]interface{}{k3dCluster("k3s-default", 1, 1, true)},
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Stop(msgs, "")
+			},
+			wantResult: cluster.Result{Name: "k3s-default", Action: "stop", Changed: true, Cluster: &cluster.Info{
+				Name: "k3s-default", Servers: 1, HasLoadBalancer: true,
+			}},
+			wantCall: []string{"cluster", "stop", "k3s-default"},
+		},
+		{
+			name:     "start running",
+			clusters: []map[string]interface{}{k3dCluster("k3s-default", 1, 1, true)},
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Start(msgs, "k3s-default")
+			},
+			wantResult: cluster.Result{Name: "k3s-default", Action: "start", Cluster: &cluster.Info{
+				Name: "k3s-default", Servers: 1, ServersRunning: 1, HasLoadBalancer: true,
+			}},
+		},
+		{
+			name: "start missing",
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Start(msgs, "review")
+			},
+			wantError: "cluster review doesn't exist, create it with tufin cluster create --name review",
+		},
+		{
+			name: "invalid name",
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Create(msgs, cluster.CreateOptions{Name: "Review_1"})
+			},
+			wantError: `invalid cluster name "Review_1"`,
+		},
+		{
+			name: "name too long",
+			run: func(c *cluster.Client, msgs chan<- string) (cluster.Result, error) {
+				return c.Delete(msgs, strings.Repeat("a", 33))
+			},
+			wantError: "must be no more than 32 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k3d := &fakeK3d{clusters: tt.clusters}
+			result, err := tt.run(cluster.NewClientWithRunner(k3d), make(chan string, 10))
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.wantResult) {
+				t.Errorf("result = %+v, want %+v", result, tt.wantResult)
+			}
+
+			// everything but the listing changes the cluster, and only happens when it's needed
+			var changes [][]string
+			for _, call := range k3d.calls {
+				if call[1] != "list" {
+					changes = append(changes, call)
+				}
+			}
+			if tt.wantCall == nil {
+				if len(changes) > 0 {
+					t.Errorf("k3d was run with %v, want no changes", changes)
+				}
+			} else if len(changes) != 1 || !reflect.DeepEqual(changes[0], tt.wantCall) {
+				t.Errorf("k3d was run with %v, want %v", changes, tt.wantCall)
+			}
+		})
+	}
+}
+
+func TestClient_StreamError(t *testing.T) {
+	k3d := &fakeK3d{failWith: errors.New("k3d cluster create: docker isn't running")}
+
+	_, err := cluster.NewClientWithRunner(k3d).Create(make(chan string, 10), cluster.CreateOptions{})
+	if err == nil || err.Error() != "k3d cluster create: docker isn't running" {
+		t.Errorf("Create() error = %v, want the k3d failure", err)
+	}
+}
+
+func TestClient_CreateOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	simple := writeConfig("simple.yaml", `apiVersion: k3d.io/v1alpha5
+kind: Simple
+metadata:
+  name: from-file
+servers: 1
+agents: 2
+`)
+	withPorts := writeConfig("ports.yaml", `apiVersion: k3d.io/v1alpha5
+kind: Simple
+ports:
+  - port: 8080:80
+    nodeFilters:
+      - loadbalancer
+`)
+	oldVersion := writeConfig("old.yaml", `apiVersion: k3d.io/v1alpha4
+kind: Simple
+`)
+
+	tests := []struct {
+		name           string
+		opts           cluster.CreateOptions
+		wantArgs       []string
+		wantRegistries string
+		wantError      string
+	}{
+		{
+			name: "topology",
+			opts: cluster.CreateOptions{
+				Name:    "multi",
+				Servers: 3,
+				Agents:  2,
+				Image:   "rancher/k3s:v1.31.2-k3s1",
+				Ports:   []string{"8080:80@loadbalancer"},
+				Volumes: []string{"/data:/data@agent:*"},
+				K3sArgs: []string{"--disable=traefik@server:*"},
+			},
+			wantArgs: []string{
+				"cluster", "create", "multi",
+				"--servers", "3",
+				"--agents", "2",
+				"--image", "rancher/k3s:v1.31.2-k3s1",
+				"--port", "8080:80@loadbalancer",
+				"--volume", "/data:/data@agent:*",
+				"--k3s-arg=--disable=traefik@server:*",
+			},
+		},
+		{
+			name:     "api port",
+			opts:     cluster.CreateOptions{APIPort: 6550, Ports: []string{}},
+			wantArgs: []string{"cluster", "create", "k3s-default", "--api-port", "6550"},
+		},
+		{
+			name:     "no ports",
+			opts:     cluster.CreateOptions{Ports: []string{}},
+			wantArgs: []string{"cluster", "create", "k3s-default"},
+		},
+		{
+			name: "registry mirrors",
+			opts: cluster.CreateOptions{RegistryMirrors: map[string]string{
+				"quay.io":   "http://localhost:5000",
+				"docker.io": "https://mirror.gcr.io",
+			}},
+			wantArgs: []string{"cluster", "create", "k3s-default", "--port", "80:80@loadbalancer", "--port", "443:443@loadbalancer", "--registry-config"},
+			wantRegistries: `mirrors:
+  "docker.io":
+    endpoint:
+      - "https://mirror.gcr.io"
+  "quay.io":
+    endpoint:
+      - "http://localhost:5000"
+`,
+		},
+		{
+			name:     "config file",
+			opts:     cluster.CreateOptions{ConfigFile: simple},
+			wantArgs: []string{"cluster", "create", "from-file", "--config", simple, "--port", "80:80@loadbalancer", "--port", "443:443@loadbalancer"},
+		},
+		{
+			name:     "config file with ports",
+			opts:     cluster.CreateOptions{ConfigFile: withPorts, Name: "review", Agents: 1},
+			wantArgs: []string{"cluster", "create", "review", "--config", withPorts, "--agents", "1"},
+		},
+		{
+			name:      "config file version",
+			opts:      cluster.CreateOptions{ConfigFile: oldVersion},
+			wantError: "unsupported cluster config k3d.io/v1alpha4 Simple",
+		},
+		{
+			name:      "config file missing",
+			opts:      cluster.CreateOptions{ConfigFile: filepath.Join(dir, "missing.yaml")},
+			wantError: "failed to read the cluster config",
+		},
+		{
+			name:      "negative agents",
+			opts:      cluster.CreateOptions{Agents: -1},
+			wantError: "must not be negative",
+		},
+		{
+			name:      "invalid port",
+			opts:      cluster.CreateOptions{Ports: []string{"80"}},
+			wantError: `invalid port mapping "80"`,
+		},
+		{
+			name:      "port out of range",
+			opts:      cluster.CreateOptions{Ports: []string{"70000:80@loadbalancer"}},
+			wantError: "ports must be between 1 and 65535",
+		},
+		{
+			name:      "api port out of range",
+			opts:      cluster.CreateOptions{APIPort: 70000},
+			wantError: "invalid API port 70000",
+		},
+		{
+			name:      "relative volume destination",
+			opts:      cluster.CreateOptions{Volumes: []string{"/data:data"}},
+			wantError: `invalid volume "/data:data"`,
+		},
+		{
+			name:      "k3s argument without dashes",
+			opts:      cluster.CreateOptions{K3sArgs: []string{"disable=traefik"}},
+			wantError: `invalid k3s argument "disable=traefik"`,
+		},
+		{
+			name:      "mirror without scheme",
+			opts:      cluster.CreateOptions{RegistryMirrors: map[string]string{"docker.io": "mirror.gcr.io"}},
+			wantError: "invalid mirror endpoint for docker.io",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k3d := &fakeK3d{}
+			_, err := cluster.NewClientWithRunner(k3d).Create(make(chan string, 10), tt.opts)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantError)
+				}
+				for _, call := range k3d.calls {
+					if call[1] == "create" {
+						t.Errorf("k3d was run with %v, want no cluster created", call)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+
+			var args []string
+			for _, call := range k3d.calls {
+				if call[1] == "create" {
+					args = call
+				}
+			}
+			// the registries file is a temporary one, only its content is predictable
+			if tt.wantRegistries != "" {
+				path := args[len(args)-1]
+				args = args[:len(args)-1]
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("registries file %s is left behind", path)
+				}
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("k3d was run with %v, want %v", args, tt.wantArgs)
+			}
+			if k3d.registries != tt.wantRegistries {
+				t.Errorf("registries file = %q, want %q", k3d.registries, tt.wantRegistries)
+			}
+		})
+	}
+}
+
+// k3dKubeconfig is the kubeconfig k3d kubeconfig get prints for the cluster called name
+func k3dKubeconfig(name string) []byte {
+	return []byte(`apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://0.0.0.0:6443
+  name: k3d-` + name + `
+contexts:
+- context:
+    cluster: k3d-` + name + `
+    user: admin@k3d-` + name + `
+  name: k3d-` + name + `
+current-context: k3d-` + name + `
+users:
+- name: admin@k3d-` + name + `
+  user:
+    token: secret
+`)
+}
+
+func TestClient_CreateKubeconfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tufin", "kubeconfig")
+	k3d := &fakeK3d{clusters: []map[string]interface{}{k3dCluster("existing", 1, 1, true)}}
+	c := cluster.NewClientWithRunner(k3d)
+
+	result, err := c.Create(make(chan string, 10), cluster.CreateOptions{Name: "review", Kubeconfig: path})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if result.Kubeconfig != path || result.Context != "k3d-review" {
+		t.Errorf("Create() wrote the kubeconfig to %q with context %q, want %q and k3d-review", result.Kubeconfig, result.Context, path)
+	}
+	// k3d mustn't write the default kubeconfig behind our back
+	for _, call := range k3d.calls {
+		if call[1] == "create" && !slices.Contains(call, "--kubeconfig-update-default=false") {
+			t.Errorf("k3d was run with %v, want it to leave the default kubeconfig alone", call)
+		}
+	}
+
+	// a cluster that exists already becomes the current context too
+	result, err = c.Create(make(chan string, 10), cluster.CreateOptions{Name: "existing", Kubeconfig: path})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if result.Changed || result.Context != "k3d-existing" {
+		t.Errorf("Create() = %+v, want the existing cluster with context k3d-existing", result)
+	}
+
+	context, err := k8s.GetContextFromHost(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "k3d-existing" {
+		t.Errorf("current context = %q, want k3d-existing", context)
+	}
+}
+
+func TestCreateOptions_HostPort(t *testing.T) {
+	tests := []struct {
+		name          string
+		ports         []string
+		containerPort int
+		want          int
+	}{
+		{name: "default http", containerPort: 80, want: 80},
+		{name: "default https", containerPort: 443, want: 443},
+		{name: "remapped", ports: []string{"8080:80@loadbalancer"}, containerPort: 80, want: 8080},
+		{name: "without node filter", ports: []string{"127.0.0.1:8080:80"}, containerPort: 80, want: 8080},
+		{name: "on a node, not the load balancer", ports: []string{"8080:80@agent:0"}, containerPort: 80, want: 0},
+		{name: "not published", ports: []string{}, containerPort: 80, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := cluster.CreateOptions{Ports: tt.ports}
+			if got := opts.HostPort(tt.containerPort); got != tt.want {
+				t.Errorf("HostPort(%d) = %d, want %d", tt.containerPort, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeRuntime is a container runtime that answers with info, or fails with err
+type fakeRuntime struct {
+	info cluster.RuntimeInfo
+	err  error
+}
+
+func (r *fakeRuntime) Socket() string {
+	return "unix:///var/run/docker.sock"
+}
+
+func (r *fakeRuntime) Info() (cluster.RuntimeInfo, error) {
+	return r.info, r.err
+}
+
+// fakeHost has the ports in use, the free disk, and fails writing the paths in readOnly
+type fakeHost struct {
+	inUse    []int
+	freeDisk uint64
+	diskErr  error
+	readOnly []string
+}
+
+func (h *fakeHost) PortInUse(port int) (bool, error) {
+	return slices.Contains(h.inUse, port), nil
+}
+
+func (h *fakeHost) FreeDisk(path string) (uint64, error) {
+	return h.freeDisk, h.diskErr
+}
+
+func (h *fakeHost) Writable(path string) error {
+	if slices.Contains(h.readOnly, path) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+const gi = 1 << 30
+
+// healthyRuntime is a runtime with 8Gi of memory
+func healthyRuntime() *fakeRuntime {
+	return &fakeRuntime{info: cluster.RuntimeInfo{Version: "27.3.1", CPUs: 4, Memory: 8 * gi, DataRoot: "/var/lib/docker"}}
+}
+
+func TestClient_Preflight(t *testing.T) {
+	requirements := cluster.Requirements{Memory: resource.MustParse("1Gi"), Storage: resource.MustParse("6Gi")}
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+
+	tests := []struct {
+		name     string
+		runtime  *fakeRuntime
+		host     *fakeHost
+		opts     cluster.CreateOptions
+		clusters []map[string]interface{}
+		// want maps the checks to the status they should have, the others should pass
+		want       map[string]cluster.CheckStatus
+		wantRemedy string
+	}{
+		{
+			name:    "healthy",
+			runtime: healthyRuntime(),
+			host:    &fakeHost{freeDisk: 100 * gi},
+			opts:    cluster.CreateOptions{Kubeconfig: kubeconfig, Requirements: requirements},
+			want:    map[string]cluster.CheckStatus{},
+		},
+		{
+			name:       "docker not running",
+			runtime:    &fakeRuntime{err: fmt.Errorf("dial unix /var/run/docker.sock: %w", os.ErrNotExist)},
+			host:       &fakeHost{freeDisk: 100 * gi},
+			want:       map[string]cluster.CheckStatus{"container runtime": cluster.CheckFailed},
+			wantRemedy: "systemctl start docker",
+		},
+		{
+			name:       "socket permission",
+			runtime:    &fakeRuntime{err: fmt.Errorf("dial unix /var/run/docker.sock: %w", os.ErrPermission)},
+			host:       &fakeHost{freeDisk: 100 * gi},
+			want:       map[string]cluster.CheckStatus{"container runtime": cluster.CheckFailed},
+			wantRemedy: "usermod -aG docker",
+		},
+		{
+			name:       "runtime answers with an error",
+			runtime:    &fakeRuntime{err: fmt.Errorf("%w: it answered 500 Internal Server Error", cluster.ErrRuntimeUnhealthy)},
+			host:       &fakeHost{freeDisk: 100 * gi},
+			want:       map[string]cluster.CheckStatus{"container runtime": cluster.CheckFailed},
+			wantRemedy: "docker info",
+		},
+		{
+			name:    "runtime behind ssh",
+			runtime: &fakeRuntime{err: fmt.Errorf("%w: only unix and tcp addresses are checked, not ssh://dev@build", cluster.ErrRuntimeUnchecked)},
+			host:    &fakeHost{freeDisk: 100 * gi},
+			want:    map[string]cluster.CheckStatus{"container runtime": cluster.CheckWarning},
+		},
+		{
+			name:    "runtime too slow to answer",
+			runtime: &fakeRuntime{err: errors.New("context deadline exceeded")},
+			host:    &fakeHost{freeDisk: 100 * gi},
+			want:    map[string]cluster.CheckStatus{"container runtime": cluster.CheckWarning},
+		},
+		{
+			name:       "ports in use",
+			runtime:    healthyRuntime(),
+			host:       &fakeHost{inUse: []int{80, 6443}, freeDisk: 100 * gi},
+			opts:       cluster.CreateOptions{APIPort: 6443},
+			want:       map[string]cluster.CheckStatus{"port 80": cluster.CheckFailed, "port 6443": cluster.CheckFailed},
+			wantRemedy: "lsof -i :80",
+		},
+		{
+			name:     "ports of an existing cluster",
+			runtime:  healthyRuntime(),
+			host:     &fakeHost{inUse: []int{80, 443}, freeDisk: 100 * gi},
+			clusters: []map[string]interface{}{k3dCluster(cluster.DefaultName, 1, 1, true)},
+			want:     map[string]cluster.CheckStatus{},
+		},
+		{
+			name:       "too little memory",
+			runtime:    healthyRuntime(),
+			host:       &fakeHost{freeDisk: 100 * gi},
+			opts:       cluster.CreateOptions{Agents: 3, Requirements: cluster.Requirements{Memory: resource.MustParse("7Gi")}},
+			want:       map[string]cluster.CheckStatus{"memory": cluster.CheckFailed},
+			wantRemedy: "more memory",
+		},
+		{
+			name:       "too little disk",
+			runtime:    healthyRuntime(),
+			host:       &fakeHost{freeDisk: 5 * gi},
+			opts:       cluster.CreateOptions{Requirements: requirements},
+			want:       map[string]cluster.CheckStatus{"disk": cluster.CheckFailed},
+			wantRemedy: "docker system prune",
+		},
+		{
+			name:    "disk can't be checked",
+			runtime: healthyRuntime(),
+			host:    &fakeHost{diskErr: os.ErrNotExist},
+			want:    map[string]cluster.CheckStatus{"disk": cluster.CheckWarning},
+		},
+		{
+			name:       "kubeconfig not writable",
+			runtime:    healthyRuntime(),
+			host:       &fakeHost{freeDisk: 100 * gi, readOnly: []string{kubeconfig}},
+			opts:       cluster.CreateOptions{Kubeconfig: kubeconfig},
+			want:       map[string]cluster.CheckStatus{"kubeconfig": cluster.CheckFailed},
+			wantRemedy: "--private-kubeconfig",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k3d := &fakeK3d{clusters: tt.clusters}
+			c := cluster.NewClientWithRunner(k3d).WithPreflight(tt.runtime, tt.host)
+
+			checks, err := c.Preflight(tt.opts)
+			if err != nil {
+				t.Fatalf("Preflight() error = %v", err)
+			}
+			var remedies []string
+			for _, check := range checks {
+				want, ok := tt.want[check.Name]
+				if !ok {
+					want = cluster.CheckPassed
+				}
+				if check.Status != want {
+					t.Errorf("check %s = %s (%s), want %s", check.Name, check.Status, check.Message, want)
+				}
+				remedies = append(remedies, check.Remedy)
+			}
+			if tt.wantRemedy != "" && !strings.Contains(strings.Join(remedies, "\n"), tt.wantRemedy) {
+				t.Errorf("remedies %q, want one mentioning %q", remedies, tt.wantRemedy)
+			}
+
+			// Create fails on exactly the failed checks, before k3d gets to create anything
+			_, err = c.Create(make(chan string, 10), tt.opts)
+			var preflightErr *cluster.PreflightError
+			failed := false
+			for _, status := range tt.want {
+				failed = failed || status == cluster.CheckFailed
+			}
+			if failed != errors.As(err, &preflightErr) {
+				t.Fatalf("Create() error = %v, want a preflight error: %v", err, failed)
+			}
+			if failed {
+				for _, call := range k3d.calls {
+					if call[1] == "create" {
+						t.Errorf("k3d was run with %v, want no cluster created", call)
+					}
+				}
+			} else if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_CreateSkipPreflight(t *testing.T) {
+	k3d := &fakeK3d{}
+	c := cluster.NewClientWithRunner(k3d).WithPreflight(&fakeRuntime{err: os.ErrNotExist}, &fakeHost{})
+
+	if _, err := c.Create(make(chan string, 10), cluster.CreateOptions{}); err == nil {
+		t.Fatal("Create() without a runtime succeeded, want a preflight error")
+	}
+	if _, err := c.Create(make(chan string, 10), cluster.CreateOptions{SkipPreflight: true}); err != nil {
+		t.Fatalf("Create() with SkipPreflight error = %v", err)
+	}
+}
@@ -1,5 +1,12 @@
 package config
 
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
 type DeploymentOverrides struct {
 	Replicas      int32
 	CPURequest    string
@@ -7,6 +14,57 @@ type DeploymentOverrides struct {
 	CPULimit      string
 	MemoryLimit   string
 	VolumeSize    string
+	Namespace     string
+	Release       string
+
+	// Host, Path, IngressClass and TLSSecret configure how a component is exposed outside the cluster
+	Host         string
+	Path         string
+	IngressClass string
+	TLSSecret    string
+
+	// ServiceType, ServicePort, NodePort, SourceRanges and ServiceAnnotations configure a component's service
+	ServiceType        string
+	ServicePort        int32
+	NodePort           int32
+	SourceRanges       []string
+	ServiceAnnotations map[string]string
+
+	// Files maps the name of a config file the component supports, e.g. my.cnf, to the content it's given
+	Files map[string]string
+
+	// Probes tune the component's default probes, ProbePath replaces the path its HTTP probes request
+	Probes    map[ProbeKind]ProbeOverrides
+	ProbePath string
+
+	// RunAsUser, RunAsGroup, FSGroup, RunAsNonRoot, ReadOnlyRootFilesystem and SeccompProfile
+	// change a component's security contexts, nil and empty keep its hardened defaults
+	RunAsUser              *int64
+	RunAsGroup             *int64
+	FSGroup                *int64
+	RunAsNonRoot           *bool
+	ReadOnlyRootFilesystem *bool
+	SeccompProfile         string
+}
+
+// ProbeKind is one of the probes the kubelet runs against a container.
+type ProbeKind string
+
+const (
+	LivenessProbe  ProbeKind = "liveness"
+	ReadinessProbe ProbeKind = "readiness"
+	StartupProbe   ProbeKind = "startup"
+)
+
+// ProbeOverrides tunes one of a component's probes, nil fields keep the component's default.
+type ProbeOverrides struct {
+	// Disabled drops the probe altogether
+	Disabled bool
+
+	InitialDelaySeconds *int32
+	PeriodSeconds       *int32
+	TimeoutSeconds      *int32
+	FailureThreshold    *int32
 }
 
 type Option func(*DeploymentOverrides)
@@ -46,3 +104,258 @@ func WithVolumeSize(size string) Option {
 		do.VolumeSize = size
 	}
 }
+
+func WithHost(host string) Option {
+	return func(do *DeploymentOverrides) {
+		do.Host = host
+	}
+}
+
+func WithPath(path string) Option {
+	return func(do *DeploymentOverrides) {
+		do.Path = path
+	}
+}
+
+func WithIngressClass(class string) Option {
+	return func(do *DeploymentOverrides) {
+		do.IngressClass = class
+	}
+}
+
+func WithTLSSecret(secret string) Option {
+	return func(do *DeploymentOverrides) {
+		do.TLSSecret = secret
+	}
+}
+
+func WithServiceType(serviceType string) Option {
+	return func(do *DeploymentOverrides) {
+		do.ServiceType = serviceType
+	}
+}
+
+func WithServicePort(port int32) Option {
+	return func(do *DeploymentOverrides) {
+		do.ServicePort = port
+	}
+}
+
+func WithNodePort(port int32) Option {
+	return func(do *DeploymentOverrides) {
+		do.NodePort = port
+	}
+}
+
+func WithSourceRanges(cidrs ...string) Option {
+	return func(do *DeploymentOverrides) {
+		do.SourceRanges = cidrs
+	}
+}
+
+// WithServiceAnnotation sets one annotation on the component's service, replacing the one set before, if any.
+func WithServiceAnnotation(key, value string) Option {
+	return func(do *DeploymentOverrides) {
+		if do.ServiceAnnotations == nil {
+			do.ServiceAnnotations = map[string]string{}
+		}
+		do.ServiceAnnotations[key] = value
+	}
+}
+
+// HasServiceOptions reports whether any of the options that configure a component's service is set.
+func (o *DeploymentOverrides) HasServiceOptions() bool {
+	return o.ServiceType != "" || o.ServicePort != 0 || o.NodePort != 0 || len(o.SourceRanges) > 0 || len(o.ServiceAnnotations) > 0
+}
+
+// HasIngress reports whether any of the options that expose a component through an ingress is set.
+func (o *DeploymentOverrides) HasIngress() bool {
+	return o.Host != "" || o.Path != "" || o.IngressClass != "" || o.TLSSecret != ""
+}
+
+// WithFile supplies the content of one of the component's config files, replacing the one set before, if any.
+func WithFile(name, content string) Option {
+	return func(do *DeploymentOverrides) {
+		if do.Files == nil {
+			do.Files = map[string]string{}
+		}
+		do.Files[name] = content
+	}
+}
+
+// WithProbe changes the overrides of one of the component's probes, on top of the ones set before.
+func WithProbe(kind ProbeKind, update func(*ProbeOverrides)) Option {
+	return func(do *DeploymentOverrides) {
+		if do.Probes == nil {
+			do.Probes = map[ProbeKind]ProbeOverrides{}
+		}
+		probe := do.Probes[kind]
+		update(&probe)
+		do.Probes[kind] = probe
+	}
+}
+
+func WithProbePath(path string) Option {
+	return func(do *DeploymentOverrides) {
+		do.ProbePath = path
+	}
+}
+
+// ApplyProbe returns a copy of probe with the overrides of its kind applied, or nil if that probe is disabled.
+// probe itself is left untouched, so the component's defaults can be shared.
+func (o *DeploymentOverrides) ApplyProbe(kind ProbeKind, probe *corev1.Probe) *corev1.Probe {
+	override := o.Probes[kind]
+	if probe == nil || override.Disabled {
+		return nil
+	}
+
+	p := probe.DeepCopy()
+	if o.ProbePath != "" && p.HTTPGet != nil {
+		p.HTTPGet.Path = o.ProbePath
+	}
+	for _, field := range []struct {
+		value  *int32
+		target *int32
+	}{
+		{override.InitialDelaySeconds, &p.InitialDelaySeconds},
+		{override.PeriodSeconds, &p.PeriodSeconds},
+		{override.TimeoutSeconds, &p.TimeoutSeconds},
+		{override.FailureThreshold, &p.FailureThreshold},
+	} {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	return p
+}
+
+func WithRunAsUser(uid int64) Option {
+	return func(do *DeploymentOverrides) {
+		do.RunAsUser = &uid
+	}
+}
+
+func WithRunAsGroup(gid int64) Option {
+	return func(do *DeploymentOverrides) {
+		do.RunAsGroup = &gid
+	}
+}
+
+func WithFSGroup(gid int64) Option {
+	return func(do *DeploymentOverrides) {
+		do.FSGroup = &gid
+	}
+}
+
+func WithRunAsNonRoot(nonRoot bool) Option {
+	return func(do *DeploymentOverrides) {
+		do.RunAsNonRoot = &nonRoot
+	}
+}
+
+func WithReadOnlyRootFilesystem(readOnly bool) Option {
+	return func(do *DeploymentOverrides) {
+		do.ReadOnlyRootFilesystem = &readOnly
+	}
+}
+
+func WithSeccompProfile(profile string) Option {
+	return func(do *DeploymentOverrides) {
+		do.SeccompProfile = profile
+	}
+}
+
+// ApplySecurity sets the security settings overridden in o on the component's pod and container security contexts,
+// creating them if needed and leaving the settings that aren't overridden at their current value.
+func (o *DeploymentOverrides) ApplySecurity(pod **corev1.PodSecurityContext, container **corev1.SecurityContext) {
+	if o.RunAsUser != nil || o.RunAsGroup != nil || o.FSGroup != nil || o.RunAsNonRoot != nil || o.SeccompProfile != "" {
+		if *pod == nil {
+			*pod = &corev1.PodSecurityContext{}
+		}
+		p := *pod
+		if o.RunAsUser != nil {
+			p.RunAsUser = o.RunAsUser
+		}
+		if o.RunAsGroup != nil {
+			p.RunAsGroup = o.RunAsGroup
+		}
+		if o.FSGroup != nil {
+			p.FSGroup = o.FSGroup
+		}
+		if o.RunAsNonRoot != nil {
+			p.RunAsNonRoot = o.RunAsNonRoot
+		}
+		if o.SeccompProfile != "" {
+			p.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileType(o.SeccompProfile)}
+		}
+	}
+
+	if o.ReadOnlyRootFilesystem != nil {
+		if *container == nil {
+			*container = &corev1.SecurityContext{}
+		}
+		(*container).ReadOnlyRootFilesystem = o.ReadOnlyRootFilesystem
+	}
+}
+
+func WithNamespace(namespace string) Option {
+	return func(do *DeploymentOverrides) {
+		do.Namespace = namespace
+	}
+}
+
+func WithRelease(release string) Option {
+	return func(do *DeploymentOverrides) {
+		do.Release = release
+	}
+}
+
+// ReleaseName returns the name a component's objects get within a release.
+// Without a release, the component name is used as is.
+func ReleaseName(release, component string) string {
+	if release == "" {
+		return component
+	}
+	return fmt.Sprintf("%s-%s", release, component)
+}
+
+// CheckFiles makes sure every file in o.Files is one of the supported ones.
+func (o *DeploymentOverrides) CheckFiles(supported []string) error {
+	for name := range o.Files {
+		if !slices.Contains(supported, name) {
+			return UnknownFileError(name, supported)
+		}
+	}
+	return nil
+}
+
+// ApplyResources sets the CPU and memory requests and limits overridden in o on res,
+// leaving the ones that aren't overridden at their current value.
+func (o *DeploymentOverrides) ApplyResources(res *corev1.ResourceRequirements) error {
+	overrides := []struct {
+		key   string
+		value string
+		list  *corev1.ResourceList
+		name  corev1.ResourceName
+	}{
+		{"cpu-request", o.CPURequest, &res.Requests, corev1.ResourceCPU},
+		{"memory-request", o.MemoryRequest, &res.Requests, corev1.ResourceMemory},
+		{"cpu-limit", o.CPULimit, &res.Limits, corev1.ResourceCPU},
+		{"memory-limit", o.MemoryLimit, &res.Limits, corev1.ResourceMemory},
+	}
+
+	for _, override := range overrides {
+		if override.value == "" {
+			continue
+		}
+		q, err := ParseQuantity(override.key, override.value)
+		if err != nil {
+			return err
+		}
+		if *override.list == nil {
+			*override.list = corev1.ResourceList{}
+		}
+		(*override.list)[override.name] = q
+	}
+	return nil
+}
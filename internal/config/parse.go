@@ -0,0 +1,265 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// optionKeys are the keys ParseOption understands
+var optionKeys = []string{
+	"replicas",
+	"cpu-request",
+	"memory-request",
+	"cpu-limit",
+	"memory-limit",
+	"volume-size",
+	"host",
+	"path",
+	"ingress-class",
+	"tls-secret",
+	"service-type",
+	"service-port",
+	"node-port",
+	"source-ranges",
+	serviceAnnotationPrefix + "<name>",
+	string(LivenessProbe) + probeKeySuffix + "<field>",
+	string(ReadinessProbe) + probeKeySuffix + "<field>",
+	string(StartupProbe) + probeKeySuffix + "<field>",
+	"probe-path",
+	"run-as-user",
+	"run-as-group",
+	"fs-group",
+	"run-as-non-root",
+	"read-only-root-filesystem",
+	"seccomp-profile",
+}
+
+// serviceAnnotationPrefix starts the keys that set an annotation on the service, e.g. service-annotation.example.com/owner
+const serviceAnnotationPrefix = "service-annotation."
+
+// probeKeySuffix follows the probe kind in the keys that tune a probe, e.g. readiness-probe.period-seconds
+const probeKeySuffix = "-probe."
+
+// probeKinds are the probes that can be tuned
+var probeKinds = []ProbeKind{LivenessProbe, ReadinessProbe, StartupProbe}
+
+// probeFields are the settings of a probe that can be tuned
+var probeFields = []string{
+	"enabled",
+	"initial-delay-seconds",
+	"period-seconds",
+	"timeout-seconds",
+	"failure-threshold",
+}
+
+// seccompProfiles are the values seccomp-profile accepts, Localhost profiles need a path we have no option for
+var seccompProfiles = []string{
+	string(corev1.SeccompProfileTypeRuntimeDefault),
+	string(corev1.SeccompProfileTypeUnconfined),
+}
+
+// serviceTypes are the values service-type accepts
+var serviceTypes = []string{
+	string(corev1.ServiceTypeClusterIP),
+	string(corev1.ServiceTypeNodePort),
+	string(corev1.ServiceTypeLoadBalancer),
+}
+
+// OptionKeys returns every key accepted by --set and the values file.
+func OptionKeys() []string {
+	return append([]string(nil), optionKeys...)
+}
+
+// ParseOption turns a single key=value override, as used by --set and the values file, into an Option.
+// Values are checked here, so a bad quantity is reported up front instead of failing the deployment.
+func ParseOption(key, value string) (Option, error) {
+	if name, ok := strings.CutPrefix(key, serviceAnnotationPrefix); ok && name != "" {
+		return WithServiceAnnotation(name, value), nil
+	}
+	for _, kind := range probeKinds {
+		if field, ok := strings.CutPrefix(key, string(kind)+probeKeySuffix); ok {
+			return parseProbeOption(kind, key, field, value)
+		}
+	}
+
+	switch key {
+	case "replicas":
+		replicas, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value type for replicas: %s", value)
+		}
+		if replicas < 0 {
+			return nil, fmt.Errorf("replicas must not be negative: %s", value)
+		}
+		// zero is what the components take for unset, so it would deploy their default count instead
+		if replicas == 0 {
+			return nil, fmt.Errorf("replicas must be at least 1, use tufin destroy to remove a component: %s", value)
+		}
+		return WithReplicas(int32(replicas)), nil
+	case "cpu-request":
+		if _, err := ParseQuantity(key, value); err != nil {
+			return nil, err
+		}
+		return WithCPURequest(value), nil
+	case "memory-request":
+		if _, err := ParseQuantity(key, value); err != nil {
+			return nil, err
+		}
+		return WithMemoryRequest(value), nil
+	case "cpu-limit":
+		if _, err := ParseQuantity(key, value); err != nil {
+			return nil, err
+		}
+		return WithCPULimit(value), nil
+	case "memory-limit":
+		if _, err := ParseQuantity(key, value); err != nil {
+			return nil, err
+		}
+		return WithMemoryLimit(value), nil
+	case "volume-size":
+		if _, err := ParseQuantity(key, value); err != nil {
+			return nil, err
+		}
+		return WithVolumeSize(value), nil
+	// the ingress options are checked against the rest of the component's config when it's built
+	case "host":
+		return WithHost(value), nil
+	case "path":
+		return WithPath(value), nil
+	case "ingress-class":
+		return WithIngressClass(value), nil
+	case "tls-secret":
+		return WithTLSSecret(value), nil
+	case "service-type":
+		if !slices.Contains(serviceTypes, value) {
+			return nil, unknownError("service type", value, serviceTypes)
+		}
+		return WithServiceType(value), nil
+	case "service-port":
+		port, err := parsePort(key, value)
+		if err != nil {
+			return nil, err
+		}
+		return WithServicePort(port), nil
+	case "node-port":
+		port, err := parsePort(key, value)
+		if err != nil {
+			return nil, err
+		}
+		return WithNodePort(port), nil
+	case "source-ranges":
+		// separated by spaces, since --set already splits on commas
+		cidrs := strings.Fields(value)
+		for _, cidr := range cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("invalid CIDR in source-ranges: %q (expected e.g. 10.0.0.0/8)", cidr)
+			}
+		}
+		return WithSourceRanges(cidrs...), nil
+	case "probe-path":
+		if !strings.HasPrefix(value, "/") {
+			return nil, fmt.Errorf("probe-path must start with a /: %s", value)
+		}
+		return WithProbePath(value), nil
+	case "run-as-user", "run-as-group", "fs-group":
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value type for %s: %s", key, value)
+		}
+		if id < 0 {
+			return nil, fmt.Errorf("%s must not be negative: %s", key, value)
+		}
+		switch key {
+		case "run-as-user":
+			return WithRunAsUser(id), nil
+		case "run-as-group":
+			return WithRunAsGroup(id), nil
+		default:
+			return WithFSGroup(id), nil
+		}
+	case "run-as-non-root", "read-only-root-filesystem":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value type for %s: %s (expected true or false)", key, value)
+		}
+		if key == "run-as-non-root" {
+			return WithRunAsNonRoot(b), nil
+		}
+		return WithReadOnlyRootFilesystem(b), nil
+	case "seccomp-profile":
+		if !slices.Contains(seccompProfiles, value) {
+			return nil, unknownError("seccomp profile", value, seccompProfiles)
+		}
+		return WithSeccompProfile(value), nil
+	default:
+		return nil, unknownError("option", key, optionKeys)
+	}
+}
+
+// parseProbeOption parses the value of field, one of the probeFields, of the probe of the given kind
+func parseProbeOption(kind ProbeKind, key, field, value string) (Option, error) {
+	if field == "enabled" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value type for %s: %s (expected true or false)", key, value)
+		}
+		return WithProbe(kind, func(p *ProbeOverrides) { p.Disabled = !enabled }), nil
+	}
+	if !slices.Contains(probeFields, field) {
+		return nil, unknownError(fmt.Sprintf("%s probe setting", kind), field, probeFields)
+	}
+
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value type for %s: %s", key, value)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("%s must not be negative: %s", key, value)
+	}
+	// only the initial delay may be zero, the kubelet needs the others to be at least 1
+	if n == 0 && field != "initial-delay-seconds" {
+		return nil, fmt.Errorf("%s must be greater than zero: %s", key, value)
+	}
+	v := int32(n)
+
+	return WithProbe(kind, func(p *ProbeOverrides) {
+		switch field {
+		case "initial-delay-seconds":
+			p.InitialDelaySeconds = &v
+		case "period-seconds":
+			p.PeriodSeconds = &v
+		case "timeout-seconds":
+			p.TimeoutSeconds = &v
+		case "failure-threshold":
+			p.FailureThreshold = &v
+		}
+	}), nil
+}
+
+// parsePort parses the value of the port option key
+func parsePort(key, value string) (int32, error) {
+	port, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port for %s: %s (expected 1-65535)", key, value)
+	}
+	return int32(port), nil
+}
+
+// ParseQuantity parses the value of the quantity option key, such as 500m or 1Gi.
+// Unlike resource.MustParse it returns an error for malformed values, and it rejects zero and negative ones.
+func ParseQuantity(key, value string) (resource.Quantity, error) {
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("invalid quantity for %s: %q (expected e.g. 500m, 1, 256Mi or 1Gi)", key, value)
+	}
+	if q.Sign() <= 0 {
+		return resource.Quantity{}, fmt.Errorf("%s must be greater than zero: %s", key, value)
+	}
+	return q, nil
+}
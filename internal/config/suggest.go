@@ -0,0 +1,58 @@
+package config
+
+import "fmt"
+
+// maxSuggestDistance is the most edits a typo can be away from a known name
+// for us to still suggest that name
+const maxSuggestDistance = 3
+
+// Suggest returns the candidate closest to input, or "" if none is close enough to be a likely typo.
+func Suggest(input string, candidates []string) string {
+	best, bestDistance := "", maxSuggestDistance+1
+	for _, c := range candidates {
+		if d := levenshtein(input, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// unknownError reports an unknown name of the given kind, with a suggestion if there is a close match
+func unknownError(kind, input string, candidates []string) error {
+	if suggestion := Suggest(input, candidates); suggestion != "" {
+		return fmt.Errorf("unknown %s %q, did you mean %q?", kind, input, suggestion)
+	}
+	return fmt.Errorf("unknown %s %q", kind, input)
+}
+
+// UnknownComponentError reports a component name that isn't one of components.
+func UnknownComponentError(component string, components []string) error {
+	return unknownError("component", component, components)
+}
+
+// UnknownFileError reports a config file name that isn't one of the files a component supports.
+func UnknownFileError(file string, files []string) error {
+	return unknownError("file", file, files)
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -1,9 +1,11 @@
 package config_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/kol-ratner/tufin/internal/config"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestDeploymentOverrides(t *testing.T) {
@@ -101,3 +103,340 @@ func TestDeploymentOverrides(t *testing.T) {
 		})
 	}
 }
+
+func TestParseOption(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		value     string
+		expected  config.DeploymentOverrides
+		wantError string
+	}{
+		{
+			name:     "replicas",
+			key:      "replicas",
+			value:    "3",
+			expected: config.DeploymentOverrides{Replicas: 3},
+		},
+		{
+			name:     "fractional cpu",
+			key:      "cpu-limit",
+			value:    "0.5",
+			expected: config.DeploymentOverrides{CPULimit: "0.5"},
+		},
+		{
+			name:     "service type",
+			key:      "service-type",
+			value:    "NodePort",
+			expected: config.DeploymentOverrides{ServiceType: "NodePort"},
+		},
+		{
+			name:     "source ranges",
+			key:      "source-ranges",
+			value:    "10.0.0.0/8 192.168.1.0/24",
+			expected: config.DeploymentOverrides{SourceRanges: []string{"10.0.0.0/8", "192.168.1.0/24"}},
+		},
+		{
+			name:     "service annotation",
+			key:      "service-annotation.example.com/owner",
+			value:    "team-a",
+			expected: config.DeploymentOverrides{ServiceAnnotations: map[string]string{"example.com/owner": "team-a"}},
+		},
+		{
+			name:     "probe timing",
+			key:      "startup-probe.failure-threshold",
+			value:    "60",
+			expected: config.DeploymentOverrides{Probes: map[config.ProbeKind]config.ProbeOverrides{config.StartupProbe: {FailureThreshold: ptr(int32(60))}}},
+		},
+		{
+			name:     "zero initial delay",
+			key:      "liveness-probe.initial-delay-seconds",
+			value:    "0",
+			expected: config.DeploymentOverrides{Probes: map[config.ProbeKind]config.ProbeOverrides{config.LivenessProbe: {InitialDelaySeconds: ptr(int32(0))}}},
+		},
+		{
+			name:     "disabled probe",
+			key:      "readiness-probe.enabled",
+			value:    "false",
+			expected: config.DeploymentOverrides{Probes: map[config.ProbeKind]config.ProbeOverrides{config.ReadinessProbe: {Disabled: true}}},
+		},
+		{
+			name:     "probe path",
+			key:      "probe-path",
+			value:    "/healthz",
+			expected: config.DeploymentOverrides{ProbePath: "/healthz"},
+		},
+		{
+			name:     "run as user",
+			key:      "run-as-user",
+			value:    "1000",
+			expected: config.DeploymentOverrides{RunAsUser: ptr(int64(1000))},
+		},
+		{
+			name:     "writable root filesystem",
+			key:      "read-only-root-filesystem",
+			value:    "false",
+			expected: config.DeploymentOverrides{ReadOnlyRootFilesystem: ptr(false)},
+		},
+		{
+			name:      "negative fs group",
+			key:       "fs-group",
+			value:     "-1",
+			wantError: "fs-group must not be negative: -1",
+		},
+		{
+			name:      "seccomp profile typo",
+			key:       "seccomp-profile",
+			value:     "RuntimeDefualt",
+			wantError: `unknown seccomp profile "RuntimeDefualt", did you mean "RuntimeDefault"?`,
+		},
+		{
+			name:      "zero probe period",
+			key:       "readiness-probe.period-seconds",
+			value:     "0",
+			wantError: "readiness-probe.period-seconds must be greater than zero: 0",
+		},
+		{
+			name:      "probe setting typo",
+			key:       "liveness-probe.period-secnods",
+			value:     "5",
+			wantError: `unknown liveness probe setting "period-secnods", did you mean "period-seconds"?`,
+		},
+		{
+			name:      "relative probe path",
+			key:       "probe-path",
+			value:     "healthz",
+			wantError: "probe-path must start with a /: healthz",
+		},
+		{
+			name:      "service type typo",
+			key:       "service-type",
+			value:     "Loadbalancer",
+			wantError: `unknown service type "Loadbalancer", did you mean "LoadBalancer"?`,
+		},
+		{
+			name:      "port out of range",
+			key:       "node-port",
+			value:     "70000",
+			wantError: "invalid port for node-port: 70000 (expected 1-65535)",
+		},
+		{
+			name:      "malformed cidr",
+			key:       "source-ranges",
+			value:     "10.0.0.0",
+			wantError: `invalid CIDR in source-ranges: "10.0.0.0" (expected e.g. 10.0.0.0/8)`,
+		},
+		{
+			name:      "negative replicas",
+			key:       "replicas",
+			value:     "-1",
+			wantError: "replicas must not be negative: -1",
+		},
+		{
+			name:      "zero replicas",
+			key:       "replicas",
+			value:     "0",
+			wantError: "replicas must be at least 1, use tufin destroy to remove a component: 0",
+		},
+		{
+			name:      "malformed quantity",
+			key:       "memory-request",
+			value:     "1GB",
+			wantError: `invalid quantity for memory-request: "1GB" (expected e.g. 500m, 1, 256Mi or 1Gi)`,
+		},
+		{
+			name:      "zero quantity",
+			key:       "volume-size",
+			value:     "0",
+			wantError: "volume-size must be greater than zero: 0",
+		},
+		{
+			name:      "typo in key",
+			key:       "memory-requets",
+			value:     "1Gi",
+			wantError: `unknown option "memory-requets", did you mean "memory-request"?`,
+		},
+		{
+			name:      "unknown key",
+			key:       "image",
+			value:     "nginx",
+			wantError: `unknown option "image"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := config.ParseOption(tt.key, tt.value)
+			if tt.wantError != "" {
+				if err == nil || err.Error() != tt.wantError {
+					t.Fatalf("ParseOption() error = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOption() error = %v", err)
+			}
+
+			got := config.DeploymentOverrides{}
+			opt(&got)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseOption() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"wordpress", "mysql"}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"wordpres", "wordpress"},
+		{"mysq", "mysql"},
+		{"redis", ""},
+	}
+
+	for _, tt := range tests {
+		if got := config.Suggest(tt.input, candidates); got != tt.want {
+			t.Errorf("Suggest(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDeploymentOverrides_ApplyProbe(t *testing.T) {
+	probe := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/wp-login.php"},
+		},
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+
+	tests := []struct {
+		name     string
+		opts     []config.Option
+		kind     config.ProbeKind
+		expected *corev1.Probe
+	}{
+		{
+			name:     "no overrides",
+			kind:     config.LivenessProbe,
+			expected: probe,
+		},
+		{
+			name: "timings and path",
+			opts: []config.Option{
+				config.WithProbe(config.ReadinessProbe, func(p *config.ProbeOverrides) { p.PeriodSeconds = ptr(int32(30)) }),
+				config.WithProbe(config.ReadinessProbe, func(p *config.ProbeOverrides) { p.InitialDelaySeconds = ptr(int32(15)) }),
+				config.WithProbePath("/healthz"),
+			},
+			kind: config.ReadinessProbe,
+			expected: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{Path: "/healthz"},
+				},
+				InitialDelaySeconds: 15,
+				PeriodSeconds:       30,
+				TimeoutSeconds:      5,
+				FailureThreshold:    3,
+			},
+		},
+		{
+			name: "other kind untouched",
+			opts: []config.Option{
+				config.WithProbe(config.StartupProbe, func(p *config.ProbeOverrides) { p.Disabled = true }),
+			},
+			kind:     config.LivenessProbe,
+			expected: probe,
+		},
+		{
+			name: "disabled",
+			opts: []config.Option{
+				config.WithProbe(config.StartupProbe, func(p *config.ProbeOverrides) { p.Disabled = true }),
+			},
+			kind: config.StartupProbe,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides := &config.DeploymentOverrides{}
+			for _, opt := range tt.opts {
+				opt(overrides)
+			}
+
+			got := overrides.ApplyProbe(tt.kind, probe)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ApplyProbe() = %+v, want %+v", got, tt.expected)
+			}
+			if probe.HTTPGet.Path != "/wp-login.php" || probe.PeriodSeconds != 10 {
+				t.Errorf("ApplyProbe() changed the default probe: %+v", probe)
+			}
+		})
+	}
+}
+
+func TestDeploymentOverrides_ApplySecurity(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []config.Option
+		pod           *corev1.PodSecurityContext
+		container     *corev1.SecurityContext
+		wantPod       *corev1.PodSecurityContext
+		wantContainer *corev1.SecurityContext
+	}{
+		{
+			name:          "no overrides",
+			pod:           &corev1.PodSecurityContext{RunAsUser: ptr(int64(999)), FSGroup: ptr(int64(999))},
+			container:     &corev1.SecurityContext{ReadOnlyRootFilesystem: ptr(true)},
+			wantPod:       &corev1.PodSecurityContext{RunAsUser: ptr(int64(999)), FSGroup: ptr(int64(999))},
+			wantContainer: &corev1.SecurityContext{ReadOnlyRootFilesystem: ptr(true)},
+		},
+		{
+			name: "overrides on top of the defaults",
+			opts: []config.Option{
+				config.WithRunAsUser(1000),
+				config.WithSeccompProfile("Unconfined"),
+				config.WithReadOnlyRootFilesystem(false),
+			},
+			pod:       &corev1.PodSecurityContext{RunAsUser: ptr(int64(999)), FSGroup: ptr(int64(999))},
+			container: &corev1.SecurityContext{ReadOnlyRootFilesystem: ptr(true), AllowPrivilegeEscalation: ptr(false)},
+			wantPod: &corev1.PodSecurityContext{
+				RunAsUser:      ptr(int64(1000)),
+				FSGroup:        ptr(int64(999)),
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+			},
+			wantContainer: &corev1.SecurityContext{ReadOnlyRootFilesystem: ptr(false), AllowPrivilegeEscalation: ptr(false)},
+		},
+		{
+			name:          "contexts created when missing",
+			opts:          []config.Option{config.WithFSGroup(2000), config.WithRunAsNonRoot(true), config.WithReadOnlyRootFilesystem(true)},
+			wantPod:       &corev1.PodSecurityContext{FSGroup: ptr(int64(2000)), RunAsNonRoot: ptr(true)},
+			wantContainer: &corev1.SecurityContext{ReadOnlyRootFilesystem: ptr(true)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides := &config.DeploymentOverrides{}
+			for _, opt := range tt.opts {
+				opt(overrides)
+			}
+
+			pod, container := tt.pod, tt.container
+			overrides.ApplySecurity(&pod, &container)
+			if !reflect.DeepEqual(pod, tt.wantPod) {
+				t.Errorf("pod security context = %+v, want %+v", pod, tt.wantPod)
+			}
+			if !reflect.DeepEqual(container, tt.wantContainer) {
+				t.Errorf("container security context = %+v, want %+v", container, tt.wantContainer)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,163 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kol-ratner/tufin/internal/config"
+)
+
+func TestParseValues(t *testing.T) {
+	components := []string{"mysql", "wordpress"}
+
+	tests := []struct {
+		name      string
+		values    string
+		expected  map[string]config.DeploymentOverrides
+		wantError string
+	}{
+		{
+			name: "yaml values",
+			values: `
+wordpress:
+  replicas: 2
+  memory-request: 1Gi
+mysql:
+  cpu-limit: 1
+  volume-size: 10Gi
+`,
+			expected: map[string]config.DeploymentOverrides{
+				"wordpress": {Replicas: 2, MemoryRequest: "1Gi"},
+				"mysql":     {CPULimit: "1", VolumeSize: "10Gi"},
+			},
+		},
+		{
+			name:   "json values",
+			values: `{"wordpress": {"replicas": 3, "cpu-request": "250m"}}`,
+			expected: map[string]config.DeploymentOverrides{
+				"wordpress": {Replicas: 3, CPURequest: "250m"},
+			},
+		},
+		{
+			name:   "component without options",
+			values: "mysql:\n",
+			expected: map[string]config.DeploymentOverrides{
+				"mysql": {},
+			},
+		},
+		{
+			name:     "empty document",
+			values:   "",
+			expected: map[string]config.DeploymentOverrides{},
+		},
+		{
+			name:      "unknown component",
+			values:    "wordpress:\n  replicas: 2\nredis:\n  replicas: 1\n",
+			wantError: `line 3: unknown component "redis"`,
+		},
+		{
+			name:      "unknown option",
+			values:    "wordpress:\n  replicas: 2\n  replica: 3\n",
+			wantError: `line 3: wordpress: unknown option "replica", did you mean "replicas"?`,
+		},
+		{
+			name:      "invalid replicas",
+			values:    "mysql:\n  replicas: three\n",
+			wantError: "line 2: mysql: invalid value type for replicas: three",
+		},
+		{
+			name:      "duplicate option",
+			values:    "mysql:\n  replicas: 1\n  replicas: 2\n",
+			wantError: "line 3: mysql.replicas is set more than once",
+		},
+		{
+			name:      "nested value",
+			values:    "mysql:\n  replicas:\n    count: 1\n",
+			wantError: "line 3: mysql.replicas must be a single value",
+		},
+		{
+			name: "config files",
+			values: `
+mysql:
+  replicas: 1
+  files:
+    my.cnf: |
+      [mysqld]
+      max_connections=500
+`,
+			expected: map[string]config.DeploymentOverrides{
+				"mysql": {Replicas: 1, Files: map[string]string{"my.cnf": "[mysqld]\nmax_connections=500\n"}},
+			},
+		},
+		{
+			name:      "files not a mapping",
+			values:    "mysql:\n  files: my.cnf\n",
+			wantError: "line 2: mysql.files must map file names to their content",
+		},
+		{
+			name:      "file without content",
+			values:    "wordpress:\n  files:\n    php.ini:\n",
+			wantError: "line 3: wordpress.files.php.ini must be the file's content, e.g. a | block",
+		},
+		{
+			name:      "duplicate file",
+			values:    "mysql:\n  files:\n    my.cnf: a\n    my.cnf: b\n",
+			wantError: "line 4: mysql.files.my.cnf is set more than once",
+		},
+		{
+			name:      "not a mapping",
+			values:    "- mysql\n",
+			wantError: "line 1: expected a mapping of components to their options",
+		},
+		{
+			name:      "invalid yaml",
+			values:    "mysql:\n  replicas: [1\n",
+			wantError: "yaml: line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.ParseValues([]byte(tt.values), components)
+
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("ParseValues() error = %v, want it to contain %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseValues() error = %v", err)
+			}
+
+			if len(got) != len(tt.expected) {
+				t.Errorf("got %d components, want %d", len(got), len(tt.expected))
+			}
+			for component, expected := range tt.expected {
+				overrides := config.DeploymentOverrides{}
+				for _, opt := range got[component] {
+					opt(&overrides)
+				}
+				if !reflect.DeepEqual(overrides, expected) {
+					t.Errorf("%s overrides = %+v, want %+v", component, overrides, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(path, []byte("mysql:\n  replicas: 1\nredis: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := config.LoadValues(path, []string{"mysql", "wordpress"})
+	want := path + `:3: unknown component "redis"`
+	if err == nil || err.Error() != want {
+		t.Errorf("LoadValues() error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadValues reads a values file and returns the overrides it sets per component.
+// Errors point at the offending file and line.
+func LoadValues(path string, components []string) (map[string][]Option, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := ParseValues(data, components)
+	if err != nil {
+		var valuesErr *ValuesError
+		if errors.As(err, &valuesErr) {
+			valuesErr.File = path
+			return nil, valuesErr
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// ValuesError points at the line of a values file that couldn't be used.
+type ValuesError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ValuesError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+func lineError(node *yaml.Node, format string, args ...any) error {
+	return &ValuesError{
+		Line: node.Line,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// ParseValues parses a YAML or JSON values document, which maps each component
+// to the same keys --set accepts, e.g.
+//
+//	wordpress:
+//	  replicas: 2
+//	  memory-request: 1Gi
+//	mysql:
+//	  volume-size: 10Gi
+//	  files:
+//	    my.cnf: |
+//	      [mysqld]
+//	      max_connections=500
+//
+// Only the given components are accepted, and every key goes through ParseOption,
+// so the values file always supports exactly what --set does.
+// On top of that, files holds the content of the component's config files, like --set-file does.
+func ParseValues(data []byte, components []string) (map[string][]Option, error) {
+	values := make(map[string][]Option)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	// an empty document sets nothing
+	if len(doc.Content) == 0 {
+		return values, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, lineError(root, "expected a mapping of components to their options")
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		componentNode, optsNode := root.Content[i], root.Content[i+1]
+		component := componentNode.Value
+
+		if !slices.Contains(components, component) {
+			return nil, lineError(componentNode, "%v", UnknownComponentError(component, components))
+		}
+		if _, seen := values[component]; seen {
+			return nil, lineError(componentNode, "component %q is set more than once", component)
+		}
+		values[component] = []Option{}
+
+		// a component with no options, e.g. `mysql:`, is fine and simply uses the defaults
+		if optsNode.Kind == yaml.ScalarNode && optsNode.Tag == "!!null" {
+			continue
+		}
+		if optsNode.Kind != yaml.MappingNode {
+			return nil, lineError(optsNode, "expected a mapping of options for %s", component)
+		}
+
+		seen := map[string]bool{}
+		for j := 0; j+1 < len(optsNode.Content); j += 2 {
+			keyNode, valueNode := optsNode.Content[j], optsNode.Content[j+1]
+			key := keyNode.Value
+
+			if seen[key] {
+				return nil, lineError(keyNode, "%s.%s is set more than once", component, key)
+			}
+			seen[key] = true
+
+			if key == filesKey {
+				opts, err := parseFiles(component, valueNode)
+				if err != nil {
+					return nil, err
+				}
+				values[component] = append(values[component], opts...)
+				continue
+			}
+
+			if valueNode.Kind != yaml.ScalarNode {
+				return nil, lineError(valueNode, "%s.%s must be a single value", component, key)
+			}
+
+			opt, err := ParseOption(key, valueNode.Value)
+			if err != nil {
+				return nil, lineError(keyNode, "%s: %v", component, err)
+			}
+			values[component] = append(values[component], opt)
+		}
+	}
+
+	return values, nil
+}
+
+// filesKey holds a component's config files in the values file
+const filesKey = "files"
+
+// parseFiles turns the files mapping of a component, file names to their content, into options.
+// Whether the component supports a file is up to the component.
+func parseFiles(component string, node *yaml.Node) ([]Option, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, lineError(node, "%s.%s must map file names to their content", component, filesKey)
+	}
+
+	var opts []Option
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		nameNode, contentNode := node.Content[i], node.Content[i+1]
+		name := nameNode.Value
+
+		if seen[name] {
+			return nil, lineError(nameNode, "%s.%s.%s is set more than once", component, filesKey, name)
+		}
+		seen[name] = true
+
+		if contentNode.Kind != yaml.ScalarNode || contentNode.Tag == "!!null" {
+			return nil, lineError(contentNode, "%s.%s.%s must be the file's content, e.g. a | block", component, filesKey, name)
+		}
+		opts = append(opts, WithFile(name, contentNode.Value))
+	}
+	return opts, nil
+}
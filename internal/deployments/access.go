@@ -0,0 +1,52 @@
+package deployments
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kol-ratner/tufin/internal/deployments/mysql"
+)
+
+// Access is what it takes to reach a deployed stack.
+type Access struct {
+	// URL wordpress is served at, empty when it isn't exposed through an ingress
+	URL string
+
+	// DatabaseHost is the in-cluster address of the mysql primary
+	DatabaseHost string
+
+	// Database, User and Password are what wordpress connects to mysql with, the root user shares the password
+	Database string
+	User     string
+	Password string
+}
+
+// GetAccess returns how to reach the stack Ship deployed with the same options and configs.
+// The configs decide wordpress' URL, the password is read from the credentials secret.
+func GetAccess(cli kubernetes.Interface, opts ShipOptions, configs ...DeploymentConfig) (Access, error) {
+	_, apps, err := build(cli, opts, configs)
+	if err != nil {
+		return Access{}, err
+	}
+
+	access := Access{
+		Database: mysql.DatabaseName,
+		User:     mysql.DatabaseUser,
+	}
+	if wp, ok := apps["wordpress"]; ok {
+		access.URL = wp.URL()
+	}
+
+	// mysql may not be among the configs, wordpress uses the one of its release all the same
+	db, err := components["mysql"].new(cli, componentOptions(opts.Namespace, opts.Release, nil)...)
+	if err != nil {
+		return Access{}, err
+	}
+	access.DatabaseHost = fmt.Sprintf("%s.%s:%d", mysql.PrimaryHost(db.Config.Name), db.Config.Namespace, db.Config.Svc.Port)
+
+	if access.Password, err = mysql.Password(cli, componentOptions(opts.Namespace, opts.Release, nil)...); err != nil {
+		return Access{}, fmt.Errorf("failed to read the mysql credentials: %w", err)
+	}
+	return access, nil
+}
@@ -1,62 +1,406 @@
 package deployments
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/kol-ratner/tufin/internal/config"
 	"github.com/kol-ratner/tufin/internal/deployments/mysql"
 	"github.com/kol-ratner/tufin/internal/deployments/wordpress"
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
 )
 
+// DefaultTimeout is how long Ship waits for components to become ready when no timeout is given.
+const DefaultTimeout = 5 * time.Minute
+
 type DeploymentConfig struct {
 	Component string
 	Options   []config.Option
 }
 
-func Ship(msgChan chan<- string, cli kubernetes.Interface, configs ...DeploymentConfig) error {
+// ShipOptions controls how Ship deploys components.
+type ShipOptions struct {
+	// Namespace the components are deployed to, it is created if it doesn't exist yet.
+	// Leaving it empty deploys to the "default" namespace.
+	Namespace string
 
-	// If no configs provided, deploy everything with defaults
-	if len(configs) == 0 {
-		return deployAll(cli, msgChan)
+	// Release prefixes the names of every object Ship creates, so several
+	// independent stacks can live side by side in one namespace.
+	Release string
+
+	// ForceConflicts takes over fields that another field manager owns,
+	// rather than failing the deployment with a conflict.
+	ForceConflicts bool
+
+	// Wait blocks until every deployed component has rolled out and its pods are ready.
+	// Ship fails if they don't get there in time.
+	Wait bool
+
+	// Timeout bounds all the waiting Ship does, both for Wait and for the dependencies
+	// a component needs ready before it is applied. Zero means DefaultTimeout.
+	Timeout time.Duration
+
+	// RotateCredentials generates a new MySQL password and applies it inside the database.
+	// Without it, the password stored in an existing credentials secret is kept as is.
+	RotateCredentials bool
+
+	// DryRun sends everything to the API server as a server-side dry run,
+	// which validates the objects and runs admission without persisting them.
+	// Nothing is waited for, and it can't be combined with Wait or RotateCredentials.
+	DryRun bool
+}
+
+// DestroyOptions controls how Destroy tears down components.
+type DestroyOptions struct {
+	// Namespace the components were deployed to, empty means "default".
+	Namespace string
+
+	// Release the components were deployed under, empty means no release.
+	Release string
+
+	// KeepData preserves the components' PersistentVolumeClaims
+	// so that a subsequent deploy picks up the existing data.
+	KeepData bool
+}
+
+// Ship deploys the given components, or all of them when none are given.
+// Components are applied in dependency order, and a component is only applied
+// once the components it depends on report ready.
+func Ship(msgChan chan<- string, cli kubernetes.Interface, opts ShipOptions, configs ...DeploymentConfig) error {
+
+	if opts.DryRun && (opts.Wait || opts.RotateCredentials) {
+		return fmt.Errorf("a dry run can't wait for readiness or rotate credentials")
 	}
 
-	// Deploy selected components with their options
-	for _, cfg := range configs {
-		switch cfg.Component {
-		case "mysql":
-			mysql := mysql.New(cli, cfg.Options...)
-			if err := mysql.Deploy(); err != nil {
+	order, apps, err := build(cli, opts, configs)
+	if err != nil {
+		return err
+	}
+	if app, ok := apps["mysql"]; ok {
+		if err := checkLegacyMySQL(cli, app); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		return dryRun(msgChan, cli, opts.Namespace, order, apps)
+	}
+
+	if opts.Namespace != "" {
+		created, err := k8sapp.EnsureNamespace(cli, opts.Namespace)
+		if err != nil {
+			return err
+		}
+		if created {
+			msgChan <- fmt.Sprintf("created namespace %s", opts.Namespace)
+		}
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	// one deadline covers all the waiting Ship does, for dependencies as well as for Wait
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	shipped := map[string]k8sapp.Application{}
+	ready := map[string]bool{}
+	rotated := false
+
+	for _, name := range order {
+		for _, dep := range components[name].dependsOn {
+			if ready[dep] {
+				continue
+			}
+
+			depApp, ok := shipped[dep]
+			if !ok {
+				// the dependency isn't part of this run, so it has to be deployed already
+				var err error
+				depApp, err = components[dep].new(cli, componentOptions(opts.Namespace, opts.Release, nil)...)
+				if err != nil {
+					return err
+				}
+				exists, err := depApp.Exists()
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return fmt.Errorf("%s depends on %s, which is not deployed: deploy %s first or include it in this deploy", name, dep, dep)
+				}
+			}
+
+			msgChan <- fmt.Sprintf("waiting for %s to be ready before deploying %s", dep, name)
+			if err := depApp.WaitReady(ctx, msgChan); err != nil {
+				return err
+			}
+			ready[dep] = true
+		}
+
+		if name == "mysql" && opts.RotateCredentials {
+			// the rotation Job runs with the security contexts of mysql's own pods, so it needs the same options
+			ok, err := mysql.RotatePassword(cli, componentOptions(opts.Namespace, opts.Release, optionsFor(configs, name))...)
+			if err != nil {
 				return err
 			}
-			msgChan <- "successfully triggered mysql deployment"
+			if ok {
+				rotated = true
+				msgChan <- "successfully rotated mysql credentials"
+			}
+		}
+
+		app := apps[name]
+		if err := app.Deploy(); err != nil {
+			return err
+		}
+		msgChan <- fmt.Sprintf("successfully triggered %s deployment", name)
+		shipped[name] = app
+	}
+
+	// wordpress only reads the database password on startup,
+	// so its pods have to be replaced to pick up a rotated one
+	if rotated {
+		wp, err := wordpress.New(cli, componentOptions(opts.Namespace, opts.Release, nil)...)
+		if err != nil {
+			return err
+		}
+		if err := wp.Restart(); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		msgChan <- "restarted wordpress to pick up the new mysql credentials"
+	}
 
-		case "wordpress":
-			wp := wordpress.New(cli, cfg.Options...)
-			if err := wp.Deploy(); err != nil {
+	if opts.Wait {
+		for _, name := range order {
+			if ready[name] {
+				continue
+			}
+			app := shipped[name]
+			if err := app.WaitReady(ctx, msgChan); err != nil {
 				return err
 			}
-			msgChan <- "successfully triggered wordpress deployment"
-		default:
-			return fmt.Errorf("unsupported component: %s", cfg.Component)
 		}
 	}
+
+	for _, name := range order {
+		app := shipped[name]
+		if url := app.URL(); url != "" {
+			msgChan <- fmt.Sprintf("%s is served at %s", name, url)
+		}
+	}
+
+	return nil
+}
+
+// Manifests returns the objects Ship would apply for the given components, or all of them when none are given,
+// in the order Ship applies them. They're built from the options alone, without contacting the cluster.
+func Manifests(opts ShipOptions, configs ...DeploymentConfig) ([]runtime.Object, error) {
+	order, apps, err := build(nil, opts, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []runtime.Object
+	for _, name := range order {
+		app := apps[name]
+		appObjs, err := app.Objects()
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, appObjs...)
+	}
+	return objs, nil
+}
+
+// Diff compares the given components, or all of them when none are given, with what is deployed in the cluster.
+// It returns a diff for every object the next Ship would change, in the order Ship applies them.
+func Diff(cli kubernetes.Interface, opts ShipOptions, configs ...DeploymentConfig) ([]k8sapp.ObjectDiff, error) {
+	order, apps, err := build(cli, opts, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []k8sapp.ObjectDiff
+	for _, name := range order {
+		app := apps[name]
+		appDiffs, err := app.Diff(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, appDiffs...)
+	}
+	return diffs, nil
+}
+
+// build groups the configs per component and builds each component's application, in deployment order.
+// All of them are built before anything touches the cluster, so invalid options
+// fail the whole deploy instead of leaving it half applied.
+// No configs means every component with its defaults.
+func build(cli kubernetes.Interface, opts ShipOptions, configs []DeploymentConfig) ([]string, map[string]k8sapp.Application, error) {
+	if len(configs) == 0 {
+		for _, name := range Components() {
+			configs = append(configs, DeploymentConfig{Component: name})
+		}
+	}
+
+	// the order configs come in doesn't matter
+	var names []string
+	componentOpts := map[string][]config.Option{}
+	for _, cfg := range configs {
+		if _, seen := componentOpts[cfg.Component]; !seen {
+			names = append(names, cfg.Component)
+		}
+		componentOpts[cfg.Component] = append(componentOpts[cfg.Component], cfg.Options...)
+	}
+
+	order, err := deployOrder(names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateRelease(opts.Release); err != nil {
+		return nil, nil, err
+	}
+
+	apps := map[string]k8sapp.Application{}
+	for _, name := range order {
+		app, err := components[name].new(cli, componentOptions(opts.Namespace, opts.Release, componentOpts[name])...)
+		if err != nil {
+			return nil, nil, err
+		}
+		app.ForceConflicts = opts.ForceConflicts
+		app.DryRun = opts.DryRun
+		apps[name] = app
+	}
+	return order, apps, nil
+}
+
+// dryRun applies the components as a server-side dry run.
+// The namespace has to exist already, since the API server can't validate objects for a namespace it doesn't have.
+func dryRun(msgChan chan<- string, cli kubernetes.Interface, namespace string, order []string, apps map[string]k8sapp.Application) error {
+	if namespace != "" {
+		_, err := cli.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("namespace %s doesn't exist, create it before a server-side dry run", namespace)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range order {
+		app := apps[name]
+		if err := app.Deploy(); err != nil {
+			return fmt.Errorf("%s failed the server-side dry run: %w", name, err)
+		}
+		msgChan <- fmt.Sprintf("%s passed the server-side dry run", name)
+	}
 	return nil
 }
 
-func deployAll(cli kubernetes.Interface, msgChan chan<- string) error {
-	mysql := mysql.New(cli)
-	if err := mysql.Deploy(); err != nil {
+// Destroy removes everything Ship created for the given components.
+// If no components are provided, all of them are destroyed.
+// Components are removed in the reverse of the order Ship creates them,
+// so nothing is left running without the components it depends on.
+func Destroy(msgChan chan<- string, cli kubernetes.Interface, opts DestroyOptions, names ...string) error {
+	if len(names) == 0 {
+		names = Components()
+	}
+
+	order, err := deployOrder(names)
+	if err != nil {
 		return err
 	}
-	msgChan <- "successfully triggered mysql deployment"
 
-	wp := wordpress.New(cli)
-	if err := wp.Deploy(); err != nil {
+	if err := validateRelease(opts.Release); err != nil {
 		return err
 	}
-	msgChan <- "successfully triggered wordpress deployment"
 
+	options := componentOptions(opts.Namespace, opts.Release, nil)
+
+	for i := len(order) - 1; i >= 0; i-- {
+		name := order[i]
+		app, err := components[name].new(cli, options...)
+		if err != nil {
+			return err
+		}
+
+		if opts.KeepData {
+			app.Resources = withoutResource(app.Resources, k8sapp.PVC)
+		}
+
+		if err := app.Delete(); err != nil {
+			return err
+		}
+		if !opts.KeepData {
+			if err := app.DeleteVolumeClaims(); err != nil {
+				return err
+			}
+		}
+		// stacks deployed by earlier versions ran mysql as a Deployment with a volume claim of its own
+		if name == "mysql" {
+			if err := deleteLegacyMySQL(app, opts.KeepData); err != nil {
+				return err
+			}
+		}
+		msgChan <- fmt.Sprintf("successfully destroyed %s", name)
+	}
+	return nil
+}
+
+// optionsFor collects the options the configs give the named component
+func optionsFor(configs []DeploymentConfig, name string) []config.Option {
+	var opts []config.Option
+	for _, cfg := range configs {
+		if cfg.Component == name {
+			opts = append(opts, cfg.Options...)
+		}
+	}
+	return opts
+}
+
+// componentOptions puts the namespace and release in front of the component's own options,
+// empty values leave the component's defaults in place
+func componentOptions(namespace, release string, opts []config.Option) []config.Option {
+	var options []config.Option
+	if namespace != "" {
+		options = append(options, config.WithNamespace(namespace))
+	}
+	if release != "" {
+		options = append(options, config.WithRelease(release))
+	}
+	return append(options, opts...)
+}
+
+// validateRelease makes sure every object name derived from the release is a valid service name,
+// the strictest naming rule among the objects we create
+func validateRelease(release string) error {
+	if release == "" {
+		return nil
+	}
+	for _, component := range []string{"mysql", "wordpress"} {
+		if errs := validation.IsDNS1035Label(config.ReleaseName(release, component)); len(errs) > 0 {
+			return fmt.Errorf("invalid release name %q: %s", release, strings.Join(errs, ", "))
+		}
+	}
 	return nil
 }
+
+func withoutResource(resources []k8sapp.KubernetesResource, drop k8sapp.KubernetesResource) []k8sapp.KubernetesResource {
+	var kept []k8sapp.KubernetesResource
+	for _, r := range resources {
+		if r != drop {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,553 @@
+package deployments
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
+)
+
+// ExportFormat is a layout Export can write manifests in.
+type ExportFormat string
+
+const (
+	// ExportYAML writes the plain manifests, one multi-document file per component.
+	ExportYAML ExportFormat = "yaml"
+
+	// ExportHelm writes a Helm chart whose values.yaml has the same per-component keys as a values file.
+	ExportHelm ExportFormat = "helm"
+
+	// ExportKustomize writes a kustomize base and an overlay for the target namespace.
+	ExportKustomize ExportFormat = "kustomize"
+)
+
+// ExportFormats returns the name of every format Export supports.
+func ExportFormats() []string {
+	return []string{string(ExportYAML), string(ExportHelm), string(ExportKustomize)}
+}
+
+// Export writes the manifests Ship would apply for the given components, or all of them when none are given,
+// to dir in the given format. It returns the paths of the files it wrote.
+// Nothing is read from the cluster, so the exported credentials are freshly generated,
+// except for the Helm chart which keeps the ones of an existing release.
+func Export(format ExportFormat, dir string, opts ShipOptions, configs ...DeploymentConfig) ([]string, error) {
+	order, apps, err := build(nil, opts, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &exportWriter{dir: dir}
+	switch format {
+	case ExportYAML:
+		err = exportYAML(w, order, apps)
+	case ExportHelm:
+		err = exportHelm(w, opts.Release, order, apps)
+	case ExportKustomize:
+		namespace := opts.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		err = exportKustomize(w, namespace, order, apps)
+	default:
+		err = fmt.Errorf("unsupported export format %q, must be one of: %s", format, strings.Join(ExportFormats(), ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w.files, nil
+}
+
+func exportYAML(w *exportWriter, order []string, apps map[string]k8sapp.Application) error {
+	for _, name := range order {
+		app := apps[name]
+		objs, err := app.Objects()
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := k8sapp.WriteManifests(&buf, objs, false); err != nil {
+			return err
+		}
+		// the file holds the generated credentials
+		if err := w.write(name+".yaml", buf.Bytes(), 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportKustomize writes a namespace-less base, where secrets come from a generator reading git-ignored env files,
+// and an overlay that puts the base into namespace
+func exportKustomize(w *exportWriter, namespace string, order []string, apps map[string]k8sapp.Application) error {
+	var resources []string
+	var generators []map[string]interface{}
+
+	for _, name := range order {
+		docs, secrets, err := manifests(apps[name])
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			unstructured.RemoveNestedField(doc, "metadata", "namespace")
+		}
+
+		data, err := yamlDocuments(docs)
+		if err != nil {
+			return err
+		}
+		file := name + ".yaml"
+		if err := w.write(filepath.Join("base", file), data, 0o644); err != nil {
+			return err
+		}
+		resources = append(resources, file)
+
+		for _, secret := range secrets {
+			envFile := secret.Name + ".env"
+			if err := w.write(filepath.Join("base", envFile), envData(secret.Data), 0o600); err != nil {
+				return err
+			}
+			generators = append(generators, map[string]interface{}{
+				"name": secret.Name,
+				"type": string(secret.Type),
+				"envs": []string{envFile},
+				"options": map[string]interface{}{
+					"labels": secret.Labels,
+					// the workloads reference the secret by its plain name
+					"disableNameSuffixHash": true,
+				},
+			})
+		}
+	}
+
+	base := map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  resources,
+	}
+	if len(generators) > 0 {
+		base["secretGenerator"] = generators
+		if err := w.write(filepath.Join("base", ".gitignore"), []byte("# generated credentials, keep them out of version control\n*.env\n"), 0o644); err != nil {
+			return err
+		}
+	}
+	if err := w.writeYAML(filepath.Join("base", "kustomization.yaml"), base); err != nil {
+		return err
+	}
+
+	overlay := map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"namespace":  namespace,
+		"resources":  []string{"../../base"},
+	}
+	return w.writeYAML(filepath.Join("overlays", namespace, "kustomization.yaml"), overlay)
+}
+
+// exportHelm writes a chart with a template per component.
+// The values.yaml mirrors config.DeploymentOverrides, so it doubles as a tufin values file.
+func exportHelm(w *exportWriter, release string, order []string, apps map[string]k8sapp.Application) error {
+	chartName := "tufin"
+	if release != "" {
+		chartName = release
+	}
+	chart := map[string]interface{}{
+		"apiVersion":  "v2",
+		"name":        chartName,
+		"description": "WordPress and MySQL, exported by tufin. Installs once per namespace, the object names are fixed at export.",
+		"type":        "application",
+		"version":     "0.1.0",
+	}
+	if err := w.writeYAML(filepath.Join(chartName, "Chart.yaml"), chart); err != nil {
+		return err
+	}
+	if err := w.write(filepath.Join(chartName, "README.md"), helmReadme(chartName, release, order, apps), 0o644); err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	for _, name := range order {
+		app := apps[name]
+		values[name] = componentValues(app)
+
+		tmpl, err := helmTemplate(name, app)
+		if err != nil {
+			return err
+		}
+		if err := w.write(filepath.Join(chartName, "templates", name+".yaml"), tmpl, 0o644); err != nil {
+			return err
+		}
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	header := "# Options per component, with the same keys as tufin's --set and values files.\n"
+	return w.write(filepath.Join(chartName, "values.yaml"), append([]byte(header), data...), 0o644)
+}
+
+// helmReadme explains that the chart's object names come from the release it was exported for, not Helm's,
+// so a second install into the same namespace would collide with the first
+func helmReadme(chartName, release string, order []string, apps map[string]k8sapp.Application) []byte {
+	var names []string
+	for _, name := range order {
+		names = append(names, "`"+apps[name].Config.Name+"`")
+	}
+	exportedFor := "without a release"
+	if release != "" {
+		exportedFor = fmt.Sprintf("for the %s release", release)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n\n", chartName)
+	b.WriteString("WordPress and MySQL, exported by tufin. values.yaml has the same per-component keys as a tufin values file.\n\n")
+	b.WriteString("## One install per namespace\n\n")
+	fmt.Fprintf(&b, "The chart was exported %s, and its objects are named after it (%s), ", exportedFor, strings.Join(names, ", "))
+	b.WriteString("whatever name it's installed under. Their selectors and `app.kubernetes.io/instance` labels are fixed the same way, ")
+	b.WriteString("so a second install into the same namespace would collide with the first.\n\n")
+	b.WriteString("For a second stack in the namespace, export another chart with `tufin export --format helm --release <name>`, ")
+	b.WriteString("or install this one into another namespace.\n")
+	return b.Bytes()
+}
+
+// componentValues returns the effective value of every option the component supports
+func componentValues(app k8sapp.Application) map[string]interface{} {
+	cfg := app.Config
+	values := map[string]interface{}{}
+
+	for _, r := range app.Resources {
+		switch r {
+		case k8sapp.Deployment:
+			values["replicas"] = cfg.Deployment.Replicas
+			for key, q := range resourceQuantities(cfg.Deployment.Resources) {
+				values[key] = q
+			}
+		case k8sapp.StatefulSet:
+			values["replicas"] = cfg.Deployment.Replicas
+			for key, q := range resourceQuantities(cfg.Deployment.Resources) {
+				values[key] = q
+			}
+			// volume-size sets the first, data, volume
+			if len(cfg.StatefulSet.VolumeClaimTemplates) > 0 {
+				values["volume-size"] = cfg.StatefulSet.VolumeClaimTemplates[0].Size
+			}
+		case k8sapp.PVC:
+			values["volume-size"] = cfg.Pvc.Size
+		case k8sapp.Ingress:
+			values["host"] = cfg.Ingress.Host
+			values["path"] = "/"
+			if cfg.Ingress.Path != "" {
+				values["path"] = cfg.Ingress.Path
+			}
+			if cfg.Ingress.ClassName != "" {
+				values["ingress-class"] = cfg.Ingress.ClassName
+			}
+			if cfg.Ingress.TLSSecretName != "" {
+				values["tls-secret"] = cfg.Ingress.TLSSecretName
+			}
+		}
+	}
+	return values
+}
+
+// resourceQuantities maps the option keys for requests and limits to the quantities set in res
+func resourceQuantities(res corev1.ResourceRequirements) map[string]string {
+	quantities := map[string]string{}
+	for _, r := range []struct {
+		key  string
+		list corev1.ResourceList
+		name corev1.ResourceName
+	}{
+		{"cpu-request", res.Requests, corev1.ResourceCPU},
+		{"memory-request", res.Requests, corev1.ResourceMemory},
+		{"cpu-limit", res.Limits, corev1.ResourceCPU},
+		{"memory-limit", res.Limits, corev1.ResourceMemory},
+	} {
+		if q, ok := r.list[r.name]; ok {
+			quantities[r.key] = q.String()
+		}
+	}
+	return quantities
+}
+
+// helmTemplate renders the component's manifests with the options, the namespace and the credentials templated
+func helmTemplate(component string, app k8sapp.Application) ([]byte, error) {
+	objs, err := app.Objects()
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTemplater()
+	value := func(key string) string {
+		return fmt.Sprintf(`{{ index .Values %q %q }}`, component, key)
+	}
+	quoted := func(key string) string {
+		return fmt.Sprintf(`{{ index .Values %q %q | quote }}`, component, key)
+	}
+
+	var docs [][]byte
+	for _, obj := range objs {
+		u, err := k8sapp.Manifest(obj)
+		if err != nil {
+			return nil, err
+		}
+		if err := unstructured.SetNestedField(u, t.placeholder("{{ .Release.Namespace }}"), "metadata", "namespace"); err != nil {
+			return nil, err
+		}
+
+		var prefix string
+		switch o := obj.(type) {
+		case *corev1.Secret:
+			// keep the credentials of an existing release, a new password wouldn't match the database's data
+			prefix = fmt.Sprintf("{{- $existing := lookup \"v1\" \"Secret\" .Release.Namespace %q }}\n", o.Name)
+			data := map[string]interface{}{}
+			for key, v := range o.Data {
+				data[key] = t.placeholder(fmt.Sprintf(
+					`{{ if $existing }}{{ index $existing.data %q }}{{ else }}{{ randAlphaNum %d | b64enc }}{{ end }}`, key, len(v)))
+			}
+			u["data"] = data
+		case *corev1.PersistentVolumeClaim:
+			if err := unstructured.SetNestedField(u, t.placeholder(quoted("volume-size")), "spec", "resources", "requests", "storage"); err != nil {
+				return nil, err
+			}
+		case *appsv1.Deployment:
+			if err := unstructured.SetNestedField(u, t.placeholder(value("replicas")), "spec", "replicas"); err != nil {
+				return nil, err
+			}
+			if err := templateResources(u, t, quoted); err != nil {
+				return nil, err
+			}
+		case *networkingv1.Ingress:
+			if err := templateIngress(u, t, quoted); err != nil {
+				return nil, err
+			}
+		case *appsv1.StatefulSet:
+			if err := unstructured.SetNestedField(u, t.placeholder(value("replicas")), "spec", "replicas"); err != nil {
+				return nil, err
+			}
+			if err := templateResources(u, t, quoted); err != nil {
+				return nil, err
+			}
+			if err := templateClaimSize(u, t, quoted); err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := yaml.Marshal(u)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, append([]byte(prefix), t.render(data)...))
+	}
+	return bytes.Join(docs, []byte("---\n")), nil
+}
+
+// templateIngress templates the host and path of an Ingress's rule, and its class and TLS secret if it has them
+func templateIngress(u map[string]interface{}, t *templater, quoted func(string) string) error {
+	if _, found, _ := unstructured.NestedString(u, "spec", "ingressClassName"); found {
+		if err := unstructured.SetNestedField(u, t.placeholder(quoted("ingress-class")), "spec", "ingressClassName"); err != nil {
+			return err
+		}
+	}
+
+	rules, _, err := unstructured.NestedSlice(u, "spec", "rules")
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if err := unstructured.SetNestedField(rule, t.placeholder(quoted("host")), "host"); err != nil {
+			return err
+		}
+		paths, _, err := unstructured.NestedSlice(rule, "http", "paths")
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if path, ok := p.(map[string]interface{}); ok {
+				path["path"] = t.placeholder(quoted("path"))
+			}
+		}
+		if err := unstructured.SetNestedSlice(rule, paths, "http", "paths"); err != nil {
+			return err
+		}
+	}
+	if err := unstructured.SetNestedSlice(u, rules, "spec", "rules"); err != nil {
+		return err
+	}
+
+	tlsList, found, err := unstructured.NestedSlice(u, "spec", "tls")
+	if err != nil || !found {
+		return err
+	}
+	for _, entry := range tlsList {
+		tls, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tls["secretName"] = t.placeholder(quoted("tls-secret"))
+		tls["hosts"] = []interface{}{t.placeholder(quoted("host"))}
+	}
+	return unstructured.SetNestedSlice(u, tlsList, "spec", "tls")
+}
+
+// templateClaimSize templates the size of a StatefulSet's first volume claim template, the one volume-size sets
+func templateClaimSize(u map[string]interface{}, t *templater, quoted func(string) string) error {
+	claims, _, err := unstructured.NestedSlice(u, "spec", "volumeClaimTemplates")
+	if err != nil || len(claims) == 0 {
+		return err
+	}
+	claim, ok := claims[0].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if err := unstructured.SetNestedField(claim, t.placeholder(quoted("volume-size")), "spec", "resources", "requests", "storage"); err != nil {
+		return err
+	}
+	return unstructured.SetNestedSlice(u, claims, "spec", "volumeClaimTemplates")
+}
+
+// templateResources templates the requests and limits of every container in a workload's pod template
+func templateResources(u map[string]interface{}, t *templater, quoted func(string) string) error {
+	path := []string{"spec", "template", "spec", "containers"}
+	containers, _, err := unstructured.NestedSlice(u, path...)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, r := range []struct {
+			key, list, name string
+		}{
+			{"cpu-request", "requests", "cpu"},
+			{"memory-request", "requests", "memory"},
+			{"cpu-limit", "limits", "cpu"},
+			{"memory-limit", "limits", "memory"},
+		} {
+			if _, found, _ := unstructured.NestedFieldNoCopy(container, "resources", r.list, r.name); !found {
+				continue
+			}
+			if err := unstructured.SetNestedField(container, t.placeholder(quoted(r.key)), "resources", r.list, r.name); err != nil {
+				return err
+			}
+		}
+	}
+	return unstructured.SetNestedSlice(u, containers, path...)
+}
+
+// templater swaps Go template expressions in for plain placeholders after marshalling,
+// since the YAML encoder would otherwise quote them into strings
+type templater struct {
+	exprs map[string]string
+}
+
+func newTemplater() *templater {
+	return &templater{exprs: map[string]string{}}
+}
+
+func (t *templater) placeholder(expr string) string {
+	token := fmt.Sprintf("__tufin_template_%d__", len(t.exprs))
+	t.exprs[token] = expr
+	return token
+}
+
+func (t *templater) render(data []byte) []byte {
+	for token, expr := range t.exprs {
+		data = bytes.ReplaceAll(data, []byte(token), []byte(expr))
+	}
+	return data
+}
+
+// manifests splits the application's objects into the manifests of its regular objects and its secrets
+func manifests(app k8sapp.Application) ([]map[string]interface{}, []*corev1.Secret, error) {
+	objs, err := app.Objects()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var docs []map[string]interface{}
+	var secrets []*corev1.Secret
+	for _, obj := range objs {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			secrets = append(secrets, secret)
+			continue
+		}
+		u, err := k8sapp.Manifest(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, u)
+	}
+	return docs, secrets, nil
+}
+
+func yamlDocuments(docs []map[string]interface{}) ([]byte, error) {
+	var out [][]byte
+	for _, doc := range docs {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return bytes.Join(out, []byte("---\n")), nil
+}
+
+// envData renders secret data as an env file, the format kustomize's secretGenerator reads
+func envData(data map[string][]byte) []byte {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s=%s\n", k, data[k])
+	}
+	return buf.Bytes()
+}
+
+// exportWriter writes files below dir and remembers which ones it wrote
+type exportWriter struct {
+	dir   string
+	files []string
+}
+
+func (w *exportWriter) write(name string, data []byte, perm os.FileMode) error {
+	path := filepath.Join(w.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	w.files = append(w.files, path)
+	return nil
+}
+
+func (w *exportWriter) writeYAML(name string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.write(name, data, 0o644)
+}
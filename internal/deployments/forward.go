@@ -0,0 +1,80 @@
+package deployments
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kol-ratner/tufin/pkg/k8s"
+)
+
+// ForwardOptions controls how Forward reaches a component.
+type ForwardOptions struct {
+	// Namespace the component was deployed to, empty means "default".
+	Namespace string
+
+	// Release the component was deployed under, empty means no release.
+	Release string
+
+	// LocalPort to listen on, zero uses the component's default: 8080 for wordpress, 3306 for mysql.
+	LocalPort int
+
+	// Address to listen on, empty means localhost.
+	Address string
+
+	// Ready is called with the local address every time the forward is (re)established, it may be nil.
+	Ready func(address string)
+}
+
+// Forward forwards a local port to a ready pod of the component, picked by the labels Ship gives its pods.
+// For mysql that's always the primary, the only pod that accepts writes.
+// It keeps forwarding across pod replacements, until ctx is done.
+func Forward(ctx context.Context, msgChan chan<- string, cli *k8s.Client, name string, opts ForwardOptions) error {
+	c, ok := components[name]
+	if !ok {
+		return fmt.Errorf("unsupported component: %s", name)
+	}
+	if err := validateRelease(opts.Release); err != nil {
+		return err
+	}
+
+	app, err := c.new(cli, componentOptions(opts.Namespace, opts.Release, nil)...)
+	if err != nil {
+		return err
+	}
+
+	localPort := opts.LocalPort
+	if localPort == 0 {
+		localPort = c.localPort
+	}
+	host := opts.Address
+	if host == "" {
+		host = "localhost"
+	}
+
+	var podName string
+	if c.forwardPod != nil {
+		podName = c.forwardPod(app.Config.Name)
+	}
+
+	return cli.PortForward(ctx, msgChan, k8s.PortForwardOptions{
+		Namespace:  app.Config.Namespace,
+		Selector:   app.Config.Deployment.SelectorMatchLabels,
+		PodName:    podName,
+		RemotePort: int(app.Config.Deployment.ContainerPort),
+		LocalPort:  localPort,
+		Address:    opts.Address,
+		Ready: func(port int) {
+			if opts.Ready != nil {
+				opts.Ready(forwardAddress(c.scheme, host, port))
+			}
+		},
+	})
+}
+
+// forwardAddress is how the forwarded component is reached locally, a URL when it speaks HTTP
+func forwardAddress(scheme, host string, port int) string {
+	if scheme == "" {
+		return fmt.Sprintf("%s:%d", host, port)
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port)
+}
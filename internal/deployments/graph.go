@@ -0,0 +1,117 @@
+package deployments
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kol-ratner/tufin/internal/config"
+	"github.com/kol-ratner/tufin/internal/deployments/mysql"
+	"github.com/kol-ratner/tufin/internal/deployments/wordpress"
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
+)
+
+// component is an application Ship knows how to deploy
+type component struct {
+	// new builds the component's application from the user's options
+	new func(cliSet kubernetes.Interface, opts ...config.Option) (k8sapp.Application, error)
+
+	// dependsOn lists the components that have to be ready before this one is applied
+	dependsOn []string
+
+	// localPort is the local port Forward listens on unless told otherwise
+	localPort int
+
+	// scheme prefixes the address Forward prints, empty prints just host and port
+	scheme string
+
+	// forwardPod names the pod Forward connects to given the component's name, nil means any ready pod of it
+	forwardPod func(name string) string
+}
+
+var components = map[string]component{
+	"mysql": {
+		new:       mysql.New,
+		localPort: 3306,
+		// replicas are read-only, writes have to reach the primary
+		forwardPod: mysql.PrimaryPod,
+	},
+	"wordpress": {
+		new:       wordpress.New,
+		dependsOn: []string{"mysql"},
+		localPort: 8080,
+		scheme:    "http",
+	},
+}
+
+// Components returns the name of every component Ship can deploy, in deployment order.
+func Components() []string {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	// the registry is complete, so ordering it can only fail on a dependency cycle,
+	// which is a programming error caught by the tests
+	ordered, err := deployOrder(names)
+	if err != nil {
+		panic(err)
+	}
+	return ordered
+}
+
+// deployOrder sorts the named components so that each one comes after the components it depends on.
+// Dependencies that aren't among names don't affect the order, Ship waits for those separately.
+// Components with no ordering constraint between them are sorted by name, so the result is stable.
+func deployOrder(names []string) ([]string, error) {
+	requested := map[string]bool{}
+	for _, name := range names {
+		if _, ok := components[name]; !ok {
+			return nil, fmt.Errorf("unsupported component: %s", name)
+		}
+		requested[name] = true
+	}
+
+	// number of requested dependencies each component is still waiting on
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for name := range requested {
+		pending[name] = 0
+		for _, dep := range components[name].dependsOn {
+			if requested[dep] {
+				pending[name]++
+				dependents[dep] = append(dependents[dep], name)
+			}
+		}
+	}
+
+	var ordered []string
+	for len(pending) > 0 {
+		var next []string
+		for name, count := range pending {
+			if count == 0 {
+				next = append(next, name)
+			}
+		}
+		if len(next) == 0 {
+			var cycle []string
+			for name := range pending {
+				cycle = append(cycle, name)
+			}
+			sort.Strings(cycle)
+			return nil, fmt.Errorf("dependency cycle between components: %s", strings.Join(cycle, ", "))
+		}
+
+		sort.Strings(next)
+		for _, name := range next {
+			delete(pending, name)
+			for _, dependent := range dependents[name] {
+				pending[dependent]--
+			}
+		}
+		ordered = append(ordered, next...)
+	}
+
+	return ordered, nil
+}
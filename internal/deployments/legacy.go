@@ -0,0 +1,49 @@
+package deployments
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kol-ratner/tufin/internal/deployments/mysql"
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
+)
+
+// checkLegacyMySQL fails when mysql still runs as the Deployment earlier versions created.
+// Its pod carries the same labels as the StatefulSet's, so the service would send traffic to both,
+// and the StatefulSet starts on new, empty volumes. The data has to be moved over by hand.
+func checkLegacyMySQL(cli kubernetes.Interface, app k8sapp.Application) error {
+	name, namespace := app.Config.Name, app.Config.Namespace
+	_, err := cli.AppsV1().Deployments(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf(`%[1]s runs as a Deployment, which this version replaced with a StatefulSet on new volumes.
+Move the data over before deploying:
+  1. back up the database:
+     kubectl exec -n %[2]s deploy/%[1]s -- sh -c 'mysqldump -uroot -p"$MYSQL_ROOT_PASSWORD" --databases %[3]s' > backup.sql
+  2. remove the old Deployment, its %[1]s volume claim is kept:
+     kubectl delete deployment %[1]s -n %[2]s
+  3. deploy again, which creates the StatefulSet
+  4. restore the backup into the primary:
+     kubectl exec -i -n %[2]s %[1]s-0 -c %[1]s -- sh -c 'mysql -uroot -p"$MYSQL_ROOT_PASSWORD"' < backup.sql
+  5. once the site looks right, remove the old volume claim:
+     kubectl delete pvc %[1]s -n %[2]s`, name, namespace, mysql.DatabaseName)
+}
+
+// deleteLegacyMySQL removes the Deployment earlier versions ran mysql as, and its volume claim unless keepData is set.
+// Both were named after the component, like the StatefulSet that replaced them.
+func deleteLegacyMySQL(app k8sapp.Application, keepData bool) error {
+	app.Resources = []k8sapp.KubernetesResource{k8sapp.Deployment}
+	if !keepData {
+		app.Resources = append(app.Resources, k8sapp.PVC)
+	}
+	return app.Delete()
+}
@@ -2,7 +2,10 @@ package mysql
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
@@ -11,24 +14,89 @@ import (
 	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
 )
 
-func New(cliSet kubernetes.Interface, opts ...config.Option) k8sapp.Application {
+// configFiles are the config files that can be supplied for mysql, and where they're mounted.
+// conf.d is read in alphabetical order, so the replication.cnf written by the init container
+// comes after custom.cnf and its settings can't be overridden by accident.
+var configFiles = map[string]string{
+	"my.cnf": "/etc/mysql/conf.d/custom.cnf",
+}
+
+// ConfigFiles returns the names of the config files mysql accepts, e.g. through --set-file.
+func ConfigFiles() []string {
+	return slices.Sorted(maps.Keys(configFiles))
+}
+
+// DatabaseName and DatabaseUser are the database created for wordpress, and the user it connects as.
+// The user shares the root user's password.
+const (
+	DatabaseName = "wordpress"
+	DatabaseUser = "wordpress"
+)
+
+// mysqlUID is the id of the mysql user and group of the official image
+const mysqlUID = 999
+
+// pingHandler asks the server whether it's up. mysqladmin ping succeeds even when access is denied,
+// so it needs no credentials. It connects over TCP, which the entrypoint's temporary
+// server used while initializing the data directory doesn't listen on.
+var pingHandler = corev1.ProbeHandler{
+	Exec: &corev1.ExecAction{
+		Command: []string{"mysqladmin", "ping", "--host=127.0.0.1", "--silent"},
+	},
+}
 
-	cfg := newConfig(opts...)
+var (
+	// the first start initializes the data directory, and a replica clones the primary before that,
+	// so the startup probe gives it up to 10 minutes
+	startupProbe = &corev1.Probe{
+		ProbeHandler:     pingHandler,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 60,
+	}
+	livenessProbe = &corev1.Probe{
+		ProbeHandler:     pingHandler,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+	readinessProbe = &corev1.Probe{
+		ProbeHandler:     pingHandler,
+		PeriodSeconds:    5,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+)
+
+func New(cliSet kubernetes.Interface, opts ...config.Option) (k8sapp.Application, error) {
+
+	cfg, err := newConfig(opts...)
+	if err != nil {
+		return k8sapp.Application{}, err
+	}
 
 	return k8sapp.Application{
 		Client: cliSet,
 		Config: cfg,
 		Resources: []k8sapp.KubernetesResource{
-			k8sapp.Deployment,
-			k8sapp.PVC,
+			k8sapp.ConfigMap,
+			k8sapp.StatefulSet,
 			k8sapp.Service,
 			k8sapp.Secret,
 		},
-	}
+	}, nil
 }
 
-func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
-	name := "mysql"
+func newConfig(opts ...config.Option) (*k8sapp.ApplicationConfig, error) {
+	// Collect the overrides up front, the release decides the name of every object we create
+	overrides := &config.DeploymentOverrides{}
+	for _, opt := range opts {
+		opt(overrides)
+	}
+
+	name := config.ReleaseName(overrides.Release, "mysql")
+	secretName := fmt.Sprintf("%s-creds", name)
+	image := "mysql:8.0"
 
 	cfg := &k8sapp.ApplicationConfig{
 		Name:      name,
@@ -40,7 +108,7 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 
 		Deployment: k8sapp.DeploymentConfig{
 			Replicas: 1,
-			Image:    "mysql:8.0",
+			Image:    image,
 			SelectorMatchLabels: map[string]string{
 				"app":                    name,
 				"app.kubernetes.io/name": name,
@@ -62,7 +130,7 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 					ValueFrom: &corev1.EnvVarSource{
 						SecretKeyRef: &corev1.SecretKeySelector{
 							LocalObjectReference: corev1.LocalObjectReference{
-								Name: fmt.Sprintf("%s-creds", name),
+								Name: secretName,
 							},
 							Key: "password",
 						},
@@ -70,18 +138,18 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 				},
 				{
 					Name:  "MYSQL_DATABASE",
-					Value: "wordpress",
+					Value: DatabaseName,
 				},
 				{
 					Name:  "MYSQL_USER",
-					Value: "wordpress",
+					Value: DatabaseUser,
 				},
 				{
 					Name: "MYSQL_PASSWORD",
 					ValueFrom: &corev1.EnvVarSource{
 						SecretKeyRef: &corev1.SecretKeySelector{
 							LocalObjectReference: corev1.LocalObjectReference{
-								Name: fmt.Sprintf("%s-creds", name),
+								Name: secretName,
 							},
 							Key: "password",
 						},
@@ -90,25 +158,69 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 			},
 			VolumeMounts: []corev1.VolumeMount{
 				{
-					Name:      fmt.Sprintf("%-storage", name),
+					Name:      dataVolume,
 					MountPath: "/var/lib/mysql",
 				},
+				{
+					Name:      confVolume,
+					MountPath: "/etc/mysql/conf.d",
+				},
+				{
+					Name:      runVolume,
+					MountPath: "/var/run/mysqld",
+				},
+				{
+					Name:      tmpVolume,
+					MountPath: "/tmp",
+				},
 			},
 			Volumes: []corev1.Volume{
 				{
-					Name: fmt.Sprintf("%-storage", name),
+					Name: confVolume,
 					VolumeSource: corev1.VolumeSource{
-						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: name,
-						},
+						EmptyDir: &corev1.EmptyDirVolumeSource{},
+					},
+				},
+				{
+					Name: runVolume,
+					VolumeSource: corev1.VolumeSource{
+						EmptyDir: &corev1.EmptyDirVolumeSource{},
+					},
+				},
+				{
+					Name: tmpVolume,
+					VolumeSource: corev1.VolumeSource{
+						EmptyDir: &corev1.EmptyDirVolumeSource{},
 					},
 				},
 			},
+			InitContainers: initContainers(name, image, secretName),
+			LivenessProbe:  livenessProbe,
+			ReadinessProbe: readinessProbe,
+			StartupProbe:   startupProbe,
+			// the image's mysql user, which owns the server's files
+			PodSecurityContext: k8sapp.RestrictedPodSecurityContext(mysqlUID, mysqlUID),
+			SecurityContext:    k8sapp.RestrictedSecurityContext(),
 		},
 
-		Pvc: k8sapp.PvcConfig{
-			AccessMode: corev1.ReadWriteOnce,
-			Size:       "5Gi",
+		StatefulSet: k8sapp.StatefulSetConfig{
+			// the headless service below, every pod is reachable as <pod>.<name>
+			ServiceName: name,
+			VolumeClaimTemplates: []k8sapp.VolumeClaimTemplate{
+				{
+					Name: dataVolume,
+					PvcConfig: k8sapp.PvcConfig{
+						AccessMode: corev1.ReadWriteOnce,
+						Size:       "5Gi",
+					},
+				},
+			},
+			// replicas clone the primary when they first start, so the primary has to be up before them
+			PodManagementPolicy: appsv1.OrderedReadyPodManagement,
+			// replicas are replaced before the primary, which keeps taking writes until it's its turn
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			},
 		},
 
 		Svc: k8sapp.SvcConfig{
@@ -116,40 +228,59 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 			DisableClusterIP: true,
 		},
 
+		ConfigMap: k8sapp.ConfigMapConfig{
+			Name:       fmt.Sprintf("%s-config", name),
+			MountPaths: configFiles,
+		},
+
 		Secret: k8sapp.SecretConfig{
-			SecretName: fmt.Sprintf("%s-creds", name),
+			SecretName: secretName,
 			SecretType: "Opaque",
 			SecretData: map[string][]byte{
-				"password": k8sapp.GeneratePassword(25),
+				"password":             k8sapp.GeneratePassword(25),
+				replicationPasswordKey: k8sapp.GeneratePassword(25),
 			},
 		},
 	}
 
-	// Create and apply overrides
-	overrides := &config.DeploymentOverrides{}
-	for _, opt := range opts {
-		opt(overrides)
-	}
-
 	// Apply overrides to the config
 	if overrides.Replicas != 0 {
 		cfg.Deployment.Replicas = overrides.Replicas
 	}
-	if overrides.CPURequest != "" {
-		cfg.Deployment.Resources.Requests[corev1.ResourceCPU] = resource.MustParse(overrides.CPURequest)
+	if err := overrides.ApplyResources(&cfg.Deployment.Resources); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
-	if overrides.MemoryRequest != "" {
-		cfg.Deployment.Resources.Requests[corev1.ResourceMemory] = resource.MustParse(overrides.MemoryRequest)
+	if overrides.VolumeSize != "" {
+		cfg.StatefulSet.VolumeClaimTemplates[0].Size = overrides.VolumeSize
 	}
-	if overrides.CPULimit != "" {
-		cfg.Deployment.Resources.Limits[corev1.ResourceCPU] = resource.MustParse(overrides.CPULimit)
+	if overrides.HasIngress() {
+		return nil, fmt.Errorf("%s: only reachable inside the cluster, so host, path, ingress-class and tls-secret don't apply", name)
 	}
-	if overrides.MemoryLimit != "" {
-		cfg.Deployment.Resources.Limits[corev1.ResourceMemory] = resource.MustParse(overrides.MemoryLimit)
+	if overrides.HasServiceOptions() {
+		return nil, fmt.Errorf("%s: the service is headless, it gives every pod a stable name and can't be exposed; "+
+			"service-type, service-port, node-port, source-ranges and service annotations don't apply", name)
 	}
-	if overrides.VolumeSize != "" {
-		cfg.Pvc.Size = overrides.VolumeSize
+	if err := overrides.CheckFiles(ConfigFiles()); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	cfg.ConfigMap.Files = overrides.Files
+	if overrides.ProbePath != "" {
+		return nil, fmt.Errorf("%s: the probes run mysqladmin ping rather than requesting a path, so probe-path doesn't apply", name)
+	}
+	cfg.Deployment.LivenessProbe = overrides.ApplyProbe(config.LivenessProbe, cfg.Deployment.LivenessProbe)
+	cfg.Deployment.ReadinessProbe = overrides.ApplyProbe(config.ReadinessProbe, cfg.Deployment.ReadinessProbe)
+	cfg.Deployment.StartupProbe = overrides.ApplyProbe(config.StartupProbe, cfg.Deployment.StartupProbe)
+	overrides.ApplySecurity(&cfg.Deployment.PodSecurityContext, &cfg.Deployment.SecurityContext)
+	if overrides.Namespace != "" {
+		cfg.Namespace = overrides.Namespace
+	}
+	if overrides.Release != "" {
+		cfg.Labels["app.kubernetes.io/instance"] = overrides.Release
+		cfg.Deployment.SelectorMatchLabels["app.kubernetes.io/instance"] = overrides.Release
 	}
 
-	return cfg
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
@@ -0,0 +1,165 @@
+package mysql
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MySQL runs as a StatefulSet where pod 0 is the writable primary
+// and every other pod is a read-only replica, replicating from the primary with GTID auto-positioning.
+// Replicas are seeded with the clone plugin: an init container clones the primary's data
+// into the replica's empty volume and points it at the primary before mysql starts.
+const (
+	// dataVolume is the volume claim template holding each pod's data directory
+	dataVolume = "data"
+
+	// confVolume carries the per-pod server configuration written by the init container
+	confVolume = "conf"
+
+	// runVolume and tmpVolume hold the socket and the temporary files,
+	// the root filesystem is read-only
+	runVolume = "run"
+	tmpVolume = "tmp"
+
+	// replicationUser replicates from, and clones, the primary.
+	// It's separate from root so rotating the root password doesn't break running replicas.
+	replicationUser = "replication"
+
+	// replicationPasswordKey holds the replication user's password in the credentials secret
+	replicationPasswordKey = "replication-password"
+)
+
+// PrimaryHost returns the stable DNS name of the primary of the mysql StatefulSet called name,
+// the only pod that accepts writes.
+func PrimaryHost(name string) string {
+	return fmt.Sprintf("%s.%s", PrimaryPod(name), name)
+}
+
+// PrimaryPod returns the name of the primary's pod in the mysql StatefulSet called name.
+func PrimaryPod(name string) string {
+	return name + "-0"
+}
+
+// configScript writes the server configuration that differs per pod, based on the pod's ordinal.
+// Server ids start at 100 so they never collide with the default id of 1.
+const configScript = `set -eu
+ordinal="${HOSTNAME##*-}"
+{
+  echo "[mysqld]"
+  echo "server-id=$((100 + ordinal))"
+  echo "gtid-mode=ON"
+  echo "enforce-gtid-consistency=ON"
+  echo "report-host=${HOSTNAME}.${SERVICE_NAME}"
+  echo "plugin-load-add=mysql_clone.so"
+  if [ "$ordinal" -gt 0 ]; then
+    echo "super-read-only=ON"
+  fi
+} > /etc/mysql/conf.d/replication.cnf
+`
+
+// cloneScript seeds an empty replica from the primary.
+// The primary initializes its own data, and replicas that already have data
+// resume replicating from where they left off, so both skip it.
+// The clone is configured to replicate before it is moved into place,
+// so an interrupted run leaves an empty data directory and is simply retried.
+const cloneScript = `set -eu
+ordinal="${HOSTNAME##*-}"
+datadir=/var/lib/mysql
+if [ "$ordinal" -eq 0 ] || [ -d "$datadir/mysql" ]; then
+  exit 0
+fi
+
+echo "waiting for the primary ${SOURCE_HOST}"
+until mysql -h "$SOURCE_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" -e "SELECT 1" >/dev/null 2>&1; do
+  sleep 2
+done
+
+# created here rather than on the primary's first start, so primaries that predate replication get it too
+mysql -h "$SOURCE_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" -e "
+  CREATE USER IF NOT EXISTS '${REPLICATION_USER}'@'%' IDENTIFIED BY '${REPLICATION_PASSWORD}';
+  ALTER USER '${REPLICATION_USER}'@'%' IDENTIFIED BY '${REPLICATION_PASSWORD}';
+  GRANT REPLICATION SLAVE, BACKUP_ADMIN ON *.* TO '${REPLICATION_USER}'@'%';"
+
+# the clone is received by a throwaway instance.
+# Without root, the fsGroup of the pod has already made the data directory writable
+rm -rf "$datadir/clone" /tmp/seed
+if [ "$(id -u)" -eq 0 ]; then
+  chown mysql:mysql "$datadir"
+fi
+mysqld --no-defaults --initialize-insecure --user=mysql --datadir=/tmp/seed
+mysqld --no-defaults --user=mysql --datadir=/tmp/seed --socket=/tmp/seed.sock --skip-networking --mysqlx=OFF \
+  --plugin-load-add=mysql_clone.so &
+until mysqladmin --socket=/tmp/seed.sock -uroot ping >/dev/null 2>&1; do sleep 1; done
+
+echo "cloning the data of ${SOURCE_HOST}"
+mysql --socket=/tmp/seed.sock -uroot -e "
+  SET GLOBAL clone_valid_donor_list = '${SOURCE_HOST}:3306';
+  CLONE INSTANCE FROM '${REPLICATION_USER}'@'${SOURCE_HOST}':3306 IDENTIFIED BY '${REPLICATION_PASSWORD}'
+    DATA DIRECTORY = '${datadir}/clone';"
+mysqladmin --socket=/tmp/seed.sock -uroot shutdown
+wait
+
+# the clone carries the primary's users and GTID history, so it can pick up replication right where the clone ends
+mysqld --no-defaults --user=mysql --datadir="$datadir/clone" --socket=/tmp/clone.sock --skip-networking --mysqlx=OFF \
+  --server-id=$((100 + ordinal)) --gtid-mode=ON --enforce-gtid-consistency=ON --skip-replica-start &
+until mysqladmin --socket=/tmp/clone.sock -uroot ping >/dev/null 2>&1; do sleep 1; done
+mysql --socket=/tmp/clone.sock -uroot -p"$MYSQL_ROOT_PASSWORD" -e "
+  CHANGE REPLICATION SOURCE TO
+    SOURCE_HOST = '${SOURCE_HOST}',
+    SOURCE_USER = '${REPLICATION_USER}',
+    SOURCE_PASSWORD = '${REPLICATION_PASSWORD}',
+    SOURCE_AUTO_POSITION = 1,
+    GET_SOURCE_PUBLIC_KEY = 1;"
+mysqladmin --socket=/tmp/clone.sock -uroot -p"$MYSQL_ROOT_PASSWORD" shutdown
+wait
+
+mv "$datadir"/clone/* "$datadir"/
+rmdir "$datadir/clone"
+`
+
+// initContainers configure each pod for replication before mysql starts
+func initContainers(name, image, secretName string) []corev1.Container {
+	secretEnv := func(envName, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: envName,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: secretName,
+					},
+					Key: key,
+				},
+			},
+		}
+	}
+
+	return []corev1.Container{
+		{
+			Name:    "init-config",
+			Image:   image,
+			Command: []string{"bash", "-c", configScript},
+			Env: []corev1.EnvVar{
+				{Name: "SERVICE_NAME", Value: name},
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: confVolume, MountPath: "/etc/mysql/conf.d"},
+			},
+		},
+		{
+			Name:    "clone-primary",
+			Image:   image,
+			Command: []string{"bash", "-c", cloneScript},
+			Env: []corev1.EnvVar{
+				{Name: "SOURCE_HOST", Value: PrimaryHost(name)},
+				{Name: "REPLICATION_USER", Value: replicationUser},
+				secretEnv("MYSQL_ROOT_PASSWORD", "password"),
+				secretEnv("REPLICATION_PASSWORD", replicationPasswordKey),
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: dataVolume, MountPath: "/var/lib/mysql"},
+				{Name: tmpVolume, MountPath: "/tmp"},
+			},
+		},
+	}
+}
@@ -0,0 +1,187 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kol-ratner/tufin/internal/config"
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
+)
+
+const (
+	// nextPasswordKey temporarily holds the new password in the credentials secret
+	// so the rotation job can read both the old and the new one.
+	nextPasswordKey = "next-password"
+
+	rotationTimeout  = 5 * time.Minute
+	rotationInterval = 2 * time.Second
+)
+
+// Password returns the password of the MySQL root and wordpress users, as kept in the credentials secret.
+func Password(cliSet kubernetes.Interface, opts ...config.Option) (string, error) {
+	cfg, err := newConfig(opts...)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := cliSet.CoreV1().Secrets(cfg.Namespace).Get(context.Background(), cfg.Secret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	password, ok := secret.Data["password"]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no password", cfg.Secret.SecretName)
+	}
+	return string(password), nil
+}
+
+// RotatePassword changes the password of the MySQL root and wordpress users.
+// It is changed on the primary, and reaches the replicas through replication.
+// The new password is first applied inside the running database by a one-off Job
+// and only then written to the credentials secret, so the secret never holds
+// a password the database doesn't know about.
+// It returns false if there is no existing secret, i.e. nothing to rotate yet.
+func RotatePassword(cliSet kubernetes.Interface, opts ...config.Option) (bool, error) {
+	ctx := context.Background()
+	cfg, err := newConfig(opts...)
+	if err != nil {
+		return false, err
+	}
+	scrtCli := cliSet.CoreV1().Secrets(cfg.Namespace)
+
+	secret, err := scrtCli.Get(ctx, cfg.Secret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[nextPasswordKey] = k8sapp.GeneratePassword(25)
+	secret, err = scrtCli.Update(ctx, secret, metav1.UpdateOptions{FieldManager: k8sapp.FieldManager})
+	if err != nil {
+		return false, err
+	}
+
+	if err := runRotationJob(ctx, cliSet, cfg); err != nil {
+		// leave the current password in place, the database still uses it
+		delete(secret.Data, nextPasswordKey)
+		if _, cleanupErr := scrtCli.Update(ctx, secret, metav1.UpdateOptions{FieldManager: k8sapp.FieldManager}); cleanupErr != nil {
+			return false, fmt.Errorf("%w (cleanup failed: %v)", err, cleanupErr)
+		}
+		return false, err
+	}
+
+	secret.Data["password"] = secret.Data[nextPasswordKey]
+	delete(secret.Data, nextPasswordKey)
+	if _, err := scrtCli.Update(ctx, secret, metav1.UpdateOptions{FieldManager: k8sapp.FieldManager}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// runRotationJob runs the ALTER USER statements against the database and waits for them to finish
+func runRotationJob(ctx context.Context, cliSet kubernetes.Interface, cfg *k8sapp.ApplicationConfig) error {
+	jobCli := cliSet.BatchV1().Jobs(cfg.Namespace)
+	name := fmt.Sprintf("%s-rotate-creds", cfg.Name)
+
+	// a job left behind by a previous failed rotation would block creating a new one
+	background := metav1.DeletePropagationBackground
+	deleteOpts := metav1.DeleteOptions{PropagationPolicy: &background}
+	if err := jobCli.Delete(ctx, name, deleteOpts); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	secretEnv := func(envName, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: envName,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: cfg.Secret.SecretName,
+					},
+					Key: key,
+				},
+			},
+		}
+	}
+
+	sql := `ALTER USER 'root'@'%' IDENTIFIED BY '$NEW_PASSWORD'; ` +
+		`ALTER USER 'root'@'localhost' IDENTIFIED BY '$NEW_PASSWORD'; ` +
+		fmt.Sprintf(`ALTER USER '%s'@'%%' IDENTIFIED BY '$NEW_PASSWORD'; `, DatabaseUser) +
+		`FLUSH PRIVILEGES;`
+
+	backoffLimit := int32(2)
+	automountToken := false
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cfg.Namespace,
+			Labels:    cfg.Labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				// the same restrictions as the database pods, so the job is admitted wherever they are
+				Spec: corev1.PodSpec{
+					RestartPolicy:                corev1.RestartPolicyNever,
+					AutomountServiceAccountToken: &automountToken,
+					SecurityContext:              cfg.Deployment.PodSecurityContext,
+					Containers: []corev1.Container{
+						{
+							Name:            name,
+							Image:           cfg.Deployment.Image,
+							SecurityContext: cfg.Deployment.SecurityContext,
+							Env: []corev1.EnvVar{
+								secretEnv("OLD_PASSWORD", "password"),
+								secretEnv("NEW_PASSWORD", nextPasswordKey),
+							},
+							Command: []string{
+								"sh", "-c",
+								fmt.Sprintf(`mysql -h %s -uroot -p"$OLD_PASSWORD" -e "%s"`, PrimaryHost(cfg.Name), sql),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if _, err := jobCli.Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	defer func() { _ = jobCli.Delete(ctx, name, deleteOpts) }()
+
+	err := wait.PollUntilContextTimeout(ctx, rotationInterval, rotationTimeout, true, func(ctx context.Context) (bool, error) {
+		j, err := jobCli.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if j.Status.Succeeded > 0 {
+			return true, nil
+		}
+		for _, c := range j.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				return false, fmt.Errorf("job %s failed: %s", name, c.Message)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rotate mysql credentials: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package deployments
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Requests sums up what a stack asks the cluster for.
+type Requests struct {
+	// Memory requested by every replica of every component
+	Memory resource.Quantity
+
+	// Storage requested by every persistent volume claim, a StatefulSet's claim once per replica
+	Storage resource.Quantity
+}
+
+// GetRequests returns what the stack Ship would deploy with the same options and configs asks for.
+// Like Manifests, it's worked out from the options alone, without contacting the cluster.
+func GetRequests(opts ShipOptions, configs ...DeploymentConfig) (Requests, error) {
+	objs, err := Manifests(opts, configs...)
+	if err != nil {
+		return Requests{}, err
+	}
+
+	var requests Requests
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			addPodRequests(&requests.Memory, o.Spec.Template.Spec, o.Spec.Replicas)
+		case *appsv1.StatefulSet:
+			addPodRequests(&requests.Memory, o.Spec.Template.Spec, o.Spec.Replicas)
+			for _, claim := range o.Spec.VolumeClaimTemplates {
+				addTimes(&requests.Storage, claim.Spec.Resources.Requests[corev1.ResourceStorage], o.Spec.Replicas)
+			}
+		case *corev1.PersistentVolumeClaim:
+			requests.Storage.Add(o.Spec.Resources.Requests[corev1.ResourceStorage])
+		}
+	}
+	return requests, nil
+}
+
+// addPodRequests adds the memory the pod's containers request, once per replica.
+// Init containers are done before the others start, so they don't add to it.
+func addPodRequests(total *resource.Quantity, pod corev1.PodSpec, replicas *int32) {
+	for _, c := range pod.Containers {
+		addTimes(total, c.Resources.Requests[corev1.ResourceMemory], replicas)
+	}
+}
+
+// addTimes adds q to total once per replica, nil replicas meaning the API server's default of one
+func addTimes(total *resource.Quantity, q resource.Quantity, replicas *int32) {
+	n := int32(1)
+	if replicas != nil {
+		n = *replicas
+	}
+	for i := int32(0); i < n; i++ {
+		total.Add(q)
+	}
+}
@@ -1,13 +1,29 @@
 package deployments_test
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kol-ratner/tufin/internal/config"
 	"github.com/kol-ratner/tufin/internal/deployments"
+	"github.com/kol-ratner/tufin/pkg/k8s"
+	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestShip(t *testing.T) {
@@ -47,12 +63,14 @@ users:
 	// Rest of your test cases remain the same
 	tests := []struct {
 		name      string
+		deployed  []string
 		configs   []deployments.DeploymentConfig
 		wantMsgs  []string
 		wantError bool
 	}{
 		{
-			name: "deploy wordpress only",
+			name:     "deploy wordpress only",
+			deployed: []string{"mysql"},
 			configs: []deployments.DeploymentConfig{
 				{
 					Component: "wordpress",
@@ -63,10 +81,24 @@ users:
 				},
 			},
 			wantMsgs: []string{
+				"waiting for mysql to be ready before deploying wordpress",
+				"mysql is ready",
 				"successfully triggered wordpress deployment",
+				"wordpress is served at http://wordpress.localhost/",
 			},
 			wantError: false,
 		},
+		{
+			name:     "deploy wordpress without mysql",
+			deployed: nil,
+			configs: []deployments.DeploymentConfig{
+				{
+					Component: "wordpress",
+				},
+			},
+			wantMsgs:  []string{},
+			wantError: true,
+		},
 		{
 			name: "deploy mysql only",
 			configs: []deployments.DeploymentConfig{
@@ -99,20 +131,40 @@ users:
 					},
 				},
 			},
+			// mysql goes first regardless of the order it was given in, since wordpress depends on it
 			wantMsgs: []string{
-				"successfully triggered wordpress deployment",
 				"successfully triggered mysql deployment",
+				"waiting for mysql to be ready before deploying wordpress",
+				"mysql is ready",
+				"successfully triggered wordpress deployment",
+				"wordpress is served at http://wordpress.localhost/",
 			},
 			wantError: false,
 		},
+		{
+			name: "unsupported component",
+			configs: []deployments.DeploymentConfig{
+				{
+					Component: "redis",
+				},
+			},
+			wantMsgs:  []string{},
+			wantError: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			msgs := make(chan string, len(tt.wantMsgs))
+			fakeClientset := newReadyClientset()
+			for _, component := range tt.deployed {
+				cfg := deployments.DeploymentConfig{Component: component}
+				if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}, cfg); err != nil {
+					t.Fatalf("Ship() error = %v", err)
+				}
+			}
 
-			fakeClientset := fake.NewSimpleClientset()
-			err := deployments.Ship(msgs, fakeClientset, tt.configs...)
+			msgs := make(chan string, len(tt.wantMsgs))
+			err := deployments.Ship(msgs, fakeClientset, deployments.ShipOptions{}, tt.configs...)
 
 			if (err != nil) != tt.wantError {
 				t.Errorf("Ship() error = %v, wantError %v", err, tt.wantError)
@@ -130,3 +182,1225 @@ users:
 		})
 	}
 }
+
+func TestShip_Wait(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	opts := deployments.ShipOptions{Wait: true}
+
+	wantMsgs := []string{
+		"successfully triggered mysql deployment",
+		"waiting for mysql to be ready before deploying wordpress",
+		"mysql is ready",
+		"successfully triggered wordpress deployment",
+		"wordpress is ready",
+		"wordpress is served at http://wordpress.localhost/",
+	}
+	msgs := make(chan string, len(wantMsgs))
+	if err := deployments.Ship(msgs, fakeClientset, opts); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+
+	close(msgs)
+	gotMsgs := make([]string, 0)
+	for msg := range msgs {
+		gotMsgs = append(gotMsgs, msg)
+	}
+	if !reflect.DeepEqual(gotMsgs, wantMsgs) {
+		t.Errorf("Ship() messages = %v, want %v", gotMsgs, wantMsgs)
+	}
+}
+
+func TestShip_DependencyTimeout(t *testing.T) {
+	// a plain fake clientset never reports anything as ready
+	fakeClientset := fake.NewClientset()
+	opts := deployments.ShipOptions{Timeout: time.Second}
+
+	err := deployments.Ship(make(chan string, 10), fakeClientset, opts)
+	if err == nil {
+		t.Fatal("Ship() error = nil, want a timeout waiting for mysql")
+	}
+
+	if _, err := fakeClientset.AppsV1().Deployments("default").Get(context.Background(), "wordpress", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("wordpress was deployed before mysql was ready, got err = %v", err)
+	}
+}
+
+// newReadyClientset returns a fake clientset in which every deployment
+// has rolled out and every pvc is bound, as there are no controllers behind it to do so
+func newReadyClientset() *fake.Clientset {
+	cli := fake.NewClientset()
+
+	cli.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := cli.Tracker().List(
+			appsv1.SchemeGroupVersion.WithResource("deployments"),
+			appsv1.SchemeGroupVersion.WithKind("Deployment"),
+			action.GetNamespace(),
+		)
+		if err != nil {
+			return true, nil, err
+		}
+		list := obj.(*appsv1.DeploymentList)
+		for i := range list.Items {
+			d := &list.Items[i]
+			d.Status.ObservedGeneration = d.Generation
+			d.Status.Replicas = *d.Spec.Replicas
+			d.Status.UpdatedReplicas = *d.Spec.Replicas
+			d.Status.ReadyReplicas = *d.Spec.Replicas
+			d.Status.AvailableReplicas = *d.Spec.Replicas
+		}
+		return true, list, nil
+	})
+
+	cli.PrependReactor("list", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := cli.Tracker().List(
+			appsv1.SchemeGroupVersion.WithResource("statefulsets"),
+			appsv1.SchemeGroupVersion.WithKind("StatefulSet"),
+			action.GetNamespace(),
+		)
+		if err != nil {
+			return true, nil, err
+		}
+		list := obj.(*appsv1.StatefulSetList)
+		for i := range list.Items {
+			sts := &list.Items[i]
+			sts.Status.ObservedGeneration = sts.Generation
+			sts.Status.Replicas = *sts.Spec.Replicas
+			sts.Status.ReadyReplicas = *sts.Spec.Replicas
+			sts.Status.UpdatedReplicas = *sts.Spec.Replicas
+		}
+		return true, list, nil
+	})
+
+	cli.PrependReactor("list", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := cli.Tracker().List(
+			corev1.SchemeGroupVersion.WithResource("persistentvolumeclaims"),
+			corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"),
+			action.GetNamespace(),
+		)
+		if err != nil {
+			return true, nil, err
+		}
+		list := obj.(*corev1.PersistentVolumeClaimList)
+		for i := range list.Items {
+			list.Items[i].Status.Phase = corev1.ClaimBound
+		}
+		return true, list, nil
+	})
+
+	return cli
+}
+
+func TestDestroy(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []string
+		opts       deployments.DestroyOptions
+		wantMsgs   []string
+		wantPVCs   []string
+		wantError  bool
+	}{
+		{
+			name:       "destroy everything",
+			components: nil,
+			wantMsgs: []string{
+				"successfully destroyed wordpress",
+				"successfully destroyed mysql",
+			},
+			wantPVCs:  []string{},
+			wantError: false,
+		},
+		{
+			name:       "destroy wordpress only",
+			components: []string{"wordpress"},
+			wantMsgs: []string{
+				"successfully destroyed wordpress",
+			},
+			wantPVCs:  []string{"data-mysql-0"},
+			wantError: false,
+		},
+		{
+			name:       "destroy everything but keep data",
+			components: nil,
+			opts:       deployments.DestroyOptions{KeepData: true},
+			wantMsgs: []string{
+				"successfully destroyed wordpress",
+				"successfully destroyed mysql",
+			},
+			wantPVCs:  []string{"data-mysql-0", "wordpress"},
+			wantError: false,
+		},
+		{
+			name:       "unsupported component",
+			components: []string{"redis"},
+			wantMsgs:   []string{},
+			wantPVCs:   []string{"data-mysql-0", "wordpress"},
+			wantError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := newReadyClientset()
+			if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}); err != nil {
+				t.Fatalf("Ship() error = %v", err)
+			}
+			// the fake clientset has no statefulset controller to claim the mysql volume
+			claim := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "data-mysql-0",
+					Namespace: "default",
+					Labels:    map[string]string{"app": "mysql", "app.kubernetes.io/name": "mysql"},
+				},
+			}
+			if _, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").Create(context.Background(), claim, metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			msgs := make(chan string, len(tt.wantMsgs))
+			err := deployments.Destroy(msgs, fakeClientset, tt.opts, tt.components...)
+			if (err != nil) != tt.wantError {
+				t.Errorf("Destroy() error = %v, wantError %v", err, tt.wantError)
+			}
+
+			close(msgs)
+			gotMsgs := make([]string, 0)
+			for msg := range msgs {
+				gotMsgs = append(gotMsgs, msg)
+			}
+			if !reflect.DeepEqual(gotMsgs, tt.wantMsgs) {
+				t.Errorf("Destroy() messages = %v, want %v", gotMsgs, tt.wantMsgs)
+			}
+
+			pvcs, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotPVCs := make([]string, 0)
+			for _, pvc := range pvcs.Items {
+				gotPVCs = append(gotPVCs, pvc.Name)
+			}
+			if !reflect.DeepEqual(gotPVCs, tt.wantPVCs) {
+				t.Errorf("remaining pvcs = %v, want %v", gotPVCs, tt.wantPVCs)
+			}
+		})
+	}
+}
+
+// legacyMySQL adds the mysql Deployment and volume claim earlier versions created to the clientset
+func legacyMySQL(t *testing.T, cli *fake.Clientset) {
+	t.Helper()
+	ctx := context.Background()
+	meta := metav1.ObjectMeta{
+		Name:      "mysql",
+		Namespace: "default",
+		Labels:    map[string]string{"app": "mysql", "app.kubernetes.io/name": "mysql"},
+	}
+	if _, err := cli.AppsV1().Deployments("default").Create(ctx, &appsv1.Deployment{ObjectMeta: meta}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.CoreV1().PersistentVolumeClaims("default").Create(ctx, &corev1.PersistentVolumeClaim{ObjectMeta: meta}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShip_LegacyMySQL(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	legacyMySQL(t, fakeClientset)
+
+	err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{})
+	if err == nil {
+		t.Fatal("Ship() over a mysql Deployment succeeded, want it to ask for the data to be moved first")
+	}
+	for _, want := range []string{"mysqldump", "kubectl delete deployment mysql -n default", "mysql-0"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Ship() error = %v, want it to mention %q", err, want)
+		}
+	}
+	if _, err := fakeClientset.AppsV1().StatefulSets("default").Get(context.Background(), "mysql", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("mysql StatefulSet was created next to the Deployment, get error = %v", err)
+	}
+}
+
+func TestDestroy_LegacyMySQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		keepData bool
+		wantPVC  bool
+	}{
+		{name: "with data", wantPVC: false},
+		{name: "keep data", keepData: true, wantPVC: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := newReadyClientset()
+			legacyMySQL(t, fakeClientset)
+
+			opts := deployments.DestroyOptions{KeepData: tt.keepData}
+			if err := deployments.Destroy(make(chan string, 10), fakeClientset, opts, "mysql"); err != nil {
+				t.Fatalf("Destroy() error = %v", err)
+			}
+
+			ctx := context.Background()
+			if _, err := fakeClientset.AppsV1().Deployments("default").Get(ctx, "mysql", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("legacy mysql Deployment was kept, get error = %v", err)
+			}
+			_, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").Get(ctx, "mysql", metav1.GetOptions{})
+			if gotPVC := err == nil; gotPVC != tt.wantPVC {
+				t.Errorf("legacy mysql claim kept = %v, want %v (get error = %v)", gotPVC, tt.wantPVC, err)
+			}
+		})
+	}
+}
+
+func TestShip_Credentials(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         deployments.ShipOptions
+		wantMsgs     []string
+		wantRotation bool
+	}{
+		{
+			name: "redeploy keeps the password",
+			opts: deployments.ShipOptions{},
+			wantMsgs: []string{
+				"successfully triggered mysql deployment",
+				"waiting for mysql to be ready before deploying wordpress",
+				"mysql is ready",
+				"successfully triggered wordpress deployment",
+				"wordpress is served at http://wordpress.localhost/",
+			},
+			wantRotation: false,
+		},
+		{
+			name: "redeploy rotates the password",
+			opts: deployments.ShipOptions{RotateCredentials: true},
+			wantMsgs: []string{
+				"successfully rotated mysql credentials",
+				"successfully triggered mysql deployment",
+				"waiting for mysql to be ready before deploying wordpress",
+				"mysql is ready",
+				"successfully triggered wordpress deployment",
+				"restarted wordpress to pick up the new mysql credentials",
+				"wordpress is served at http://wordpress.localhost/",
+			},
+			wantRotation: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := newReadyClientset()
+			// there is no job controller behind the fake clientset, so jobs succeed as soon as they're created
+			fakeClientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+				job.Status.Succeeded = 1
+				return false, nil, nil
+			})
+
+			if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}); err != nil {
+				t.Fatalf("Ship() error = %v", err)
+			}
+			before := mysqlPassword(t, fakeClientset)
+
+			msgs := make(chan string, len(tt.wantMsgs))
+			if err := deployments.Ship(msgs, fakeClientset, tt.opts); err != nil {
+				t.Fatalf("Ship() error = %v", err)
+			}
+
+			close(msgs)
+			gotMsgs := make([]string, 0)
+			for msg := range msgs {
+				gotMsgs = append(gotMsgs, msg)
+			}
+			if !reflect.DeepEqual(gotMsgs, tt.wantMsgs) {
+				t.Errorf("Ship() messages = %v, want %v", gotMsgs, tt.wantMsgs)
+			}
+
+			after := mysqlPassword(t, fakeClientset)
+			if gotRotation := after != before; gotRotation != tt.wantRotation {
+				t.Errorf("password rotated = %v, want %v", gotRotation, tt.wantRotation)
+			}
+		})
+	}
+}
+
+func TestShip_CredentialsSecurityContext(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	var job *batchv1.Job
+	fakeClientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Status.Succeeded = 1
+		return false, nil, nil
+	})
+
+	configs := []deployments.DeploymentConfig{
+		{Component: "mysql", Options: []config.Option{config.WithRunAsUser(1001)}},
+		{Component: "wordpress"},
+	}
+	if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}, configs...); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+	if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{RotateCredentials: true}, configs...); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+
+	if job == nil {
+		t.Fatal("no rotation job was created")
+	}
+	podSecurity := job.Spec.Template.Spec.SecurityContext
+	if podSecurity == nil || podSecurity.RunAsUser == nil || *podSecurity.RunAsUser != 1001 {
+		t.Errorf("rotation job pod security context = %+v, want it to run as user 1001 like mysql", podSecurity)
+	}
+}
+
+func mysqlPassword(t *testing.T, cli *fake.Clientset) string {
+	t.Helper()
+	secret, err := cli.CoreV1().Secrets("default").Get(context.Background(), "mysql-creds", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secret.Data["next-password"]; ok {
+		t.Errorf("secret still holds the temporary next-password key")
+	}
+	return string(secret.Data["password"])
+}
+
+func TestShip_Namespace(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	opts := deployments.ShipOptions{Namespace: "team-a"}
+
+	wantMsgs := []string{
+		"created namespace team-a",
+		"successfully triggered mysql deployment",
+		"waiting for mysql to be ready before deploying wordpress",
+		"mysql is ready",
+		"successfully triggered wordpress deployment",
+		"wordpress is served at http://wordpress.team-a.localhost/",
+	}
+	msgs := make(chan string, len(wantMsgs))
+	if err := deployments.Ship(msgs, fakeClientset, opts); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+	close(msgs)
+
+	gotMsgs := make([]string, 0)
+	for msg := range msgs {
+		gotMsgs = append(gotMsgs, msg)
+	}
+	if !reflect.DeepEqual(gotMsgs, wantMsgs) {
+		t.Errorf("Ship() messages = %v, want %v", gotMsgs, wantMsgs)
+	}
+
+	ctx := context.Background()
+	if _, err := fakeClientset.AppsV1().StatefulSets("team-a").Get(ctx, "mysql", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected mysql statefulset in namespace team-a, got err = %v", err)
+	}
+	for _, name := range []string{"wordpress"} {
+		if _, err := fakeClientset.AppsV1().Deployments("team-a").Get(ctx, name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected %s deployment in namespace team-a, got err = %v", name, err)
+		}
+	}
+	deps, err := fakeClientset.AppsV1().Deployments("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps.Items) != 0 {
+		t.Errorf("expected no deployments in the default namespace, got %d", len(deps.Items))
+	}
+
+	destroyOpts := deployments.DestroyOptions{Namespace: "team-a"}
+	if err := deployments.Destroy(make(chan string, 10), fakeClientset, destroyOpts); err != nil {
+		t.Fatalf("Destroy() error = %v", err)
+	}
+	deps, err = fakeClientset.AppsV1().Deployments("team-a").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps.Items) != 0 {
+		t.Errorf("expected Destroy() to remove the deployments in team-a, got %d", len(deps.Items))
+	}
+}
+
+func TestShip_Release(t *testing.T) {
+	tests := []struct {
+		name       string
+		release    string
+		wantNames  []string
+		wantHost   string
+		wantSecret string
+		wantError  bool
+	}{
+		{
+			name:       "no release",
+			release:    "",
+			wantNames:  []string{"mysql", "wordpress"},
+			wantHost:   "mysql-0.mysql",
+			wantSecret: "mysql-creds",
+			wantError:  false,
+		},
+		{
+			name:       "named release",
+			release:    "preview",
+			wantNames:  []string{"preview-mysql", "preview-wordpress"},
+			wantHost:   "preview-mysql-0.preview-mysql",
+			wantSecret: "preview-mysql-creds",
+			wantError:  false,
+		},
+		{
+			name:      "invalid release name",
+			release:   "Preview_1",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := newReadyClientset()
+			opts := deployments.ShipOptions{Release: tt.release}
+
+			err := deployments.Ship(make(chan string, 10), fakeClientset, opts)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Ship() error = %v, wantError %v", err, tt.wantError)
+			}
+			if tt.wantError {
+				return
+			}
+
+			ctx := context.Background()
+			if _, err := fakeClientset.AppsV1().StatefulSets("default").Get(ctx, tt.wantNames[0], metav1.GetOptions{}); err != nil {
+				t.Errorf("expected statefulset %s, got err = %v", tt.wantNames[0], err)
+			}
+			if _, err := fakeClientset.AppsV1().Deployments("default").Get(ctx, tt.wantNames[1], metav1.GetOptions{}); err != nil {
+				t.Errorf("expected deployment %s, got err = %v", tt.wantNames[1], err)
+			}
+			if _, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").Get(ctx, tt.wantNames[1], metav1.GetOptions{}); err != nil {
+				t.Errorf("expected pvc %s, got err = %v", tt.wantNames[1], err)
+			}
+
+			wp, err := fakeClientset.AppsV1().Deployments("default").Get(ctx, tt.wantNames[1], metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, env := range wp.Spec.Template.Spec.Containers[0].Env {
+				switch env.Name {
+				case "WORDPRESS_DB_HOST":
+					if env.Value != tt.wantHost {
+						t.Errorf("WORDPRESS_DB_HOST = %s, want %s", env.Value, tt.wantHost)
+					}
+				case "WORDPRESS_DB_PASSWORD":
+					if got, want := env.ValueFrom.SecretKeyRef.Name, tt.wantSecret; got != want {
+						t.Errorf("WORDPRESS_DB_PASSWORD secret = %s, want %s", got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestShip_ConfigFiles(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	ctx := context.Background()
+
+	ship := func(content string) *appsv1.StatefulSet {
+		t.Helper()
+		configs := []deployments.DeploymentConfig{
+			{Component: "mysql", Options: []config.Option{config.WithFile("my.cnf", content)}},
+		}
+		if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}, configs...); err != nil {
+			t.Fatalf("Ship() error = %v", err)
+		}
+
+		cm, err := fakeClientset.CoreV1().ConfigMaps("default").Get(ctx, "mysql-config", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected configmap mysql-config, got err = %v", err)
+		}
+		if cm.Data["my.cnf"] != content {
+			t.Errorf("my.cnf = %q, want %q", cm.Data["my.cnf"], content)
+		}
+
+		sts, err := fakeClientset.AppsV1().StatefulSets("default").Get(ctx, "mysql", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sts
+	}
+
+	sts := ship("[mysqld]\nmax_connections=500\n")
+	var mounted bool
+	for _, m := range sts.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if m.SubPath == "my.cnf" && m.MountPath == "/etc/mysql/conf.d/custom.cnf" {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Errorf("my.cnf isn't mounted, volume mounts = %+v", sts.Spec.Template.Spec.Containers[0].VolumeMounts)
+	}
+	checksum := sts.Spec.Template.Annotations[k8sapp.ConfigChecksumAnnotation]
+	if checksum == "" {
+		t.Fatalf("pod template has no %s annotation", k8sapp.ConfigChecksumAnnotation)
+	}
+
+	// a changed file has to roll the pods, they don't see updates to files mounted on their own
+	sts = ship("[mysqld]\nmax_connections=1000\n")
+	if sts.Spec.Template.Annotations[k8sapp.ConfigChecksumAnnotation] == checksum {
+		t.Errorf("checksum annotation didn't change with the file")
+	}
+}
+
+func TestShip_ServiceType(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	configs := []deployments.DeploymentConfig{
+		{Component: "mysql"},
+		{Component: "wordpress", Options: []config.Option{
+			config.WithServiceType("NodePort"),
+			config.WithServicePort(8080),
+			config.WithNodePort(30080),
+		}},
+	}
+	if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}, configs...); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+
+	svc, err := fakeClientset.CoreV1().Services("default").Get(context.Background(), "wordpress", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := svc.Spec.Ports[0]
+	if svc.Spec.Type != corev1.ServiceTypeNodePort || port.Port != 8080 || port.NodePort != 30080 || port.TargetPort.IntValue() != 80 {
+		t.Errorf("wordpress service = %s %+v, want NodePort 8080 on node port 30080, targeting 80", svc.Spec.Type, port)
+	}
+
+	ing, err := fakeClientset.NetworkingV1().Ingresses("default").Get(context.Background(), "wordpress", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ing.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number; got != 8080 {
+		t.Errorf("ingress backend port = %d, want 8080", got)
+	}
+}
+
+func TestShip_Probes(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	configs := []deployments.DeploymentConfig{
+		{Component: "mysql", Options: []config.Option{
+			config.WithProbe(config.StartupProbe, func(p *config.ProbeOverrides) { p.Disabled = true }),
+		}},
+		{Component: "wordpress", Options: []config.Option{
+			config.WithProbePath("/healthz"),
+			config.WithProbe(config.LivenessProbe, func(p *config.ProbeOverrides) {
+				period := int32(30)
+				p.PeriodSeconds = &period
+			}),
+		}},
+	}
+	if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}, configs...); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+
+	sts, err := fakeClientset.AppsV1().StatefulSets("default").Get(context.Background(), "mysql", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sts.Spec.Template.Spec.Containers[0]
+	if db.StartupProbe != nil {
+		t.Errorf("mysql startup probe = %+v, want it disabled", db.StartupProbe)
+	}
+	for kind, probe := range map[string]*corev1.Probe{"liveness": db.LivenessProbe, "readiness": db.ReadinessProbe} {
+		if probe == nil || probe.Exec == nil || !reflect.DeepEqual(probe.Exec.Command[:2], []string{"mysqladmin", "ping"}) {
+			t.Errorf("mysql %s probe = %+v, want mysqladmin ping", kind, probe)
+		}
+	}
+
+	dep, err := fakeClientset.AppsV1().Deployments("default").Get(context.Background(), "wordpress", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := dep.Spec.Template.Spec.Containers[0]
+	for kind, probe := range map[string]*corev1.Probe{"liveness": web.LivenessProbe, "readiness": web.ReadinessProbe, "startup": web.StartupProbe} {
+		if probe == nil || probe.HTTPGet == nil || probe.HTTPGet.Path != "/healthz" || probe.HTTPGet.Port.IntValue() != 80 {
+			t.Errorf("wordpress %s probe = %+v, want HTTP GET /healthz on port 80", kind, probe)
+		}
+	}
+	if web.LivenessProbe.PeriodSeconds != 30 || web.ReadinessProbe.PeriodSeconds != 5 {
+		t.Errorf("wordpress probe periods = %d and %d, want the liveness override of 30 and the readiness default of 5",
+			web.LivenessProbe.PeriodSeconds, web.ReadinessProbe.PeriodSeconds)
+	}
+}
+
+func TestShip_Security(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	configs := []deployments.DeploymentConfig{
+		{Component: "mysql"},
+		{Component: "wordpress", Options: []config.Option{
+			config.WithRunAsUser(1000),
+			config.WithReadOnlyRootFilesystem(false),
+		}},
+	}
+	if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}, configs...); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+
+	sts, err := fakeClientset.AppsV1().StatefulSets("default").Get(context.Background(), "mysql", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep, err := fakeClientset.AppsV1().Deployments("default").Get(context.Background(), "wordpress", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what the restricted Pod Security Standard requires, plus the hardening on top of it
+	for name, spec := range map[string]corev1.PodSpec{"mysql": sts.Spec.Template.Spec, "wordpress": dep.Spec.Template.Spec} {
+		if spec.AutomountServiceAccountToken == nil || *spec.AutomountServiceAccountToken {
+			t.Errorf("%s mounts the service account token", name)
+		}
+		pod := spec.SecurityContext
+		if pod == nil || pod.RunAsNonRoot == nil || !*pod.RunAsNonRoot || pod.FSGroup == nil ||
+			pod.SeccompProfile == nil || pod.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+			t.Errorf("%s pod security context = %+v, want non-root with an fsGroup and the runtime's seccomp profile", name, pod)
+			continue
+		}
+		for _, c := range append(spec.InitContainers, spec.Containers...) {
+			sc := c.SecurityContext
+			if sc == nil || sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation ||
+				sc.Capabilities == nil || !reflect.DeepEqual(sc.Capabilities.Drop, []corev1.Capability{"ALL"}) {
+				t.Errorf("%s container %s security context = %+v, want no privilege escalation and every capability dropped", name, c.Name, sc)
+			}
+		}
+	}
+
+	db := sts.Spec.Template.Spec
+	if *db.SecurityContext.RunAsUser != 999 || !*db.Containers[0].SecurityContext.ReadOnlyRootFilesystem {
+		t.Errorf("mysql runs as %d with a read-only root filesystem %v, want the image's mysql user 999 and true",
+			*db.SecurityContext.RunAsUser, *db.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+	}
+	web := dep.Spec.Template.Spec
+	if *web.SecurityContext.RunAsUser != 1000 || *web.SecurityContext.FSGroup != 33 || *web.Containers[0].SecurityContext.ReadOnlyRootFilesystem {
+		t.Errorf("wordpress runs as %d with fsGroup %d and a read-only root filesystem %v, want the overridden user 1000, fsGroup 33 and false",
+			*web.SecurityContext.RunAsUser, *web.SecurityContext.FSGroup, *web.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+	}
+}
+
+func TestShip_InvalidOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		configs   []deployments.DeploymentConfig
+		wantError string
+	}{
+		{
+			name: "cpu limit below the default request",
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql", Options: []config.Option{config.WithCPULimit("250m")}},
+			},
+			wantError: "mysql: cpu limit 250m is lower than the cpu request 500m",
+		},
+		{
+			name: "memory request above the limit",
+			configs: []deployments.DeploymentConfig{
+				{Component: "wordpress", Options: []config.Option{
+					config.WithMemoryRequest("2Gi"),
+					config.WithMemoryLimit("1Gi"),
+				}},
+			},
+			wantError: "wordpress: memory limit 1Gi is lower than the memory request 2Gi",
+		},
+		{
+			name: "malformed quantity",
+			configs: []deployments.DeploymentConfig{
+				{Component: "wordpress", Options: []config.Option{config.WithCPURequest("lots")}},
+			},
+			wantError: `wordpress: invalid quantity for cpu-request: "lots"`,
+		},
+		{
+			name: "malformed volume size",
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql", Options: []config.Option{config.WithVolumeSize("big")}},
+			},
+			wantError: `mysql: invalid volume size "big"`,
+		},
+		{
+			name: "unknown config file",
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql", Options: []config.Option{config.WithFile("my.conf", "[mysqld]\n")}},
+			},
+			wantError: `mysql: unknown file "my.conf", did you mean "my.cnf"?`,
+		},
+		{
+			name: "invalid host",
+			configs: []deployments.DeploymentConfig{
+				{Component: "wordpress", Options: []config.Option{config.WithHost("Blog_Example")}},
+			},
+			wantError: `wordpress: invalid host "Blog_Example"`,
+		},
+		{
+			name: "relative path",
+			configs: []deployments.DeploymentConfig{
+				{Component: "wordpress", Options: []config.Option{config.WithPath("blog")}},
+			},
+			wantError: "wordpress: path must start with a /: blog",
+		},
+		{
+			name: "host on mysql",
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql", Options: []config.Option{config.WithHost("db.example.com")}},
+			},
+			wantError: "mysql: only reachable inside the cluster",
+		},
+		{
+			name: "service type on mysql",
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql", Options: []config.Option{config.WithServiceType("LoadBalancer")}},
+			},
+			wantError: "mysql: the service is headless",
+		},
+		{
+			name: "probe path on mysql",
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql", Options: []config.Option{config.WithProbePath("/healthz")}},
+			},
+			wantError: "mysql: the probes run mysqladmin ping rather than requesting a path, so probe-path doesn't apply",
+		},
+		{
+			name: "root with run as non-root",
+			configs: []deployments.DeploymentConfig{
+				{Component: "wordpress", Options: []config.Option{config.WithRunAsUser(0)}},
+			},
+			wantError: "wordpress: can't run as user 0 when running as non-root is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := newReadyClientset()
+			// a valid mysql next to the broken component must not be applied either
+			configs := append([]deployments.DeploymentConfig{{Component: "mysql"}}, tt.configs...)
+
+			err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}, configs...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("Ship() error = %v, want it to contain %q", err, tt.wantError)
+			}
+
+			deps, err := fakeClientset.AppsV1().Deployments("default").List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deps.Items) != 0 {
+				t.Errorf("expected nothing to be deployed, got %d deployments", len(deps.Items))
+			}
+		})
+	}
+}
+
+func TestManifests(t *testing.T) {
+	opts := deployments.ShipOptions{Namespace: "team-a", Release: "preview"}
+	configs := []deployments.DeploymentConfig{
+		{Component: "wordpress", Options: []config.Option{config.WithReplicas(2)}},
+		{Component: "mysql"},
+	}
+
+	objs, err := deployments.Manifests(opts, configs...)
+	if err != nil {
+		t.Fatalf("Manifests() error = %v", err)
+	}
+
+	// mysql comes first since wordpress depends on it
+	want := []string{
+		"ConfigMap/preview-mysql-config",
+		"StatefulSet/preview-mysql",
+		"Service/preview-mysql",
+		"Secret/preview-mysql-creds",
+		"ConfigMap/preview-wordpress-config",
+		"Deployment/preview-wordpress",
+		"PersistentVolumeClaim/preview-wordpress",
+		"Service/preview-wordpress",
+		"Ingress/preview-wordpress",
+	}
+	var got []string
+	for _, obj := range objs {
+		meta, ok := obj.(metav1.Object)
+		if !ok {
+			t.Fatalf("%T has no object metadata", obj)
+		}
+		if meta.GetNamespace() != "team-a" {
+			t.Errorf("%s is in namespace %q, want team-a", meta.GetName(), meta.GetNamespace())
+		}
+		got = append(got, obj.GetObjectKind().GroupVersionKind().Kind+"/"+meta.GetName())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Manifests() = %v, want %v", got, want)
+	}
+
+	if d := objs[5].(*appsv1.Deployment); *d.Spec.Replicas != 2 {
+		t.Errorf("wordpress replicas = %d, want 2", *d.Spec.Replicas)
+	}
+	// every stack gets a host of its own, so releases in different namespaces don't share routes
+	if ing := objs[8].(*networkingv1.Ingress); ing.Spec.Rules[0].Host != "preview-wordpress.team-a.localhost" {
+		t.Errorf("wordpress host = %s, want preview-wordpress.team-a.localhost", ing.Spec.Rules[0].Host)
+	}
+}
+
+func TestShip_DryRun(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	opts := deployments.ShipOptions{DryRun: true}
+
+	// unlike a real deploy, wordpress doesn't wait for a mysql that was never created
+	msgs := make(chan string, 10)
+	if err := deployments.Ship(msgs, fakeClientset, opts); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+	close(msgs)
+
+	wantMsgs := []string{
+		"mysql passed the server-side dry run",
+		"wordpress passed the server-side dry run",
+	}
+	var gotMsgs []string
+	for msg := range msgs {
+		gotMsgs = append(gotMsgs, msg)
+	}
+	if !reflect.DeepEqual(gotMsgs, wantMsgs) {
+		t.Errorf("Ship() messages = %v, want %v", gotMsgs, wantMsgs)
+	}
+
+	for _, action := range fakeClientset.Actions() {
+		if patch, ok := action.(k8stesting.PatchActionImpl); ok {
+			if dryRun := patch.PatchOptions.DryRun; len(dryRun) != 1 || dryRun[0] != metav1.DryRunAll {
+				t.Errorf("%s patch DryRun = %v, want [%s]", patch.GetResource().Resource, dryRun, metav1.DryRunAll)
+			}
+		}
+	}
+
+	err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{DryRun: true, Namespace: "team-a"})
+	if err == nil || !strings.Contains(err.Error(), "namespace team-a doesn't exist") {
+		t.Errorf("Ship() error = %v, want the missing namespace reported", err)
+	}
+
+	err = deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{DryRun: true, Wait: true})
+	if err == nil {
+		t.Error("Ship() with DryRun and Wait should fail")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	fakeClientset := newReadyClientset()
+	if err := deployments.Ship(make(chan string, 10), fakeClientset, deployments.ShipOptions{}); err != nil {
+		t.Fatalf("Ship() error = %v", err)
+	}
+
+	// the generated password differs on every build, but secret values are never compared
+	diffs, err := deployments.Diff(fakeClientset, deployments.ShipOptions{})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Diff() right after Ship() = %v, want no diffs", diffs)
+	}
+
+	configs := []deployments.DeploymentConfig{
+		{Component: "wordpress", Options: []config.Option{config.WithCPULimit("750m")}},
+	}
+	diffs, err = deployments.Diff(fakeClientset, deployments.ShipOptions{}, configs...)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Name != "Deployment/wordpress" {
+		t.Fatalf("Diff() = %v, want only the wordpress deployment to differ", diffs)
+	}
+	if !strings.Contains(diffs[0].Diff, "-            cpu: 500m\n+            cpu: 750m") {
+		t.Errorf("expected the cpu limit change in the diff:\n%s", diffs[0].Diff)
+	}
+}
+
+func TestExport(t *testing.T) {
+	opts := deployments.ShipOptions{Namespace: "team-a"}
+	configs := []deployments.DeploymentConfig{
+		{Component: "mysql", Options: []config.Option{config.WithVolumeSize("10Gi")}},
+		{Component: "wordpress", Options: []config.Option{config.WithReplicas(2)}},
+	}
+
+	tests := []struct {
+		format    deployments.ExportFormat
+		wantFiles []string
+	}{
+		{
+			format:    deployments.ExportYAML,
+			wantFiles: []string{"mysql.yaml", "wordpress.yaml"},
+		},
+		{
+			format: deployments.ExportHelm,
+			wantFiles: []string{
+				"tufin/Chart.yaml",
+				"tufin/README.md",
+				"tufin/templates/mysql.yaml",
+				"tufin/templates/wordpress.yaml",
+				"tufin/values.yaml",
+			},
+		},
+		{
+			format: deployments.ExportKustomize,
+			wantFiles: []string{
+				"base/mysql.yaml",
+				"base/mysql-creds.env",
+				"base/wordpress.yaml",
+				"base/.gitignore",
+				"base/kustomization.yaml",
+				"overlays/team-a/kustomization.yaml",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			dir := t.TempDir()
+			files, err := deployments.Export(tt.format, dir, opts, configs...)
+			if err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+
+			var got []string
+			for _, file := range files {
+				rel, err := filepath.Rel(dir, file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, rel)
+			}
+			if !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Errorf("Export() wrote %v, want %v", got, tt.wantFiles)
+			}
+		})
+	}
+
+	t.Run("helm values are a tufin values file", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, err := deployments.Export(deployments.ExportHelm, dir, opts, configs...); err != nil {
+			t.Fatalf("Export() error = %v", err)
+		}
+
+		values, err := config.LoadValues(filepath.Join(dir, "tufin", "values.yaml"), deployments.Components())
+		if err != nil {
+			t.Fatalf("LoadValues() error = %v", err)
+		}
+		overrides := config.DeploymentOverrides{}
+		for _, opt := range values["wordpress"] {
+			opt(&overrides)
+		}
+		if overrides.Replicas != 2 || overrides.CPURequest != "250m" || overrides.VolumeSize != "2Gi" ||
+			overrides.Host != "wordpress.team-a.localhost" || overrides.Path != "/" {
+			t.Errorf("wordpress values = %+v, want the effective options", overrides)
+		}
+
+		wpTmpl, err := os.ReadFile(filepath.Join(dir, "tufin", "templates", "wordpress.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `host: {{ index .Values "wordpress" "host" | quote }}`; !strings.Contains(string(wpTmpl), want) {
+			t.Errorf("wordpress template doesn't contain %q", want)
+		}
+
+		tmpl, err := os.ReadFile(filepath.Join(dir, "tufin", "templates", "mysql.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			`replicas: {{ index .Values "mysql" "replicas" }}`,
+			`storage: {{ index .Values "mysql" "volume-size" | quote }}`,
+			`namespace: {{ .Release.Namespace }}`,
+			`{{- $existing := lookup "v1" "Secret" .Release.Namespace "mysql-creds" }}`,
+		} {
+			if !strings.Contains(string(tmpl), want) {
+				t.Errorf("mysql template doesn't contain %q", want)
+			}
+		}
+	})
+
+	t.Run("kustomize base keeps credentials out of the manifests", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, err := deployments.Export(deployments.ExportKustomize, dir, opts, configs...); err != nil {
+			t.Fatalf("Export() error = %v", err)
+		}
+
+		manifest, err := os.ReadFile(filepath.Join(dir, "base", "mysql.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(manifest), "kind: Secret") || strings.Contains(string(manifest), "namespace:") {
+			t.Errorf("base manifest should have neither secrets nor a namespace:\n%s", manifest)
+		}
+		env, err := os.ReadFile(filepath.Join(dir, "base", "mysql-creds.env"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(env), "password=") {
+			t.Errorf("mysql-creds.env = %q, want the password", env)
+		}
+	})
+
+	if _, err := deployments.Export("jsonnet", t.TempDir(), opts); err == nil {
+		t.Error("Export() with an unsupported format should fail")
+	}
+}
+
+func TestForward_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		component string
+		opts      deployments.ForwardOptions
+		pods      []runtime.Object
+		wantError string
+	}{
+		{
+			name:      "unknown component",
+			component: "redis",
+			wantError: "unsupported component: redis",
+		},
+		{
+			name:      "invalid release",
+			component: "wordpress",
+			opts:      deployments.ForwardOptions{Release: "Preview"},
+			wantError: `invalid release name "Preview"`,
+		},
+		{
+			name:      "nothing deployed",
+			component: "wordpress",
+			wantError: "no pods match app=wordpress,tier=frontend in namespace default",
+		},
+		{
+			name:      "other release deployed",
+			component: "mysql",
+			opts:      deployments.ForwardOptions{Namespace: "team-a", Release: "preview"},
+			pods: []runtime.Object{&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mysql-0",
+					Namespace: "team-a",
+					Labels:    map[string]string{"app": "mysql", "app.kubernetes.io/name": "mysql"},
+				},
+			}},
+			wantError: "no pods match app=preview-mysql,app.kubernetes.io/instance=preview,app.kubernetes.io/name=preview-mysql in namespace team-a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &k8s.Client{
+				Interface: fake.NewSimpleClientset(tt.pods...),
+				Config:    &rest.Config{Host: "http://localhost:0"},
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := deployments.Forward(ctx, make(chan string, 10), cli, tt.component, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("Forward() error = %v, want it to contain %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestGetAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     deployments.ShipOptions
+		configs  []deployments.DeploymentConfig
+		wantURL  string
+		wantHost string
+	}{
+		{
+			name:     "defaults",
+			wantURL:  "http://wordpress.localhost/",
+			wantHost: "mysql-0.mysql.default:3306",
+		},
+		{
+			name: "release in a namespace, with a host of its own",
+			opts: deployments.ShipOptions{Namespace: "team-a", Release: "preview"},
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql"},
+				{Component: "wordpress", Options: []config.Option{config.WithHost("blog.localhost")}},
+			},
+			wantURL:  "http://blog.localhost/",
+			wantHost: "preview-mysql-0.preview-mysql.team-a:3306",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := newReadyClientset()
+			if err := deployments.Ship(make(chan string, 10), fakeClientset, tt.opts, tt.configs...); err != nil {
+				t.Fatalf("Ship() error = %v", err)
+			}
+
+			access, err := deployments.GetAccess(fakeClientset, tt.opts, tt.configs...)
+			if err != nil {
+				t.Fatalf("GetAccess() error = %v", err)
+			}
+			if access.URL != tt.wantURL {
+				t.Errorf("URL = %q, want %q", access.URL, tt.wantURL)
+			}
+			if access.DatabaseHost != tt.wantHost {
+				t.Errorf("DatabaseHost = %q, want %q", access.DatabaseHost, tt.wantHost)
+			}
+			if access.Database != "wordpress" || access.User != "wordpress" {
+				t.Errorf("database and user = %q and %q, want wordpress and wordpress", access.Database, access.User)
+			}
+
+			namespace := tt.opts.Namespace
+			if namespace == "" {
+				namespace = "default"
+			}
+			secret, err := fakeClientset.CoreV1().Secrets(namespace).Get(context.Background(), config.ReleaseName(tt.opts.Release, "mysql")+"-creds", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if access.Password != string(secret.Data["password"]) {
+				t.Errorf("Password = %q, want the one in the credentials secret", access.Password)
+			}
+		})
+	}
+}
+
+func TestGetAccess_NotDeployed(t *testing.T) {
+	_, err := deployments.GetAccess(fake.NewClientset(), deployments.ShipOptions{})
+	if err == nil || !strings.Contains(err.Error(), "failed to read the mysql credentials") {
+		t.Errorf("GetAccess() error = %v, want the missing credentials reported", err)
+	}
+}
+
+func TestGetRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		configs     []deployments.DeploymentConfig
+		wantMemory  string
+		wantStorage string
+	}{
+		{
+			name:        "defaults",
+			wantMemory:  "1006Mi",
+			wantStorage: "7Gi",
+		},
+		{
+			name: "replicas and sizes",
+			configs: []deployments.DeploymentConfig{
+				{Component: "mysql", Options: []config.Option{config.WithReplicas(3), config.WithMemoryRequest("1Gi")}},
+				{Component: "wordpress", Options: []config.Option{config.WithReplicas(2), config.WithVolumeSize("1Gi")}},
+			},
+			wantMemory:  "3584Mi",
+			wantStorage: "16Gi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, err := deployments.GetRequests(deployments.ShipOptions{}, tt.configs...)
+			if err != nil {
+				t.Fatalf("GetRequests() error = %v", err)
+			}
+			if want := resource.MustParse(tt.wantMemory); requests.Memory.Cmp(want) != 0 {
+				t.Errorf("Memory = %s, want %s", requests.Memory.String(), tt.wantMemory)
+			}
+			if want := resource.MustParse(tt.wantStorage); requests.Storage.Cmp(want) != 0 {
+				t.Errorf("Storage = %s, want %s", requests.Storage.String(), tt.wantStorage)
+			}
+		})
+	}
+}
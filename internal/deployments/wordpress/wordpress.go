@@ -1,31 +1,113 @@
 package wordpress
 
 import (
+	"fmt"
+	"maps"
+	"slices"
+
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/kol-ratner/tufin/internal/config"
+	"github.com/kol-ratner/tufin/internal/deployments/mysql"
 	k8sapp "github.com/kol-ratner/tufin/pkg/k8s/app"
 )
 
-func New(cliSet kubernetes.Interface, opts ...config.Option) k8sapp.Application {
+// configFiles are the config files that can be supplied for wordpress, and where they're mounted.
+// PHP reads every .ini in its conf.d after php.ini, and .htaccess lands in the document root on top of the site's volume.
+var configFiles = map[string]string{
+	"php.ini":   "/usr/local/etc/php/conf.d/custom.ini",
+	".htaccess": "/var/www/html/.htaccess",
+}
+
+// ConfigFiles returns the names of the config files wordpress accepts, e.g. through --set-file.
+func ConfigFiles() []string {
+	return slices.Sorted(maps.Keys(configFiles))
+}
+
+// Apache's pid file and lock, and PHP's uploads and sessions, go to volumes of their own, the root filesystem is read-only
+const (
+	runVolume  = "apache-run"
+	lockVolume = "apache-lock"
+	tmpVolume  = "tmp"
+)
+
+// wwwDataUID is the id of the www-data user and group of the official image, which the site's files belong to
+const wwwDataUID = 33
+
+// podSecurityContext runs wordpress as www-data. Apache keeps listening on port 80 without root because the pod
+// lets unprivileged users bind every port, a sysctl that is namespaced to the pod and allowed by the restricted policy.
+func podSecurityContext() *corev1.PodSecurityContext {
+	sc := k8sapp.RestrictedPodSecurityContext(wwwDataUID, wwwDataUID)
+	sc.Sysctls = []corev1.Sysctl{
+		{Name: "net.ipv4.ip_unprivileged_port_start", Value: "0"},
+	}
+	return sc
+}
+
+// loginHandler requests the login page, which Apache only serves once PHP is up.
+// Before WordPress is installed it redirects to the installer, which the kubelet counts as a success too.
+var loginHandler = corev1.ProbeHandler{
+	HTTPGet: &corev1.HTTPGetAction{
+		Path: "/wp-login.php",
+		Port: intstr.FromInt32(80),
+	},
+}
+
+var (
+	// the image copies WordPress into the empty volume on its first start, which takes a while on slow disks
+	startupProbe = &corev1.Probe{
+		ProbeHandler:     loginHandler,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 30,
+	}
+	livenessProbe = &corev1.Probe{
+		ProbeHandler:     loginHandler,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+	readinessProbe = &corev1.Probe{
+		ProbeHandler:     loginHandler,
+		PeriodSeconds:    5,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+)
+
+func New(cliSet kubernetes.Interface, opts ...config.Option) (k8sapp.Application, error) {
 
-	cfg := newConfig(opts...)
+	cfg, err := newConfig(opts...)
+	if err != nil {
+		return k8sapp.Application{}, err
+	}
 
 	return k8sapp.Application{
 		Client: cliSet,
 		Config: cfg,
 		Resources: []k8sapp.KubernetesResource{
+			k8sapp.ConfigMap,
 			k8sapp.Deployment,
 			k8sapp.PVC,
 			k8sapp.Service,
+			k8sapp.Ingress,
 		},
-	}
+	}, nil
 }
 
-func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
-	name := "wordpress"
+func newConfig(opts ...config.Option) (*k8sapp.ApplicationConfig, error) {
+	// Collect the overrides up front, the release decides the name of every object we create
+	overrides := &config.DeploymentOverrides{}
+	for _, opt := range opts {
+		opt(overrides)
+	}
+
+	name := config.ReleaseName(overrides.Release, "wordpress")
+	// wordpress talks to the mysql instance of the same release
+	dbName := config.ReleaseName(overrides.Release, "mysql")
 
 	cfg := &k8sapp.ApplicationConfig{
 		Name:      name,
@@ -55,19 +137,20 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 			},
 			EnvVars: []corev1.EnvVar{
 				{
-					Name:  "WORDPRESS_DB_HOST",
-					Value: "mysql",
+					Name: "WORDPRESS_DB_HOST",
+					// writes have to go to the primary, the replicas are read-only
+					Value: mysql.PrimaryHost(dbName),
 				},
 				{
 					Name:  "WORDPRESS_DB_USER",
-					Value: "wordpress",
+					Value: mysql.DatabaseUser,
 				},
 				{
 					Name: "WORDPRESS_DB_PASSWORD",
 					ValueFrom: &corev1.EnvVarSource{
 						SecretKeyRef: &corev1.SecretKeySelector{
 							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "mysql-creds",
+								Name: fmt.Sprintf("%s-creds", dbName),
 							},
 							Key: "password",
 						},
@@ -79,6 +162,18 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 					Name:      name,
 					MountPath: "/var/www/html",
 				},
+				{
+					Name:      runVolume,
+					MountPath: "/var/run/apache2",
+				},
+				{
+					Name:      lockVolume,
+					MountPath: "/var/lock/apache2",
+				},
+				{
+					Name:      tmpVolume,
+					MountPath: "/tmp",
+				},
 			},
 			Volumes: []corev1.Volume{
 				{
@@ -89,7 +184,30 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 						},
 					},
 				},
+				{
+					Name: runVolume,
+					VolumeSource: corev1.VolumeSource{
+						EmptyDir: &corev1.EmptyDirVolumeSource{},
+					},
+				},
+				{
+					Name: lockVolume,
+					VolumeSource: corev1.VolumeSource{
+						EmptyDir: &corev1.EmptyDirVolumeSource{},
+					},
+				},
+				{
+					Name: tmpVolume,
+					VolumeSource: corev1.VolumeSource{
+						EmptyDir: &corev1.EmptyDirVolumeSource{},
+					},
+				},
 			},
+			LivenessProbe:      livenessProbe,
+			ReadinessProbe:     readinessProbe,
+			StartupProbe:       startupProbe,
+			PodSecurityContext: podSecurityContext(),
+			SecurityContext:    k8sapp.RestrictedSecurityContext(),
 		},
 
 		Pvc: k8sapp.PvcConfig{
@@ -101,33 +219,69 @@ func newConfig(opts ...config.Option) *k8sapp.ApplicationConfig {
 			Port:             80,
 			DisableClusterIP: false,
 		},
-	}
 
-	// Create and apply overrides
-	overrides := &config.DeploymentOverrides{}
-	for _, opt := range opts {
-		opt(overrides)
+		ConfigMap: k8sapp.ConfigMapConfig{
+			Name:       fmt.Sprintf("%s-config", name),
+			MountPaths: configFiles,
+		},
 	}
 
 	// Apply overrides to the config
 	if overrides.Replicas != 0 {
 		cfg.Deployment.Replicas = overrides.Replicas
 	}
-	if overrides.CPURequest != "" {
-		cfg.Deployment.Resources.Requests[corev1.ResourceCPU] = resource.MustParse(overrides.CPURequest)
+	if err := overrides.ApplyResources(&cfg.Deployment.Resources); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if overrides.VolumeSize != "" {
+		cfg.Pvc.Size = overrides.VolumeSize
 	}
-	if overrides.MemoryRequest != "" {
-		cfg.Deployment.Resources.Requests[corev1.ResourceMemory] = resource.MustParse(overrides.MemoryRequest)
+	if err := overrides.CheckFiles(ConfigFiles()); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
-	if overrides.CPULimit != "" {
-		cfg.Deployment.Resources.Limits[corev1.ResourceCPU] = resource.MustParse(overrides.CPULimit)
+	cfg.ConfigMap.Files = overrides.Files
+	cfg.Deployment.LivenessProbe = overrides.ApplyProbe(config.LivenessProbe, cfg.Deployment.LivenessProbe)
+	cfg.Deployment.ReadinessProbe = overrides.ApplyProbe(config.ReadinessProbe, cfg.Deployment.ReadinessProbe)
+	cfg.Deployment.StartupProbe = overrides.ApplyProbe(config.StartupProbe, cfg.Deployment.StartupProbe)
+	overrides.ApplySecurity(&cfg.Deployment.PodSecurityContext, &cfg.Deployment.SecurityContext)
+	if overrides.ServiceType != "" {
+		cfg.Svc.Type = corev1.ServiceType(overrides.ServiceType)
 	}
-	if overrides.MemoryLimit != "" {
-		cfg.Deployment.Resources.Limits[corev1.ResourceMemory] = resource.MustParse(overrides.MemoryLimit)
+	if overrides.ServicePort != 0 {
+		cfg.Svc.Port = overrides.ServicePort
 	}
-	if overrides.VolumeSize != "" {
-		cfg.Pvc.Size = overrides.VolumeSize
+	cfg.Svc.NodePort = overrides.NodePort
+	cfg.Svc.LoadBalancerSourceRanges = overrides.SourceRanges
+	cfg.Svc.Annotations = overrides.ServiceAnnotations
+	if overrides.Namespace != "" {
+		cfg.Namespace = overrides.Namespace
+	}
+	cfg.Ingress = k8sapp.IngressConfig{
+		Host:          defaultHost(name, cfg.Namespace),
+		Path:          overrides.Path,
+		ClassName:     overrides.IngressClass,
+		TLSSecretName: overrides.TLSSecret,
+	}
+	if overrides.Host != "" {
+		cfg.Ingress.Host = overrides.Host
+	}
+	if overrides.Release != "" {
+		cfg.Labels["app.kubernetes.io/instance"] = overrides.Release
+		cfg.Deployment.SelectorMatchLabels["app.kubernetes.io/instance"] = overrides.Release
 	}
 
-	return cfg
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// defaultHost is the host wordpress is served at unless one is given.
+// Names under localhost resolve to the local machine, so it's browsable on a local cluster without any DNS setup,
+// and the namespace keeps stacks with the same release in different namespaces apart.
+func defaultHost(name, namespace string) string {
+	if namespace == "default" {
+		return name + ".localhost"
+	}
+	return fmt.Sprintf("%s.%s.localhost", name, namespace)
 }
@@ -8,8 +8,8 @@ import (
 	"github.com/kol-ratner/tufin/pkg/k8s"
 )
 
-func Status(msgChan chan<- string, cli *k8s.Client) error {
-	pods, err := cli.Pods("default")
+func Status(msgChan chan<- string, cli *k8s.Client, namespace string) error {
+	pods, err := cli.Pods(namespace)
 	if err != nil {
 		return err
 	}
@@ -3,6 +3,8 @@ package app
 import (
 	"context"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -14,12 +16,22 @@ const (
 	ConfigMap
 	Secret
 	PVC
+	StatefulSet
+	Ingress
 )
 
 type Application struct {
 	Client    kubernetes.Interface
 	Config    *ApplicationConfig
 	Resources []KubernetesResource
+
+	// ForceConflicts makes Deploy take over fields that are owned by another field manager,
+	// instead of failing with a conflict.
+	ForceConflicts bool
+
+	// DryRun has the API server validate everything Deploy applies,
+	// including admission, without persisting any of it.
+	DryRun bool
 }
 
 func NewApplication(client kubernetes.Interface, config *ApplicationConfig, resources []KubernetesResource) *Application {
@@ -30,9 +42,17 @@ func NewApplication(client kubernetes.Interface, config *ApplicationConfig, reso
 	}
 }
 
+// Deploy server-side applies each of a.Resources in order, as the FieldManager field manager.
+// Applying only sends the fields we have an opinion on, so running it repeatedly is safe
+// and leaves fields set by other controllers alone.
+// The config is validated before anything is applied.
 func (a *Application) Deploy() error {
 	ctx := context.Background()
 
+	if err := a.Config.Validate(); err != nil {
+		return err
+	}
+
 	for _, obj := range a.Resources {
 		switch obj {
 		case Deployment:
@@ -51,8 +71,96 @@ func (a *Application) Deploy() error {
 			if err := a.pvc(ctx); err != nil {
 				return err
 			}
+		case ConfigMap:
+			if err := a.configMap(ctx); err != nil {
+				return err
+			}
+		case Ingress:
+			if err := a.ingress(ctx); err != nil {
+				return err
+			}
+		case StatefulSet:
+			if err := a.statefulSet(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the application's resources in the reverse order of a.Resources,
+// so that whatever Deploy created last is torn down first.
+// Resources that no longer exist in the cluster are skipped.
+func (a *Application) Delete() error {
+	ctx := context.Background()
+
+	for i := len(a.Resources) - 1; i >= 0; i-- {
+		switch a.Resources[i] {
+		case Deployment:
+			if err := a.deleteDeployment(ctx); err != nil {
+				return err
+			}
+		case Service:
+			if err := a.deleteService(ctx); err != nil {
+				return err
+			}
+		case Secret:
+			if err := a.deleteSecret(ctx); err != nil {
+				return err
+			}
+		case PVC:
+			if err := a.deletePvc(ctx); err != nil {
+				return err
+			}
+		case ConfigMap:
+			if err := a.deleteConfigMap(ctx); err != nil {
+				return err
+			}
+		case Ingress:
+			if err := a.deleteIngress(ctx); err != nil {
+				return err
+			}
+		case StatefulSet:
+			if err := a.deleteStatefulSet(ctx); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
+
+// Exists reports whether all of the application's resources are present in the cluster.
+func (a *Application) Exists() (bool, error) {
+	ctx := context.Background()
+
+	for _, obj := range a.Resources {
+		var err error
+		switch obj {
+		case Deployment:
+			_, err = a.Client.AppsV1().Deployments(a.Config.Namespace).Get(ctx, a.Config.Name, metav1.GetOptions{})
+		case Service:
+			_, err = a.Client.CoreV1().Services(a.Config.Namespace).Get(ctx, a.Config.Name, metav1.GetOptions{})
+		case Secret:
+			_, err = a.Client.CoreV1().Secrets(a.Config.Namespace).Get(ctx, a.Config.Secret.SecretName, metav1.GetOptions{})
+		case PVC:
+			_, err = a.Client.CoreV1().PersistentVolumeClaims(a.Config.Namespace).Get(ctx, a.Config.Name, metav1.GetOptions{})
+		case StatefulSet:
+			_, err = a.Client.AppsV1().StatefulSets(a.Config.Namespace).Get(ctx, a.Config.Name, metav1.GetOptions{})
+		case ConfigMap:
+			_, err = a.Client.CoreV1().ConfigMaps(a.Config.Namespace).Get(ctx, a.Config.ConfigMap.Name, metav1.GetOptions{})
+		case Ingress:
+			_, err = a.Client.NetworkingV1().Ingresses(a.Config.Namespace).Get(ctx, a.Config.Name, metav1.GetOptions{})
+		}
+
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
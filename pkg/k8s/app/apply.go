@@ -0,0 +1,62 @@
+package app
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// FieldManager is the name tufin's changes are recorded under in each object's managed fields.
+const FieldManager = "tufin"
+
+func (a *Application) applyOptions() metav1.PatchOptions {
+	force := a.ForceConflicts
+	opts := metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        &force,
+	}
+	if a.DryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return opts
+}
+
+// applyPatch turns obj into a server-side apply patch.
+func applyPatch(obj runtime.Object) ([]byte, error) {
+	u, err := Manifest(obj)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(u)
+}
+
+// Manifest converts obj to the fields we have an opinion on.
+// Status and server-populated metadata are left out,
+// claiming them would only lead to conflicts with the controllers that own them.
+func Manifest(obj runtime.Object) (map[string]interface{}, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(u, "status")
+	unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u, "spec", "template", "metadata", "creationTimestamp")
+
+	// a StatefulSet's volume claim templates are full objects of their own
+	if claims, found, _ := unstructured.NestedSlice(u, "spec", "volumeClaimTemplates"); found {
+		for _, c := range claims {
+			if claim, ok := c.(map[string]interface{}); ok {
+				delete(claim, "status")
+				unstructured.RemoveNestedField(claim, "metadata", "creationTimestamp")
+			}
+		}
+		if err := unstructured.SetNestedSlice(u, claims, "spec", "volumeClaimTemplates"); err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
@@ -1,7 +1,13 @@
 package app
 
 import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type DeploymentConfig struct {
@@ -14,6 +20,47 @@ type DeploymentConfig struct {
 	EnvVars      []corev1.EnvVar
 	Volumes      []corev1.Volume
 	VolumeMounts []corev1.VolumeMount
+
+	// InitContainers run to completion, in order, before the application's container starts
+	InitContainers []corev1.Container
+
+	// StartupProbe holds off the other probes until the application has started,
+	// LivenessProbe restarts the container when it fails and ReadinessProbe takes the pod out of its service.
+	// Nil leaves the container without that probe.
+	LivenessProbe  *corev1.Probe
+	ReadinessProbe *corev1.Probe
+	StartupProbe   *corev1.Probe
+
+	// PodSecurityContext applies to every container of the pod, SecurityContext to each container,
+	// init containers included, that doesn't set its own. Nil leaves them to the cluster's defaults.
+	PodSecurityContext *corev1.PodSecurityContext
+	SecurityContext    *corev1.SecurityContext
+}
+
+// StatefulSetConfig holds what a StatefulSet needs on top of the pod template in DeploymentConfig.
+type StatefulSetConfig struct {
+	// ServiceName is the headless service that gives every pod a stable DNS name, <pod>.<service>
+	ServiceName string
+
+	// VolumeClaimTemplates give every pod a volume of its own, claimed as <template>-<pod>.
+	// They can't be changed once the StatefulSet exists.
+	VolumeClaimTemplates []VolumeClaimTemplate
+
+	// PodManagementPolicy is OrderedReady, which starts pods one at a time in ordinal order
+	// and waits for each to be ready, or Parallel. Empty leaves it to the API server, which defaults to OrderedReady.
+	// Like the volume claim templates, it can't be changed once the StatefulSet exists.
+	PodManagementPolicy v1.PodManagementPolicyType
+
+	// UpdateStrategy is how pods are replaced when the pod template changes:
+	// RollingUpdate replaces them from the highest ordinal down, optionally only down to a partition,
+	// OnDelete only when they are deleted. Empty leaves it to the API server, which defaults to RollingUpdate.
+	UpdateStrategy v1.StatefulSetUpdateStrategy
+}
+
+// VolumeClaimTemplate is a per-pod volume of a StatefulSet, mounted by the volume mount of the same name.
+type VolumeClaimTemplate struct {
+	Name string
+	PvcConfig
 }
 
 type PvcConfig struct {
@@ -21,9 +68,50 @@ type PvcConfig struct {
 	Size       string
 }
 
+// ConfigMapConfig holds files that are stored in the application's ConfigMap and mounted into its container.
+type ConfigMapConfig struct {
+	Name string
+
+	// Files maps the name of each file to its content
+	Files map[string]string
+
+	// MountPaths maps the name of a file to where it's mounted in the container.
+	// Only the files in Files are mounted, so it can list every file the application supports.
+	MountPaths map[string]string
+}
+
+// IngressConfig exposes the application's service outside the cluster through an Ingress.
+type IngressConfig struct {
+	// Host the application is served at, empty matches every host
+	Host string
+
+	// Path the application is served under, empty means the root
+	Path string
+
+	// ClassName picks the ingress controller, empty uses the cluster's default one
+	ClassName string
+
+	// TLSSecretName is an existing kubernetes.io/tls Secret with the certificate for Host.
+	// Empty serves plain HTTP.
+	TLSSecretName string
+}
+
 type SvcConfig struct {
 	Port             int32
 	DisableClusterIP bool
+
+	// Type is ClusterIP, NodePort or LoadBalancer, empty leaves it to the API server, which defaults to ClusterIP
+	Type corev1.ServiceType
+
+	// NodePort is the port a NodePort or LoadBalancer service is reachable at on every node, within 30000-32767,
+	// zero has the API server pick a free one
+	NodePort int32
+
+	// LoadBalancerSourceRanges restricts which client CIDRs a LoadBalancer service accepts
+	LoadBalancerSourceRanges []string
+
+	// Annotations configure the load balancer or whatever else watches the service, e.g. its cloud provider
+	Annotations map[string]string
 }
 
 type SecretConfig struct {
@@ -37,8 +125,183 @@ type ApplicationConfig struct {
 	Labels    map[string]string
 	Namespace string
 
-	Pvc        PvcConfig
-	Deployment DeploymentConfig
-	Svc        SvcConfig
-	Secret     SecretConfig
+	Pvc         PvcConfig
+	Deployment  DeploymentConfig
+	StatefulSet StatefulSetConfig
+	Svc         SvcConfig
+	Secret      SecretConfig
+	ConfigMap   ConfigMapConfig
+	Ingress     IngressConfig
+}
+
+// maxConfigMapSize is the most data the API server accepts in a single ConfigMap
+const maxConfigMapSize = 1 << 20
+
+// minNodePort and maxNodePort bound the API server's default service node port range, k3s' included
+const (
+	minNodePort = 30000
+	maxNodePort = 32767
+)
+
+// Validate checks the parts of the config the API server would otherwise reject, or that would panic when building objects:
+// quantities have to parse, files have to fit in a ConfigMap and have a mount path, the ingress host and path have to be valid,
+// StatefulSet policies have to be known ones and no resource limit may be below its request.
+func (c *ApplicationConfig) Validate() error {
+	sizes := []string{c.Pvc.Size}
+	for _, tmpl := range c.StatefulSet.VolumeClaimTemplates {
+		sizes = append(sizes, tmpl.Size)
+	}
+	for _, s := range sizes {
+		if s == "" {
+			continue
+		}
+		size, err := resource.ParseQuantity(s)
+		if err != nil {
+			return fmt.Errorf("%s: invalid volume size %q", c.Name, s)
+		}
+		if size.Sign() <= 0 {
+			return fmt.Errorf("%s: volume size must be greater than zero: %s", c.Name, s)
+		}
+	}
+
+	size := 0
+	for name, content := range c.ConfigMap.Files {
+		if errs := validation.IsConfigMapKey(name); len(errs) > 0 {
+			return fmt.Errorf("%s: invalid file name %q: %s", c.Name, name, strings.Join(errs, ", "))
+		}
+		if c.ConfigMap.MountPaths[name] == "" {
+			return fmt.Errorf("%s: file %s has no mount path", c.Name, name)
+		}
+		size += len(name) + len(content)
+	}
+	if size > maxConfigMapSize {
+		return fmt.Errorf("%s: files add up to %d bytes, more than the %d a ConfigMap can hold", c.Name, size, maxConfigMapSize)
+	}
+
+	if ing := c.Ingress; ing.Host != "" {
+		if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(ing.Host, "*.")); len(errs) > 0 {
+			return fmt.Errorf("%s: invalid host %q: %s", c.Name, ing.Host, strings.Join(errs, ", "))
+		}
+	}
+	if c.Ingress.Path != "" && !strings.HasPrefix(c.Ingress.Path, "/") {
+		return fmt.Errorf("%s: path must start with a /: %s", c.Name, c.Ingress.Path)
+	}
+	for _, ref := range []struct{ kind, name string }{
+		{"ingress class", c.Ingress.ClassName},
+		{"tls secret", c.Ingress.TLSSecretName},
+	} {
+		if ref.name == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(ref.name); len(errs) > 0 {
+			return fmt.Errorf("%s: invalid %s name %q: %s", c.Name, ref.kind, ref.name, strings.Join(errs, ", "))
+		}
+	}
+
+	if err := c.Svc.validate(c.Name); err != nil {
+		return err
+	}
+
+	for _, probe := range []struct {
+		kind  string
+		probe *corev1.Probe
+	}{
+		{"liveness", c.Deployment.LivenessProbe},
+		{"readiness", c.Deployment.ReadinessProbe},
+		{"startup", c.Deployment.StartupProbe},
+	} {
+		if err := validateProbe(probe.kind, probe.probe); err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}
+	if err := c.Deployment.validateSecurity(); err != nil {
+		return fmt.Errorf("%s: %w", c.Name, err)
+	}
+
+	switch c.StatefulSet.PodManagementPolicy {
+	case "", v1.OrderedReadyPodManagement, v1.ParallelPodManagement:
+	default:
+		return fmt.Errorf("%s: unknown pod management policy %q", c.Name, c.StatefulSet.PodManagementPolicy)
+	}
+	strategy := c.StatefulSet.UpdateStrategy
+	switch strategy.Type {
+	case "", v1.RollingUpdateStatefulSetStrategyType:
+	case v1.OnDeleteStatefulSetStrategyType:
+		if strategy.RollingUpdate != nil {
+			return fmt.Errorf("%s: rolling update settings only apply to the %s update strategy", c.Name, v1.RollingUpdateStatefulSetStrategyType)
+		}
+	default:
+		return fmt.Errorf("%s: unknown update strategy %q", c.Name, strategy.Type)
+	}
+	if strategy.RollingUpdate != nil && strategy.RollingUpdate.Partition != nil && *strategy.RollingUpdate.Partition < 0 {
+		return fmt.Errorf("%s: update partition must not be negative: %d", c.Name, *strategy.RollingUpdate.Partition)
+	}
+
+	res := c.Deployment.Resources
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		request, hasRequest := res.Requests[name]
+		limit, hasLimit := res.Limits[name]
+		if hasRequest && hasLimit && limit.Cmp(request) < 0 {
+			return fmt.Errorf("%s: %s limit %s is lower than the %s request %s", c.Name, name, limit.String(), name, request.String())
+		}
+	}
+	return nil
+}
+
+// validateProbe checks that the probe has exactly one handler and timings the kubelet accepts
+func validateProbe(kind string, p *corev1.Probe) error {
+	if p == nil {
+		return nil
+	}
+
+	handlers := 0
+	for _, set := range []bool{p.Exec != nil, p.HTTPGet != nil, p.TCPSocket != nil, p.GRPC != nil} {
+		if set {
+			handlers++
+		}
+	}
+	if handlers != 1 {
+		return fmt.Errorf("%s probe needs exactly one of exec, httpGet, tcpSocket or grpc, got %d", kind, handlers)
+	}
+	if p.HTTPGet != nil && !strings.HasPrefix(p.HTTPGet.Path, "/") {
+		return fmt.Errorf("%s probe path must start with a /: %s", kind, p.HTTPGet.Path)
+	}
+
+	if p.InitialDelaySeconds < 0 || p.PeriodSeconds < 0 || p.TimeoutSeconds < 0 || p.SuccessThreshold < 0 || p.FailureThreshold < 0 {
+		return fmt.Errorf("%s probe timings must not be negative", kind)
+	}
+	// only readiness can ask for several successes in a row
+	if kind != "readiness" && p.SuccessThreshold > 1 {
+		return fmt.Errorf("%s probe success threshold must be 1, got %d", kind, p.SuccessThreshold)
+	}
+	return nil
+}
+
+// validate checks that the service's settings fit its type
+func (s *SvcConfig) validate(name string) error {
+	switch s.Type {
+	case "", corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+	default:
+		return fmt.Errorf("%s: unsupported service type %q", name, s.Type)
+	}
+	exposed := s.Type == corev1.ServiceTypeNodePort || s.Type == corev1.ServiceTypeLoadBalancer
+
+	if s.DisableClusterIP && exposed {
+		return fmt.Errorf("%s: a headless service can't be of type %s", name, s.Type)
+	}
+	if s.NodePort != 0 && !exposed {
+		return fmt.Errorf("%s: a node port needs a NodePort or LoadBalancer service", name)
+	}
+	if s.NodePort != 0 && (s.NodePort < minNodePort || s.NodePort > maxNodePort) {
+		return fmt.Errorf("%s: node port %d is outside the cluster's node port range %d-%d", name, s.NodePort, minNodePort, maxNodePort)
+	}
+	if len(s.LoadBalancerSourceRanges) > 0 && s.Type != corev1.ServiceTypeLoadBalancer {
+		return fmt.Errorf("%s: source ranges need a LoadBalancer service", name)
+	}
+	for key := range s.Annotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("%s: invalid service annotation %q: %s", name, key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
 }
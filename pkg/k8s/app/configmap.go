@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ConfigChecksumAnnotation is set on the pod template to a checksum of the ConfigMap's files.
+// Files mounted on their own aren't updated in running pods, so a changed file has to roll them.
+const ConfigChecksumAnnotation = "tufin/config-checksum"
+
+// configVolume is the pod volume the ConfigMap's files are mounted from
+const configVolume = "config-files"
+
+func (a *Application) configMapObject() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      a.Config.ConfigMap.Name,
+			Namespace: a.Config.Namespace,
+			Labels:    a.Config.Labels,
+		},
+		Data: a.Config.ConfigMap.Files,
+	}
+}
+
+func (a *Application) configMap(ctx context.Context) error {
+	cm := a.configMapObject()
+
+	data, err := applyPatch(cm)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Client.CoreV1().ConfigMaps(a.Config.Namespace).Patch(ctx, cm.Name, types.ApplyPatchType, data, a.applyOptions())
+	return err
+}
+
+func (a *Application) deleteConfigMap(ctx context.Context) error {
+	err := a.Client.CoreV1().ConfigMaps(a.Config.Namespace).Delete(ctx, a.Config.ConfigMap.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// fileNames returns the names of the ConfigMap's files, sorted so the pod template is stable
+func (c *ConfigMapConfig) fileNames() []string {
+	names := make([]string, 0, len(c.Files))
+	for name := range c.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileMounts mounts every file of the ConfigMap at its mount path, each on its own,
+// so a file can be dropped into a directory the image already populates
+func (c *ConfigMapConfig) fileMounts() []corev1.VolumeMount {
+	var mounts []corev1.VolumeMount
+	for _, name := range c.fileNames() {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      configVolume,
+			MountPath: c.MountPaths[name],
+			SubPath:   name,
+			ReadOnly:  true,
+		})
+	}
+	return mounts
+}
+
+// checksum hashes the ConfigMap's files, names included
+func (c *ConfigMapConfig) checksum() string {
+	h := sha256.New()
+	for _, name := range c.fileNames() {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(c.Files[name]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
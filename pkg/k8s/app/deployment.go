@@ -2,17 +2,22 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
-func (a *Application) deployment(ctx context.Context) error {
-	dCli := a.Client.AppsV1().Deployments(a.Config.Namespace)
-
-	deployment := &v1.Deployment{
+func (a *Application) deploymentObject() *v1.Deployment {
+	return &v1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      a.Config.Name,
 			Namespace: a.Config.Namespace,
@@ -26,43 +31,103 @@ func (a *Application) deployment(ctx context.Context) error {
 			Strategy: v1.DeploymentStrategy{
 				Type: v1.RecreateDeploymentStrategyType,
 			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: a.Config.Labels,
+			Template: a.podTemplate(),
+		},
+	}
+}
+
+// podTemplate is the pod spec shared by the application's workloads.
+// The ConfigMap's files, if it has any, are mounted into the container.
+// None of the applications talk to the API server, so the service account token isn't mounted.
+func (a *Application) podTemplate() corev1.PodTemplateSpec {
+	volumes := a.Config.Deployment.Volumes
+	mounts := a.Config.Deployment.VolumeMounts
+	var annotations map[string]string
+
+	if files := &a.Config.ConfigMap; len(files.Files) > 0 {
+		volumes = append(append([]corev1.Volume(nil), volumes...), corev1.Volume{
+			Name: configVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: files.Name},
 				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
+			},
+		})
+		mounts = append(append([]corev1.VolumeMount(nil), mounts...), files.fileMounts()...)
+		annotations = map[string]string{ConfigChecksumAnnotation: files.checksum()}
+	}
+
+	var initContainers []corev1.Container
+	for _, c := range a.Config.Deployment.InitContainers {
+		c.SecurityContext = a.Config.Deployment.containerSecurityContext(c)
+		initContainers = append(initContainers, c)
+	}
+	automountToken := false
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      a.Config.Labels,
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			AutomountServiceAccountToken: &automountToken,
+			SecurityContext:              a.Config.Deployment.PodSecurityContext,
+			InitContainers:               initContainers,
+			Containers: []corev1.Container{
+				{
+					Name:      a.Config.Name,
+					Image:     a.Config.Deployment.Image,
+					Env:       a.Config.Deployment.EnvVars,
+					Resources: a.Config.Deployment.Resources,
+					Ports: []corev1.ContainerPort{
 						{
-							Name:      a.Config.Name,
-							Image:     a.Config.Deployment.Image,
-							Env:       a.Config.Deployment.EnvVars,
-							Resources: a.Config.Deployment.Resources,
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          a.Config.Name,
-									Protocol:      corev1.ProtocolTCP,
-									ContainerPort: a.Config.Deployment.ContainerPort,
-								},
-							},
-							VolumeMounts: a.Config.Deployment.VolumeMounts,
+							Name:          a.Config.Name,
+							Protocol:      corev1.ProtocolTCP,
+							ContainerPort: a.Config.Deployment.ContainerPort,
 						},
 					},
-					Volumes: a.Config.Deployment.Volumes,
+					VolumeMounts:    mounts,
+					LivenessProbe:   a.Config.Deployment.LivenessProbe,
+					ReadinessProbe:  a.Config.Deployment.ReadinessProbe,
+					StartupProbe:    a.Config.Deployment.StartupProbe,
+					SecurityContext: a.Config.Deployment.SecurityContext,
 				},
 			},
+			Volumes: volumes,
 		},
 	}
+}
 
-	_, err := dCli.Create(ctx, deployment, metav1.CreateOptions{})
+func (a *Application) deployment(ctx context.Context) error {
+	deployment := a.deploymentObject()
+
+	data, err := applyPatch(deployment)
 	if err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			if _, err := dCli.Update(ctx, deployment, metav1.UpdateOptions{}); err != nil {
-				return err
-			}
-			return nil
-		}
 		return err
 	}
 
+	_, err = a.Client.AppsV1().Deployments(a.Config.Namespace).Patch(ctx, deployment.Name, types.ApplyPatchType, data, a.applyOptions())
+	return err
+}
+
+func (a *Application) deleteDeployment(ctx context.Context) error {
+	err := a.Client.AppsV1().Deployments(a.Config.Namespace).Delete(ctx, a.Config.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
 	return nil
 }
+
+// Restart triggers a rolling restart of the application's Deployment
+// the same way `kubectl rollout restart` does, by stamping the pod template with the current time.
+func (a *Application) Restart() error {
+	patch := fmt.Sprintf(
+		`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":%q}}}}}`,
+		time.Now().Format(time.RFC3339),
+	)
+
+	_, err := a.Client.AppsV1().Deployments(a.Config.Namespace).Patch(
+		context.Background(), a.Config.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: FieldManager},
+	)
+	return err
+}
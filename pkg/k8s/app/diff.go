@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// ObjectDiff is the difference between the live and the desired state of one object.
+type ObjectDiff struct {
+	// Name identifies the object, e.g. Deployment/mysql
+	Name string
+
+	// Diff is a unified diff from the live object to the desired one
+	Diff string
+}
+
+// Diff compares the objects Deploy would apply with the ones in the cluster, and returns one ObjectDiff per object that differs.
+// Only the fields Deploy sets are compared: status, server-managed metadata, defaults and fields set by other controllers are ignored.
+// Secret values are never shown, a Secret only differs if it misses a key Deploy would add.
+// Objects missing from the cluster show up in full as additions.
+func (a *Application) Diff(ctx context.Context) ([]ObjectDiff, error) {
+	desired, err := a.Objects()
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []ObjectDiff
+	for _, obj := range desired {
+		want, err := Manifest(obj)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%s/%s", want["kind"], obj.(metav1.Object).GetName())
+
+		var got map[string]interface{}
+		live, err := a.liveObject(ctx, obj)
+		switch {
+		case apierrors.IsNotFound(err):
+			// nothing is deployed, the whole object is new
+		case err != nil:
+			return nil, err
+		default:
+			got, err = Manifest(live)
+			if err != nil {
+				return nil, err
+			}
+			// typed clients don't fill in the type meta of the objects they return
+			got["apiVersion"], got["kind"] = want["apiVersion"], want["kind"]
+			got = project(got, want).(map[string]interface{})
+		}
+
+		redact(want)
+		if got != nil {
+			redact(got)
+		}
+
+		from, err := yamlLines(got)
+		if err != nil {
+			return nil, err
+		}
+		to, err := yamlLines(want)
+		if err != nil {
+			return nil, err
+		}
+
+		if d := unifiedDiff("live/"+name, "desired/"+name, from, to); d != "" {
+			diffs = append(diffs, ObjectDiff{Name: name, Diff: d})
+		}
+	}
+	return diffs, nil
+}
+
+// liveObject fetches the cluster's version of obj
+func (a *Application) liveObject(ctx context.Context, obj runtime.Object) (runtime.Object, error) {
+	ns := a.Config.Namespace
+	switch o := obj.(type) {
+	case *v1.Deployment:
+		return a.Client.AppsV1().Deployments(ns).Get(ctx, o.Name, metav1.GetOptions{})
+	case *v1.StatefulSet:
+		return a.Client.AppsV1().StatefulSets(ns).Get(ctx, o.Name, metav1.GetOptions{})
+	case *corev1.Service:
+		return a.Client.CoreV1().Services(ns).Get(ctx, o.Name, metav1.GetOptions{})
+	case *corev1.Secret:
+		return a.Client.CoreV1().Secrets(ns).Get(ctx, o.Name, metav1.GetOptions{})
+	case *corev1.PersistentVolumeClaim:
+		return a.Client.CoreV1().PersistentVolumeClaims(ns).Get(ctx, o.Name, metav1.GetOptions{})
+	case *corev1.ConfigMap:
+		return a.Client.CoreV1().ConfigMaps(ns).Get(ctx, o.Name, metav1.GetOptions{})
+	case *networkingv1.Ingress:
+		return a.Client.NetworkingV1().Ingresses(ns).Get(ctx, o.Name, metav1.GetOptions{})
+	default:
+		return nil, fmt.Errorf("can't fetch %T from the cluster", obj)
+	}
+}
+
+// project trims live down to the fields present in desired, so only fields we have an opinion on are compared.
+// List items are matched by position, and live items beyond the desired ones are kept since they are a real difference.
+func project(live, desired interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		out := map[string]interface{}{}
+		for k, dv := range d {
+			if lv, ok := l[k]; ok {
+				out[k] = project(lv, dv)
+			}
+		}
+		return out
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+		out := make([]interface{}, len(l))
+		for i, lv := range l {
+			if i < len(d) {
+				out[i] = project(lv, d[i])
+			} else {
+				out[i] = lv
+			}
+		}
+		return out
+	default:
+		return live
+	}
+}
+
+// yamlLines renders obj as YAML, split into lines. A nil obj has no lines.
+func yamlLines(obj map[string]interface{}) ([]string, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func (a *Application) ingressObject() *networkingv1.Ingress {
+	cfg := a.Config.Ingress
+	pathType := networkingv1.PathTypePrefix
+
+	ing := &networkingv1.Ingress{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "Ingress",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      a.Config.Name,
+			Namespace: a.Config.Namespace,
+			Labels:    a.Config.Labels,
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: cfg.Host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     cfg.path(),
+									PathType: &pathType,
+									// the service has the application's name, see serviceObject
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: a.Config.Name,
+											Port: networkingv1.ServiceBackendPort{Number: a.Config.Svc.Port},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// empty leaves it to the cluster's default ingress class
+	if cfg.ClassName != "" {
+		ing.Spec.IngressClassName = &cfg.ClassName
+	}
+	if cfg.TLSSecretName != "" {
+		tls := networkingv1.IngressTLS{SecretName: cfg.TLSSecretName}
+		if cfg.Host != "" {
+			tls.Hosts = []string{cfg.Host}
+		}
+		ing.Spec.TLS = []networkingv1.IngressTLS{tls}
+	}
+
+	return ing
+}
+
+func (a *Application) ingress(ctx context.Context) error {
+	ing := a.ingressObject()
+
+	data, err := applyPatch(ing)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Client.NetworkingV1().Ingresses(a.Config.Namespace).Patch(ctx, ing.Name, types.ApplyPatchType, data, a.applyOptions())
+	return err
+}
+
+func (a *Application) deleteIngress(ctx context.Context) error {
+	err := a.Client.NetworkingV1().Ingresses(a.Config.Namespace).Delete(ctx, a.Config.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// URL returns the address the application's Ingress serves it at, or "" if it has no Ingress.
+// It assumes the ingress controller listens on the default HTTP and HTTPS ports.
+func (a *Application) URL() string {
+	hasIngress := false
+	for _, r := range a.Resources {
+		if r == Ingress {
+			hasIngress = true
+		}
+	}
+	if !hasIngress {
+		return ""
+	}
+
+	cfg := a.Config.Ingress
+	scheme := "http"
+	if cfg.TLSSecretName != "" {
+		scheme = "https"
+	}
+	host := cfg.Host
+	if host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, cfg.path())
+}
+
+// path is where the application is routed, the root unless a path is configured
+func (c *IngressConfig) path() string {
+	if c.Path == "" {
+		return "/"
+	}
+	return c.Path
+}
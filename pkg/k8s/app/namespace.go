@@ -0,0 +1,35 @@
+package app
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EnsureNamespace creates the namespace if it doesn't exist yet.
+// It reports whether the namespace had to be created.
+// Namespaces aren't an Application resource since several applications share one,
+// which also means Delete never removes them.
+func EnsureNamespace(client kubernetes.Interface, name string) (bool, error) {
+	ctx := context.Background()
+	nsCli := client.CoreV1().Namespaces()
+
+	if _, err := nsCli.Get(ctx, name, metav1.GetOptions{}); err == nil {
+		return false, nil
+	} else if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	if _, err := nsCli.Create(ctx, ns, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return false, err
+	}
+	return true, nil
+}
@@ -7,39 +7,54 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
-func (a *Application) pvc(ctx context.Context) error {
-	pvcCli := a.Client.CoreV1().PersistentVolumeClaims(a.Config.Namespace)
-
-	pvc := &corev1.PersistentVolumeClaim{
+func (a *Application) pvcObject() *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "PersistentVolumeClaim",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      a.Config.Name,
 			Namespace: a.Config.Namespace,
 			Labels:    a.Config.Labels,
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{
-				a.Config.Pvc.AccessMode,
-			},
-			Resources: corev1.VolumeResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse(a.Config.Pvc.Size),
-				},
+		Spec: claimSpec(a.Config.Pvc),
+	}
+}
+
+// claimSpec requests a volume of cfg.Size, which Validate has checked parses
+func claimSpec(cfg PvcConfig) corev1.PersistentVolumeClaimSpec {
+	return corev1.PersistentVolumeClaimSpec{
+		AccessModes: []corev1.PersistentVolumeAccessMode{
+			cfg.AccessMode,
+		},
+		Resources: corev1.VolumeResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse(cfg.Size),
 			},
 		},
 	}
+}
+
+func (a *Application) pvc(ctx context.Context) error {
+	pvc := a.pvcObject()
 
-	_, err := pvcCli.Create(ctx, pvc, metav1.CreateOptions{})
+	data, err := applyPatch(pvc)
 	if err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			if _, err := pvcCli.Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
-				return err
-			}
-			return nil
-		}
 		return err
 	}
 
+	_, err = a.Client.CoreV1().PersistentVolumeClaims(a.Config.Namespace).Patch(ctx, pvc.Name, types.ApplyPatchType, data, a.applyOptions())
+	return err
+}
+
+func (a *Application) deletePvc(ctx context.Context) error {
+	err := a.Client.CoreV1().PersistentVolumeClaims(a.Config.Namespace).Delete(ctx, a.Config.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
 	return nil
 }
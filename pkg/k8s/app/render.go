@@ -0,0 +1,86 @@
+package app
+
+import (
+	"io"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// RedactedValue replaces every Secret value in manifests written with redactSecrets.
+const RedactedValue = "***"
+
+// Objects returns the objects Deploy applies for a.Resources, in the same order.
+// They are built from the config alone, nothing is read from the cluster,
+// so a Secret holds the config's data rather than whatever is already stored in the cluster.
+func (a *Application) Objects() ([]runtime.Object, error) {
+	if err := a.Config.Validate(); err != nil {
+		return nil, err
+	}
+
+	var objs []runtime.Object
+	for _, obj := range a.Resources {
+		switch obj {
+		case Deployment:
+			objs = append(objs, a.deploymentObject())
+		case Service:
+			objs = append(objs, a.serviceObject())
+		case Secret:
+			objs = append(objs, a.secretObject())
+		case PVC:
+			objs = append(objs, a.pvcObject())
+		case StatefulSet:
+			objs = append(objs, a.statefulSetObject())
+		case ConfigMap:
+			objs = append(objs, a.configMapObject())
+		case Ingress:
+			objs = append(objs, a.ingressObject())
+		}
+	}
+	return objs, nil
+}
+
+// WriteManifests writes objs to w as a stream of YAML documents.
+// Like the patches Deploy sends, they leave out status and server-populated metadata.
+// With redactSecrets, every value of a Secret is replaced by RedactedValue so the output is safe to share.
+func WriteManifests(w io.Writer, objs []runtime.Object, redactSecrets bool) error {
+	for i, obj := range objs {
+		u, err := Manifest(obj)
+		if err != nil {
+			return err
+		}
+		if redactSecrets {
+			redact(u)
+		}
+
+		data, err := yaml.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redact masks the values of u if it is a Secret, keeping the keys so reviewers can still see what it holds
+func redact(u map[string]interface{}) {
+	if u["kind"] != "Secret" {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := u[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k := range values {
+			values[k] = RedactedValue
+		}
+	}
+}
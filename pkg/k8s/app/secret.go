@@ -7,12 +7,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
-func (a *Application) secret(ctx context.Context) error {
-	scrtCli := a.Client.CoreV1().Secrets(a.Config.Namespace)
-
-	secret := &corev1.Secret{
+func (a *Application) secretObject() *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      a.Config.Secret.SecretName,
 			Namespace: a.Config.Namespace,
@@ -21,15 +24,44 @@ func (a *Application) secret(ctx context.Context) error {
 		Type: a.Config.Secret.SecretType,
 		Data: a.Config.Secret.SecretData,
 	}
+}
 
-	_, err := scrtCli.Create(ctx, secret, metav1.CreateOptions{})
-	if err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			if _, err := scrtCli.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
-				return err
-			}
-			return nil
+func (a *Application) secret(ctx context.Context) error {
+	scrtCli := a.Client.CoreV1().Secrets(a.Config.Namespace)
+	secret := a.secretObject()
+
+	existing, err := scrtCli.Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	// If the secret already exists, we keep whatever data it holds
+	// and only fill in keys that are missing from it.
+	// Overwriting the data would, for example, hand out a fresh database password
+	// that no longer matches the one stored in the database's volume.
+	if err == nil {
+		data := map[string][]byte{}
+		for k, v := range a.Config.Secret.SecretData {
+			data[k] = v
+		}
+		for k, v := range existing.Data {
+			data[k] = v
 		}
+		secret.Data = data
+	}
+
+	data, err := applyPatch(secret)
+	if err != nil {
+		return err
+	}
+
+	_, err = scrtCli.Patch(ctx, secret.Name, types.ApplyPatchType, data, a.applyOptions())
+	return err
+}
+
+func (a *Application) deleteSecret(ctx context.Context) error {
+	err := a.Client.CoreV1().Secrets(a.Config.Namespace).Delete(ctx, a.Config.Secret.SecretName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
 	return nil
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RestrictedPodSecurityContext runs every container of the pod as the given user and group, never as root,
+// with the container runtime's default seccomp profile. Volumes that support ownership management
+// are made writable by the group, so the user can write its data without running as root to chown it.
+func RestrictedPodSecurityContext(uid, gid int64) *corev1.PodSecurityContext {
+	runAsNonRoot := true
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot: &runAsNonRoot,
+		RunAsUser:    &uid,
+		RunAsGroup:   &gid,
+		FSGroup:      &gid,
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// RestrictedSecurityContext keeps a container from gaining privileges: every capability is dropped,
+// privilege escalation is off and the root filesystem is read-only, so anything the container
+// writes has to go to a volume.
+func RestrictedSecurityContext() *corev1.SecurityContext {
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+}
+
+// containerSecurityContext is the security context of a container that doesn't set its own
+func (c *DeploymentConfig) containerSecurityContext(container corev1.Container) *corev1.SecurityContext {
+	if container.SecurityContext != nil || c.SecurityContext == nil {
+		return container.SecurityContext
+	}
+	return c.SecurityContext.DeepCopy()
+}
+
+// validateSecurity checks for settings the kubelet would refuse to start the containers with
+func (c *DeploymentConfig) validateSecurity() error {
+	pod := c.PodSecurityContext
+	if pod == nil {
+		return nil
+	}
+
+	if pod.RunAsNonRoot != nil && *pod.RunAsNonRoot && pod.RunAsUser != nil && *pod.RunAsUser == 0 {
+		return fmt.Errorf("can't run as user 0 when running as non-root is required")
+	}
+	for _, id := range []struct {
+		name  string
+		value *int64
+	}{
+		{"user", pod.RunAsUser},
+		{"group", pod.RunAsGroup},
+		{"fs group", pod.FSGroup},
+	} {
+		if id.value != nil && *id.value < 0 {
+			return fmt.Errorf("%s id must not be negative: %d", id.name, *id.value)
+		}
+	}
+	if profile := pod.SeccompProfile; profile != nil {
+		switch profile.Type {
+		case corev1.SeccompProfileTypeRuntimeDefault, corev1.SeccompProfileTypeUnconfined:
+		case corev1.SeccompProfileTypeLocalhost:
+			if profile.LocalhostProfile == nil || *profile.LocalhostProfile == "" {
+				return fmt.Errorf("a Localhost seccomp profile needs the path of the profile")
+			}
+		default:
+			return fmt.Errorf("unknown seccomp profile type %q", profile.Type)
+		}
+	}
+	return nil
+}
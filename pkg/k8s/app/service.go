@@ -6,44 +6,66 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-func (a *Application) service(ctx context.Context) error {
-	svcCli := a.Client.CoreV1().Services(a.Config.Namespace)
-
+func (a *Application) serviceObject() *corev1.Service {
 	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      a.Config.Name,
-			Namespace: a.Config.Namespace,
-			Labels:    a.Config.Labels,
+			Name:        a.Config.Name,
+			Namespace:   a.Config.Namespace,
+			Labels:      a.Config.Labels,
+			Annotations: a.Config.Svc.Annotations,
 		},
 		Spec: corev1.ServiceSpec{
+			Type:     a.Config.Svc.Type,
 			Selector: a.Config.Deployment.SelectorMatchLabels,
 			Ports: []corev1.ServicePort{
 				{
 					Name:     a.Config.Name,
 					Protocol: corev1.ProtocolTCP,
 					Port:     a.Config.Svc.Port,
+					NodePort: a.Config.Svc.NodePort,
 				},
 			},
+			LoadBalancerSourceRanges: a.Config.Svc.LoadBalancerSourceRanges,
 		},
 	}
 
+	// the service port can differ from the one the container listens on
+	if port := a.Config.Deployment.ContainerPort; port != 0 {
+		svc.Spec.Ports[0].TargetPort = intstr.FromInt32(port)
+	}
+
 	// Set ClusterIP to "None" for headless service
 	if a.Config.Svc.DisableClusterIP {
 		svc.Spec.ClusterIP = "None"
 	}
 
-	_, err := svcCli.Create(ctx, svc, metav1.CreateOptions{})
+	return svc
+}
+
+func (a *Application) service(ctx context.Context) error {
+	svc := a.serviceObject()
+
+	data, err := applyPatch(svc)
 	if err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			if _, err := svcCli.Update(ctx, svc, metav1.UpdateOptions{}); err != nil {
-				return err
-			}
-			return nil
-		}
 		return err
 	}
 
+	_, err = a.Client.CoreV1().Services(a.Config.Namespace).Patch(ctx, svc.Name, types.ApplyPatchType, data, a.applyOptions())
+	return err
+}
+
+func (a *Application) deleteService(ctx context.Context) error {
+	err := a.Client.CoreV1().Services(a.Config.Namespace).Delete(ctx, a.Config.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func (a *Application) statefulSetObject() *v1.StatefulSet {
+	var claims []corev1.PersistentVolumeClaim
+	for _, tmpl := range a.Config.StatefulSet.VolumeClaimTemplates {
+		claims = append(claims, corev1.PersistentVolumeClaim{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "PersistentVolumeClaim",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   tmpl.Name,
+				Labels: a.Config.Labels,
+			},
+			Spec: claimSpec(tmpl.PvcConfig),
+		})
+	}
+
+	return &v1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "StatefulSet",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      a.Config.Name,
+			Namespace: a.Config.Namespace,
+			Labels:    a.Config.Labels,
+		},
+		Spec: v1.StatefulSetSpec{
+			Replicas:    &a.Config.Deployment.Replicas,
+			ServiceName: a.Config.StatefulSet.ServiceName,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: a.Config.Deployment.SelectorMatchLabels,
+			},
+			Template:             a.podTemplate(),
+			VolumeClaimTemplates: claims,
+			PodManagementPolicy:  a.Config.StatefulSet.PodManagementPolicy,
+			UpdateStrategy:       a.Config.StatefulSet.UpdateStrategy,
+		},
+	}
+}
+
+func (a *Application) statefulSet(ctx context.Context) error {
+	sts := a.statefulSetObject()
+
+	data, err := applyPatch(sts)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Client.AppsV1().StatefulSets(a.Config.Namespace).Patch(ctx, sts.Name, types.ApplyPatchType, data, a.applyOptions())
+	return err
+}
+
+// deleteStatefulSet removes the StatefulSet and its pods.
+// The volumes claimed from its templates outlive it, see DeleteVolumeClaims.
+func (a *Application) deleteStatefulSet(ctx context.Context) error {
+	err := a.Client.AppsV1().StatefulSets(a.Config.Namespace).Delete(ctx, a.Config.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// DeleteVolumeClaims removes the volumes the application's StatefulSet claimed for its pods, and with them their data.
+// Kubernetes keeps those claims when a StatefulSet is deleted, so they have to be removed on their own.
+func (a *Application) DeleteVolumeClaims() error {
+	ctx := context.Background()
+	pvcCli := a.Client.CoreV1().PersistentVolumeClaims(a.Config.Namespace)
+
+	if len(a.Config.StatefulSet.VolumeClaimTemplates) == 0 {
+		return nil
+	}
+	selector := labels.SelectorFromSet(a.Config.Labels).String()
+	pvcs, err := pvcCli.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+
+	for _, tmpl := range a.Config.StatefulSet.VolumeClaimTemplates {
+		// claims are named <template>-<statefulset>-<ordinal>
+		prefix := fmt.Sprintf("%s-%s-", tmpl.Name, a.Config.Name)
+		for _, pvc := range pvcs.Items {
+			if !strings.HasPrefix(pvc.Name, prefix) {
+				continue
+			}
+			if err := pvcCli.Delete(ctx, pvc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
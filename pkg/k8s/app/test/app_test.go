@@ -1,17 +1,28 @@
 package app_test
 
 import (
+	"context"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 
 	"github.com/kol-ratner/tufin/pkg/k8s/app"
 )
 
 func TestApplication_Service(t *testing.T) {
-	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset := fake.NewClientset()
 
 	tests := []struct {
 		name      string
@@ -31,6 +42,56 @@ func TestApplication_Service(t *testing.T) {
 				},
 			},
 			wantError: false,
+		}, {
+			name: "load balancer",
+			config: &app.ApplicationConfig{
+				Name:      "test-app",
+				Namespace: "default",
+				Svc: app.SvcConfig{
+					Port:                     8080,
+					Type:                     corev1.ServiceTypeLoadBalancer,
+					NodePort:                 30080,
+					LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+					Annotations:              map[string]string{"example.com/owner": "team-a"},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name: "node port on a cluster ip service",
+			config: &app.ApplicationConfig{
+				Name:      "test-app",
+				Namespace: "default",
+				Svc:       app.SvcConfig{Port: 8080, NodePort: 30080},
+			},
+			wantError: true,
+		},
+		{
+			name: "node port outside the node port range",
+			config: &app.ApplicationConfig{
+				Name:      "test-app",
+				Namespace: "default",
+				Svc:       app.SvcConfig{Port: 8080, Type: corev1.ServiceTypeNodePort, NodePort: 8080},
+			},
+			wantError: true,
+		},
+		{
+			name: "source ranges on a node port service",
+			config: &app.ApplicationConfig{
+				Name:      "test-app",
+				Namespace: "default",
+				Svc:       app.SvcConfig{Port: 8080, Type: corev1.ServiceTypeNodePort, LoadBalancerSourceRanges: []string{"10.0.0.0/8"}},
+			},
+			wantError: true,
+		},
+		{
+			name: "exposed headless service",
+			config: &app.ApplicationConfig{
+				Name:      "test-app",
+				Namespace: "default",
+				Svc:       app.SvcConfig{Port: 3306, DisableClusterIP: true, Type: corev1.ServiceTypeNodePort},
+			},
+			wantError: true,
 		},
 	}
 
@@ -50,7 +111,7 @@ func TestApplication_Service(t *testing.T) {
 }
 
 func TestApplication_Deployment(t *testing.T) {
-	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset := fake.NewClientset()
 
 	tests := []struct {
 		name      string
@@ -142,7 +203,7 @@ func TestApplication_Deployment(t *testing.T) {
 }
 
 func TestApplication_Secret(t *testing.T) {
-	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset := fake.NewClientset()
 
 	tests := []struct {
 		name      string
@@ -206,7 +267,7 @@ func TestApplication_Secret(t *testing.T) {
 }
 
 func TestApplication_PVC(t *testing.T) {
-	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset := fake.NewClientset()
 
 	tests := []struct {
 		name      string
@@ -259,3 +320,1177 @@ func TestApplication_PVC(t *testing.T) {
 		})
 	}
 }
+
+func TestApplication_StatefulSet(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	ctx := context.Background()
+	partition := int32(1)
+
+	config := &app.ApplicationConfig{
+		Name:      "db",
+		Namespace: "default",
+		Labels: map[string]string{
+			"app": "db",
+		},
+		Deployment: app.DeploymentConfig{
+			Replicas:            3,
+			Image:               "mysql:8.0",
+			SelectorMatchLabels: map[string]string{"app": "db"},
+		},
+		StatefulSet: app.StatefulSetConfig{
+			ServiceName: "db",
+			VolumeClaimTemplates: []app.VolumeClaimTemplate{
+				{Name: "data", PvcConfig: app.PvcConfig{AccessMode: corev1.ReadWriteOnce, Size: "5Gi"}},
+			},
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+					Partition: &partition,
+				},
+			},
+		},
+	}
+	application := &app.Application{
+		Client:    fakeClientset,
+		Config:    config,
+		Resources: []app.KubernetesResource{app.StatefulSet},
+	}
+
+	if err := application.Deploy(); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	sts, err := fakeClientset.AppsV1().StatefulSets("default").Get(ctx, "db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected statefulset db, got err = %v", err)
+	}
+	if *sts.Spec.Replicas != 3 {
+		t.Errorf("replicas = %d, want 3", *sts.Spec.Replicas)
+	}
+	if sts.Spec.ServiceName != "db" {
+		t.Errorf("serviceName = %q, want db", sts.Spec.ServiceName)
+	}
+	if sts.Spec.PodManagementPolicy != appsv1.ParallelPodManagement {
+		t.Errorf("podManagementPolicy = %q, want %q", sts.Spec.PodManagementPolicy, appsv1.ParallelPodManagement)
+	}
+	if ru := sts.Spec.UpdateStrategy.RollingUpdate; ru == nil || ru.Partition == nil || *ru.Partition != 1 {
+		t.Errorf("updateStrategy = %+v, want a rolling update partitioned at 1", sts.Spec.UpdateStrategy)
+	}
+	if len(sts.Spec.VolumeClaimTemplates) != 1 {
+		t.Fatalf("got %d volume claim templates, want 1", len(sts.Spec.VolumeClaimTemplates))
+	}
+	claim := sts.Spec.VolumeClaimTemplates[0]
+	if got := claim.Spec.Resources.Requests[corev1.ResourceStorage]; got.Cmp(resource.MustParse("5Gi")) != 0 {
+		t.Errorf("claim template size = %s, want 5Gi", got.String())
+	}
+
+	// stand in for the statefulset controller, which claims a volume per pod
+	for _, name := range []string{"data-db-0", "data-db-1", "data-dbx-0"} {
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "db"}},
+		}
+		if _, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := application.Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := fakeClientset.AppsV1().StatefulSets("default").Get(ctx, "db", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected statefulset to be deleted, got err = %v", err)
+	}
+	pvcs, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pvcs.Items) != 3 {
+		t.Errorf("Delete() removed claimed volumes, %d left, want 3", len(pvcs.Items))
+	}
+
+	if err := application.DeleteVolumeClaims(); err != nil {
+		t.Fatalf("DeleteVolumeClaims() error = %v", err)
+	}
+	pvcs, err = fakeClientset.CoreV1().PersistentVolumeClaims("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// claims of another statefulset that merely shares the prefix are left alone
+	if len(pvcs.Items) != 1 || pvcs.Items[0].Name != "data-dbx-0" {
+		t.Errorf("DeleteVolumeClaims() left %v, want only data-dbx-0", pvcs.Items)
+	}
+}
+
+func TestApplicationConfig_ValidateStatefulSet(t *testing.T) {
+	partition, negative := int32(1), int32(-1)
+	tests := []struct {
+		name        string
+		statefulSet app.StatefulSetConfig
+		wantError   string
+	}{
+		{
+			name:        "api server defaults",
+			statefulSet: app.StatefulSetConfig{},
+		},
+		{
+			name: "on delete",
+			statefulSet: app.StatefulSetConfig{
+				PodManagementPolicy: appsv1.OrderedReadyPodManagement,
+				UpdateStrategy:      appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+			},
+		},
+		{
+			name:        "unknown pod management policy",
+			statefulSet: app.StatefulSetConfig{PodManagementPolicy: "Random"},
+			wantError:   `db: unknown pod management policy "Random"`,
+		},
+		{
+			name:        "unknown update strategy",
+			statefulSet: app.StatefulSetConfig{UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: "Recreate"}},
+			wantError:   `db: unknown update strategy "Recreate"`,
+		},
+		{
+			name: "partition with on delete",
+			statefulSet: app.StatefulSetConfig{UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type:          appsv1.OnDeleteStatefulSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+			}},
+			wantError: "db: rolling update settings only apply to the RollingUpdate update strategy",
+		},
+		{
+			name: "negative partition",
+			statefulSet: app.StatefulSetConfig{UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &negative},
+			}},
+			wantError: "db: update partition must not be negative: -1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &app.ApplicationConfig{Name: "db", StatefulSet: tt.statefulSet}
+			err := config.Validate()
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantError {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestApplication_DeploymentProbes(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	startup := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: []string{"mysqladmin", "ping"}},
+		},
+		FailureThreshold: 30,
+	}
+	readiness := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt32(80)},
+		},
+		PeriodSeconds: 5,
+	}
+
+	application := &app.Application{
+		Client: fakeClientset,
+		Config: &app.ApplicationConfig{
+			Name:      "test-app",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "test-app"},
+			Deployment: app.DeploymentConfig{
+				Replicas:            1,
+				Image:               "nginx:latest",
+				ContainerPort:       80,
+				SelectorMatchLabels: map[string]string{"app": "test-app"},
+				StartupProbe:        startup,
+				ReadinessProbe:      readiness,
+			},
+		},
+		Resources: []app.KubernetesResource{app.Deployment},
+	}
+	if err := application.Deploy(); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	dep, err := fakeClientset.AppsV1().Deployments("default").Get(context.Background(), "test-app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := dep.Spec.Template.Spec.Containers[0]
+	if !reflect.DeepEqual(container.StartupProbe, startup) {
+		t.Errorf("startup probe = %+v, want %+v", container.StartupProbe, startup)
+	}
+	if !reflect.DeepEqual(container.ReadinessProbe, readiness) {
+		t.Errorf("readiness probe = %+v, want %+v", container.ReadinessProbe, readiness)
+	}
+	if container.LivenessProbe != nil {
+		t.Errorf("liveness probe = %+v, want none", container.LivenessProbe)
+	}
+}
+
+func TestApplicationConfig_ValidateProbes(t *testing.T) {
+	exec := corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"true"}}}
+	tests := []struct {
+		name       string
+		deployment app.DeploymentConfig
+		wantError  string
+	}{
+		{
+			name: "valid probes",
+			deployment: app.DeploymentConfig{
+				LivenessProbe:  &corev1.Probe{ProbeHandler: exec, PeriodSeconds: 10},
+				ReadinessProbe: &corev1.Probe{ProbeHandler: exec, SuccessThreshold: 2},
+			},
+		},
+		{
+			name:       "no handler",
+			deployment: app.DeploymentConfig{StartupProbe: &corev1.Probe{PeriodSeconds: 10}},
+			wantError:  "web: startup probe needs exactly one of exec, httpGet, tcpSocket or grpc, got 0",
+		},
+		{
+			name: "two handlers",
+			deployment: app.DeploymentConfig{LivenessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+				Exec:      exec.Exec,
+				TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(80)},
+			}}},
+			wantError: "web: liveness probe needs exactly one of exec, httpGet, tcpSocket or grpc, got 2",
+		},
+		{
+			name: "relative path",
+			deployment: app.DeploymentConfig{ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "healthz", Port: intstr.FromInt32(80)},
+			}}},
+			wantError: "web: readiness probe path must start with a /: healthz",
+		},
+		{
+			name:       "negative timing",
+			deployment: app.DeploymentConfig{ReadinessProbe: &corev1.Probe{ProbeHandler: exec, TimeoutSeconds: -1}},
+			wantError:  "web: readiness probe timings must not be negative",
+		},
+		{
+			name:       "liveness success threshold",
+			deployment: app.DeploymentConfig{LivenessProbe: &corev1.Probe{ProbeHandler: exec, SuccessThreshold: 2}},
+			wantError:  "web: liveness probe success threshold must be 1, got 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &app.ApplicationConfig{Name: "web", Deployment: tt.deployment}
+			err := config.Validate()
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantError {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestApplication_PodSecurity(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	ownContext := &corev1.SecurityContext{RunAsUser: ptr(int64(0)), RunAsNonRoot: ptr(false)}
+
+	application := &app.Application{
+		Client: fakeClientset,
+		Config: &app.ApplicationConfig{
+			Name:      "test-app",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "test-app"},
+			Deployment: app.DeploymentConfig{
+				Replicas:            1,
+				Image:               "nginx:latest",
+				ContainerPort:       80,
+				SelectorMatchLabels: map[string]string{"app": "test-app"},
+				InitContainers: []corev1.Container{
+					{Name: "inherits", Image: "busybox"},
+					{Name: "own", Image: "busybox", SecurityContext: ownContext},
+				},
+				PodSecurityContext: app.RestrictedPodSecurityContext(101, 101),
+				SecurityContext:    app.RestrictedSecurityContext(),
+			},
+		},
+		Resources: []app.KubernetesResource{app.Deployment},
+	}
+	if err := application.Deploy(); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	dep, err := fakeClientset.AppsV1().Deployments("default").Get(context.Background(), "test-app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := dep.Spec.Template.Spec
+	if spec.AutomountServiceAccountToken == nil || *spec.AutomountServiceAccountToken {
+		t.Errorf("automountServiceAccountToken = %v, want false", spec.AutomountServiceAccountToken)
+	}
+
+	pod := spec.SecurityContext
+	if pod == nil || !*pod.RunAsNonRoot || *pod.RunAsUser != 101 || *pod.FSGroup != 101 ||
+		pod.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("pod security context = %+v, want non-root user 101 with the runtime's seccomp profile", pod)
+	}
+
+	restricted := app.RestrictedSecurityContext()
+	for _, c := range []corev1.Container{spec.Containers[0], spec.InitContainers[0]} {
+		if !reflect.DeepEqual(c.SecurityContext, restricted) {
+			t.Errorf("%s security context = %+v, want %+v", c.Name, c.SecurityContext, restricted)
+		}
+	}
+	if !reflect.DeepEqual(spec.InitContainers[1].SecurityContext, ownContext) {
+		t.Errorf("own security context = %+v, want it kept as %+v", spec.InitContainers[1].SecurityContext, ownContext)
+	}
+	if application.Config.Deployment.InitContainers[0].SecurityContext != nil {
+		t.Error("the config's init containers were modified")
+	}
+}
+
+func TestApplicationConfig_ValidateSecurity(t *testing.T) {
+	tests := []struct {
+		name      string
+		pod       *corev1.PodSecurityContext
+		wantError string
+	}{
+		{
+			name: "restricted",
+			pod:  app.RestrictedPodSecurityContext(999, 999),
+		},
+		{
+			name: "root without run as non-root",
+			pod:  &corev1.PodSecurityContext{RunAsUser: ptr(int64(0))},
+		},
+		{
+			name:      "root with run as non-root",
+			pod:       &corev1.PodSecurityContext{RunAsUser: ptr(int64(0)), RunAsNonRoot: ptr(true)},
+			wantError: "web: can't run as user 0 when running as non-root is required",
+		},
+		{
+			name:      "negative fs group",
+			pod:       &corev1.PodSecurityContext{FSGroup: ptr(int64(-1))},
+			wantError: "web: fs group id must not be negative: -1",
+		},
+		{
+			name:      "localhost profile without a path",
+			pod:       &corev1.PodSecurityContext{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost}},
+			wantError: "web: a Localhost seccomp profile needs the path of the profile",
+		},
+		{
+			name:      "unknown seccomp profile",
+			pod:       &corev1.PodSecurityContext{SeccompProfile: &corev1.SeccompProfile{Type: "Strict"}},
+			wantError: `web: unknown seccomp profile type "Strict"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &app.ApplicationConfig{Name: "web", Deployment: app.DeploymentConfig{PodSecurityContext: tt.pod}}
+			err := config.Validate()
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantError {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestApplication_ConfigMap(t *testing.T) {
+	tests := []struct {
+		name      string
+		configMap app.ConfigMapConfig
+		wantError string
+	}{
+		{
+			name: "mounted file",
+			configMap: app.ConfigMapConfig{
+				Name:       "test-app-config",
+				Files:      map[string]string{"app.conf": "debug = true\n"},
+				MountPaths: map[string]string{"app.conf": "/etc/app/app.conf", "other.conf": "/etc/app/other.conf"},
+			},
+		},
+		{
+			name: "no files",
+			configMap: app.ConfigMapConfig{
+				Name:       "test-app-config",
+				MountPaths: map[string]string{"app.conf": "/etc/app/app.conf"},
+			},
+		},
+		{
+			name: "file without mount path",
+			configMap: app.ConfigMapConfig{
+				Name:  "test-app-config",
+				Files: map[string]string{"app.conf": "debug = true\n"},
+			},
+			wantError: "test-app: file app.conf has no mount path",
+		},
+		{
+			name: "invalid file name",
+			configMap: app.ConfigMapConfig{
+				Name:       "test-app-config",
+				Files:      map[string]string{"conf/app.conf": "debug = true\n"},
+				MountPaths: map[string]string{"conf/app.conf": "/etc/app/app.conf"},
+			},
+			wantError: `test-app: invalid file name "conf/app.conf"`,
+		},
+		{
+			name: "too large",
+			configMap: app.ConfigMapConfig{
+				Name:       "test-app-config",
+				Files:      map[string]string{"app.conf": strings.Repeat("x", 1<<20)},
+				MountPaths: map[string]string{"app.conf": "/etc/app/app.conf"},
+			},
+			wantError: "more than the 1048576 a ConfigMap can hold",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := fake.NewClientset()
+			ctx := context.Background()
+			config := &app.ApplicationConfig{
+				Name:      "test-app",
+				Namespace: "default",
+				Labels:    map[string]string{"app": "test-app"},
+				Deployment: app.DeploymentConfig{
+					Replicas:            1,
+					Image:               "nginx:latest",
+					SelectorMatchLabels: map[string]string{"app": "test-app"},
+				},
+				ConfigMap: tt.configMap,
+			}
+			application := app.NewApplication(fakeClientset, config, []app.KubernetesResource{app.ConfigMap, app.Deployment})
+
+			err := application.Deploy()
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("Deploy() error = %v, want it to contain %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Deploy() error = %v", err)
+			}
+
+			cm, err := fakeClientset.CoreV1().ConfigMaps("default").Get(ctx, "test-app-config", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("expected configmap test-app-config, got err = %v", err)
+			}
+			if len(cm.Data) != len(tt.configMap.Files) {
+				t.Errorf("configmap holds %d files, want %d", len(cm.Data), len(tt.configMap.Files))
+			}
+
+			d, err := fakeClientset.AppsV1().Deployments("default").Get(ctx, "test-app", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// only the files that are given are mounted
+			mounts := d.Spec.Template.Spec.Containers[0].VolumeMounts
+			if len(mounts) != len(tt.configMap.Files) {
+				t.Errorf("got %d volume mounts, want %d", len(mounts), len(tt.configMap.Files))
+			}
+			for _, m := range mounts {
+				if m.MountPath != tt.configMap.MountPaths[m.SubPath] || !m.ReadOnly {
+					t.Errorf("file %s mounted read-only %v at %s, want read-only at %s", m.SubPath, m.ReadOnly, m.MountPath, tt.configMap.MountPaths[m.SubPath])
+				}
+			}
+			_, annotated := d.Spec.Template.Annotations[app.ConfigChecksumAnnotation]
+			if annotated != (len(tt.configMap.Files) > 0) {
+				t.Errorf("pod template annotations = %v", d.Spec.Template.Annotations)
+			}
+
+			if err := application.Delete(); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := fakeClientset.CoreV1().ConfigMaps("default").Get(ctx, "test-app-config", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("expected configmap to be deleted, got err = %v", err)
+			}
+		})
+	}
+}
+
+func TestApplication_Ingress(t *testing.T) {
+	tests := []struct {
+		name    string
+		ingress app.IngressConfig
+		wantURL string
+	}{
+		{
+			name:    "host",
+			ingress: app.IngressConfig{Host: "blog.localhost"},
+			wantURL: "http://blog.localhost/",
+		},
+		{
+			name: "tls with class and path",
+			ingress: app.IngressConfig{
+				Host:          "blog.example.com",
+				Path:          "/blog",
+				ClassName:     "nginx",
+				TLSSecretName: "blog-tls",
+			},
+			wantURL: "https://blog.example.com/blog",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := fake.NewClientset()
+			ctx := context.Background()
+			config := &app.ApplicationConfig{
+				Name:      "blog",
+				Namespace: "default",
+				Labels:    map[string]string{"app": "blog"},
+				Svc:       app.SvcConfig{Port: 80},
+				Ingress:   tt.ingress,
+			}
+			application := app.NewApplication(fakeClientset, config, []app.KubernetesResource{app.Service, app.Ingress})
+			if err := application.Deploy(); err != nil {
+				t.Fatalf("Deploy() error = %v", err)
+			}
+
+			ing, err := fakeClientset.NetworkingV1().Ingresses("default").Get(ctx, "blog", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("expected ingress blog, got err = %v", err)
+			}
+			rule := ing.Spec.Rules[0]
+			if rule.Host != tt.ingress.Host {
+				t.Errorf("host = %s, want %s", rule.Host, tt.ingress.Host)
+			}
+			backend := rule.HTTP.Paths[0].Backend.Service
+			if backend.Name != "blog" || backend.Port.Number != 80 {
+				t.Errorf("backend = %+v, want service blog on port 80", backend)
+			}
+			if tt.ingress.ClassName != "" && (ing.Spec.IngressClassName == nil || *ing.Spec.IngressClassName != tt.ingress.ClassName) {
+				t.Errorf("ingressClassName = %v, want %s", ing.Spec.IngressClassName, tt.ingress.ClassName)
+			}
+			if tt.ingress.TLSSecretName != "" && (len(ing.Spec.TLS) != 1 || ing.Spec.TLS[0].SecretName != tt.ingress.TLSSecretName) {
+				t.Errorf("tls = %+v, want secret %s", ing.Spec.TLS, tt.ingress.TLSSecretName)
+			}
+
+			if got := application.URL(); got != tt.wantURL {
+				t.Errorf("URL() = %s, want %s", got, tt.wantURL)
+			}
+
+			if err := application.Delete(); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := fakeClientset.NetworkingV1().Ingresses("default").Get(ctx, "blog", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("expected ingress to be deleted, got err = %v", err)
+			}
+		})
+	}
+}
+
+func TestApplication_Delete(t *testing.T) {
+	config := &app.ApplicationConfig{
+		Name:      "test-app",
+		Namespace: "default",
+		Labels: map[string]string{
+			"app": "test-app",
+		},
+		Deployment: app.DeploymentConfig{
+			Replicas: 1,
+			Image:    "nginx:latest",
+		},
+		Pvc: app.PvcConfig{
+			AccessMode: corev1.ReadWriteOnce,
+			Size:       "1Gi",
+		},
+		Svc: app.SvcConfig{
+			Port: 80,
+		},
+		Secret: app.SecretConfig{
+			SecretName: "test-app-creds",
+			SecretType: "Opaque",
+			SecretData: map[string][]byte{
+				"password": []byte("secret123"),
+			},
+		},
+	}
+	allResources := []app.KubernetesResource{app.Deployment, app.PVC, app.Service, app.Secret}
+
+	tests := []struct {
+		name      string
+		deployed  []app.KubernetesResource
+		deleted   []app.KubernetesResource
+		wantPVC   bool
+		wantError bool
+	}{
+		{
+			name:      "delete all resources",
+			deployed:  allResources,
+			deleted:   allResources,
+			wantPVC:   false,
+			wantError: false,
+		},
+		{
+			name:      "delete everything but the pvc",
+			deployed:  allResources,
+			deleted:   []app.KubernetesResource{app.Deployment, app.Service, app.Secret},
+			wantPVC:   true,
+			wantError: false,
+		},
+		{
+			name:      "delete resources that were never deployed",
+			deployed:  nil,
+			deleted:   allResources,
+			wantPVC:   false,
+			wantError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := fake.NewClientset()
+			if err := app.NewApplication(fakeClientset, config, tt.deployed).Deploy(); err != nil {
+				t.Fatalf("Deploy() error = %v", err)
+			}
+
+			err := app.NewApplication(fakeClientset, config, tt.deleted).Delete()
+			if (err != nil) != tt.wantError {
+				t.Errorf("Delete() error = %v, wantError %v", err, tt.wantError)
+			}
+
+			ctx := context.Background()
+			if _, err := fakeClientset.AppsV1().Deployments("default").Get(ctx, "test-app", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("expected deployment to be deleted, got err = %v", err)
+			}
+			if _, err := fakeClientset.CoreV1().Secrets("default").Get(ctx, "test-app-creds", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("expected secret to be deleted, got err = %v", err)
+			}
+			_, err = fakeClientset.CoreV1().PersistentVolumeClaims("default").Get(ctx, "test-app", metav1.GetOptions{})
+			if gotPVC := err == nil; gotPVC != tt.wantPVC {
+				t.Errorf("pvc exists = %v, want %v", gotPVC, tt.wantPVC)
+			}
+		})
+	}
+}
+
+func TestApplication_SecretKeepsExistingData(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	config := &app.ApplicationConfig{
+		Name:      "mysql",
+		Namespace: "default",
+		Secret: app.SecretConfig{
+			SecretName: "mysql-creds",
+			SecretType: "Opaque",
+			SecretData: map[string][]byte{
+				"password": []byte("first"),
+			},
+		},
+	}
+
+	application := app.NewApplication(fakeClientset, config, []app.KubernetesResource{app.Secret})
+	if err := application.Deploy(); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	config.Secret.SecretData = map[string][]byte{
+		"password": []byte("second"),
+		"username": []byte("wordpress"),
+	}
+	if err := application.Deploy(); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	secret, err := fakeClientset.CoreV1().Secrets("default").Get(context.Background(), "mysql-creds", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data["password"]); got != "first" {
+		t.Errorf("password = %q, want the existing %q", got, "first")
+	}
+	if got := string(secret.Data["username"]); got != "wordpress" {
+		t.Errorf("username = %q, want the missing key to be added as %q", got, "wordpress")
+	}
+}
+
+func TestApplication_DeployConflicts(t *testing.T) {
+	tests := []struct {
+		name           string
+		forceConflicts bool
+		wantReplicas   int32
+		wantError      bool
+	}{
+		{
+			name:           "field owned by another manager",
+			forceConflicts: false,
+			wantReplicas:   5,
+			wantError:      true,
+		},
+		{
+			name:           "force conflicts takes ownership",
+			forceConflicts: true,
+			wantReplicas:   3,
+			wantError:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := fake.NewClientset()
+			ctx := context.Background()
+
+			other := appsv1ac.Deployment("test-app", "default").
+				WithSpec(appsv1ac.DeploymentSpec().WithReplicas(5))
+			if _, err := fakeClientset.AppsV1().Deployments("default").Apply(ctx, other, metav1.ApplyOptions{FieldManager: "kubectl"}); err != nil {
+				t.Fatal(err)
+			}
+
+			application := app.NewApplication(fakeClientset, &app.ApplicationConfig{
+				Name:      "test-app",
+				Namespace: "default",
+				Deployment: app.DeploymentConfig{
+					Replicas: 3,
+					Image:    "nginx:latest",
+				},
+			}, []app.KubernetesResource{app.Deployment})
+			application.ForceConflicts = tt.forceConflicts
+
+			err := application.Deploy()
+			if (err != nil) != tt.wantError {
+				t.Errorf("Deploy() error = %v, wantError %v", err, tt.wantError)
+			}
+			if tt.wantError && !apierrors.IsConflict(err) {
+				t.Errorf("Deploy() error = %v, want a conflict", err)
+			}
+
+			got, err := fakeClientset.AppsV1().Deployments("default").Get(ctx, "test-app", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got.Spec.Replicas != tt.wantReplicas {
+				t.Errorf("replicas = %d, want %d", *got.Spec.Replicas, tt.wantReplicas)
+			}
+		})
+	}
+}
+
+func TestApplication_DeployIsIdempotent(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	application := app.NewApplication(fakeClientset, &app.ApplicationConfig{
+		Name:      "test-app",
+		Namespace: "default",
+		Labels: map[string]string{
+			"app": "test-app",
+		},
+		Deployment: app.DeploymentConfig{
+			Replicas: 1,
+			Image:    "nginx:latest",
+		},
+		Pvc: app.PvcConfig{
+			AccessMode: corev1.ReadWriteOnce,
+			Size:       "1Gi",
+		},
+		Svc: app.SvcConfig{
+			Port:             80,
+			DisableClusterIP: true,
+		},
+	}, []app.KubernetesResource{app.Deployment, app.PVC, app.Service})
+
+	for i := 0; i < 3; i++ {
+		if err := application.Deploy(); err != nil {
+			t.Fatalf("Deploy() run %d error = %v", i+1, err)
+		}
+	}
+
+	got, err := fakeClientset.AppsV1().Deployments("default").Get(context.Background(), "test-app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	managers := map[string]bool{}
+	for _, entry := range got.ManagedFields {
+		managers[entry.Manager] = true
+	}
+	if !managers[app.FieldManager] || len(managers) != 1 {
+		t.Errorf("field managers = %v, want only %s", managers, app.FieldManager)
+	}
+}
+
+func TestApplication_WaitReady(t *testing.T) {
+	config := &app.ApplicationConfig{
+		Name:      "test-app",
+		Namespace: "default",
+		Labels: map[string]string{
+			"app": "test-app",
+		},
+		Deployment: app.DeploymentConfig{
+			Replicas: 1,
+			Image:    "nginx:latest",
+			SelectorMatchLabels: map[string]string{
+				"app": "test-app",
+			},
+		},
+		Pvc: app.PvcConfig{
+			AccessMode: corev1.ReadWriteOnce,
+			Size:       "1Gi",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		ready     bool
+		pods      []runtime.Object
+		wantError string
+	}{
+		{
+			name:      "rolled out",
+			ready:     true,
+			wantError: "",
+		},
+		{
+			name:  "crash looping pod",
+			ready: false,
+			pods: []runtime.Object{
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test-app-abc",
+						Namespace: "default",
+						Labels:    map[string]string{"app": "test-app"},
+					},
+					Status: corev1.PodStatus{
+						ContainerStatuses: []corev1.ContainerStatus{
+							{
+								Name: "test-app",
+								State: corev1.ContainerState{
+									Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+								},
+								LastTerminationState: corev1.ContainerState{
+									Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1},
+								},
+							},
+						},
+					},
+				},
+				&corev1.Event{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test-app-abc.1",
+						Namespace: "default",
+					},
+					InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "test-app-abc"},
+					Type:           corev1.EventTypeWarning,
+					Reason:         "BackOff",
+					Message:        "Back-off restarting failed container",
+				},
+			},
+			wantError: "CrashLoopBackOff",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := fake.NewClientset(tt.pods...)
+			application := app.NewApplication(fakeClientset, config, []app.KubernetesResource{app.PVC, app.Deployment})
+			if err := application.Deploy(); err != nil {
+				t.Fatalf("Deploy() error = %v", err)
+			}
+
+			ctx := context.Background()
+			if tt.ready {
+				pvc, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").Get(ctx, "test-app", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pvc.Status.Phase = corev1.ClaimBound
+				if _, err := fakeClientset.CoreV1().PersistentVolumeClaims("default").UpdateStatus(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+
+				d, err := fakeClientset.AppsV1().Deployments("default").Get(ctx, "test-app", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				d.Status.Replicas = 1
+				d.Status.UpdatedReplicas = 1
+				d.Status.ReadyReplicas = 1
+				d.Status.AvailableReplicas = 1
+				if _, err := fakeClientset.AppsV1().Deployments("default").UpdateStatus(ctx, d, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			msgs := make(chan string, 100)
+			err := application.WaitReady(waitCtx, msgs)
+
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("WaitReady() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("WaitReady() error = %v, want it to mention %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestApplication_WaitReadyStatefulSet(t *testing.T) {
+	partition := int32(2)
+
+	tests := []struct {
+		name      string
+		strategy  appsv1.StatefulSetUpdateStrategy
+		status    appsv1.StatefulSetStatus
+		wantReady bool
+	}{
+		{
+			name:      "rolled out",
+			status:    appsv1.StatefulSetStatus{ReadyReplicas: 3, UpdatedReplicas: 3, CurrentRevision: "r2", UpdateRevision: "r2"},
+			wantReady: true,
+		},
+		{
+			name:      "pods not ready",
+			status:    appsv1.StatefulSetStatus{ReadyReplicas: 1, CurrentRevision: "r1", UpdateRevision: "r1"},
+			wantReady: false,
+		},
+		{
+			name:      "rolling update in progress",
+			status:    appsv1.StatefulSetStatus{ReadyReplicas: 3, UpdatedReplicas: 1, CurrentRevision: "r1", UpdateRevision: "r2"},
+			wantReady: false,
+		},
+		{
+			name: "partition updated",
+			strategy: appsv1.StatefulSetUpdateStrategy{
+				Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+			},
+			status:    appsv1.StatefulSetStatus{ReadyReplicas: 3, UpdatedReplicas: 1, CurrentRevision: "r1", UpdateRevision: "r2"},
+			wantReady: true,
+		},
+		{
+			name:      "on delete",
+			strategy:  appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+			status:    appsv1.StatefulSetStatus{ReadyReplicas: 3, CurrentRevision: "r1", UpdateRevision: "r2"},
+			wantReady: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClientset := fake.NewClientset()
+			config := &app.ApplicationConfig{
+				Name:      "db",
+				Namespace: "default",
+				Labels:    map[string]string{"app": "db"},
+				Deployment: app.DeploymentConfig{
+					Replicas:            3,
+					Image:               "mysql:8.0",
+					SelectorMatchLabels: map[string]string{"app": "db"},
+				},
+				StatefulSet: app.StatefulSetConfig{ServiceName: "db", UpdateStrategy: tt.strategy},
+			}
+			application := app.NewApplication(fakeClientset, config, []app.KubernetesResource{app.StatefulSet})
+			if err := application.Deploy(); err != nil {
+				t.Fatalf("Deploy() error = %v", err)
+			}
+
+			ctx := context.Background()
+			sts, err := fakeClientset.AppsV1().StatefulSets("default").Get(ctx, "db", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sts.Status = tt.status
+			if _, err := fakeClientset.AppsV1().StatefulSets("default").UpdateStatus(ctx, sts, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			waitCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			defer cancel()
+			err = application.WaitReady(waitCtx, make(chan string, 100))
+			if (err == nil) != tt.wantReady {
+				t.Errorf("WaitReady() error = %v, want ready %v", err, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestWriteManifests(t *testing.T) {
+	application := app.NewApplication(nil, &app.ApplicationConfig{
+		Name:      "test-app",
+		Namespace: "default",
+		Labels: map[string]string{
+			"app": "test-app",
+		},
+		Deployment: app.DeploymentConfig{
+			Replicas: 1,
+			Image:    "nginx:latest",
+		},
+		Svc: app.SvcConfig{
+			Port: 80,
+		},
+		Secret: app.SecretConfig{
+			SecretName: "test-app-creds",
+			SecretType: corev1.SecretTypeOpaque,
+			SecretData: map[string][]byte{
+				"password": []byte("hunter2"),
+			},
+		},
+	}, []app.KubernetesResource{app.Deployment, app.Service, app.Secret})
+
+	objs, err := application.Objects()
+	if err != nil {
+		t.Fatalf("Objects() error = %v", err)
+	}
+	if len(objs) != 3 {
+		t.Fatalf("Objects() returned %d objects, want 3", len(objs))
+	}
+
+	var out strings.Builder
+	if err := app.WriteManifests(&out, objs, true); err != nil {
+		t.Fatalf("WriteManifests() error = %v", err)
+	}
+	got := out.String()
+
+	docs := strings.Split(got, "---\n")
+	if len(docs) != 3 {
+		t.Fatalf("got %d YAML documents, want 3:\n%s", len(docs), got)
+	}
+	for i, kind := range []string{"Deployment", "Service", "Secret"} {
+		if !strings.Contains(docs[i], "kind: "+kind+"\n") {
+			t.Errorf("document %d is not a %s:\n%s", i, kind, docs[i])
+		}
+	}
+	if !strings.Contains(docs[2], "password: '"+app.RedactedValue+"'") {
+		t.Errorf("expected the secret's password to be redacted:\n%s", docs[2])
+	}
+	for _, leaked := range []string{"hunter2", "aHVudGVyMg==", "status:", "creationTimestamp"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("manifests contain %q:\n%s", leaked, got)
+		}
+	}
+}
+
+func TestApplication_DeployDryRun(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	application := app.NewApplication(fakeClientset, &app.ApplicationConfig{
+		Name:      "test-app",
+		Namespace: "default",
+		Deployment: app.DeploymentConfig{
+			Replicas: 1,
+			Image:    "nginx:latest",
+		},
+		Svc: app.SvcConfig{
+			Port: 80,
+		},
+	}, []app.KubernetesResource{app.Deployment, app.Service})
+	application.DryRun = true
+
+	if err := application.Deploy(); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	patches := 0
+	for _, action := range fakeClientset.Actions() {
+		patch, ok := action.(k8stesting.PatchActionImpl)
+		if !ok {
+			continue
+		}
+		patches++
+		if dryRun := patch.PatchOptions.DryRun; len(dryRun) != 1 || dryRun[0] != metav1.DryRunAll {
+			t.Errorf("%s patch DryRun = %v, want [%s]", patch.GetResource().Resource, dryRun, metav1.DryRunAll)
+		}
+	}
+	if patches != 2 {
+		t.Errorf("got %d patches, want 2", patches)
+	}
+}
+
+func TestApplication_Diff(t *testing.T) {
+	fakeClientset := fake.NewClientset()
+	config := &app.ApplicationConfig{
+		Name:      "test-app",
+		Namespace: "default",
+		Labels: map[string]string{
+			"app": "test-app",
+		},
+		Deployment: app.DeploymentConfig{
+			Replicas: 1,
+			Image:    "nginx:latest",
+		},
+		Svc: app.SvcConfig{
+			Port: 80,
+		},
+		Secret: app.SecretConfig{
+			SecretName: "test-app-creds",
+			SecretType: corev1.SecretTypeOpaque,
+			SecretData: map[string][]byte{
+				"password": []byte("hunter2"),
+			},
+		},
+	}
+	application := app.NewApplication(fakeClientset, config, []app.KubernetesResource{app.Deployment, app.Service, app.Secret})
+	ctx := context.Background()
+
+	diffs, err := application.Diff(ctx)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 3 {
+		t.Fatalf("Diff() before deploying returned %d diffs, want 3", len(diffs))
+	}
+	if !strings.Contains(diffs[0].Diff, "@@ -0,0 +1,") || !strings.Contains(diffs[0].Diff, "+kind: Deployment") {
+		t.Errorf("expected a missing deployment to be diffed as an addition, got:\n%s", diffs[0].Diff)
+	}
+
+	if err := application.Deploy(); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	// other controllers adding labels and changing secret values isn't drift
+	svc, err := fakeClientset.CoreV1().Services("default").Get(ctx, "test-app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Labels["team"] = "web"
+	if _, err := fakeClientset.CoreV1().Services("default").Update(ctx, svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	config.Secret.SecretData = map[string][]byte{"password": []byte("changed")}
+
+	diffs, err = application.Diff(ctx)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("Diff() after deploying returned %v, want no diffs", diffs)
+	}
+
+	config.Deployment.Replicas = 3
+	diffs, err = application.Diff(ctx)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Name != "Deployment/test-app" {
+		t.Fatalf("Diff() = %v, want only the deployment to differ", diffs)
+	}
+	for _, want := range []string{"--- live/Deployment/test-app", "+++ desired/Deployment/test-app", "-  replicas: 1", "+  replicas: 3"} {
+		if !strings.Contains(diffs[0].Diff, want) {
+			t.Errorf("diff doesn't contain %q:\n%s", want, diffs[0].Diff)
+		}
+	}
+	if strings.Contains(diffs[0].Diff, "+kind") {
+		t.Errorf("diff should only contain the changed lines and their context:\n%s", diffs[0].Diff)
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffOp is one line of an edit script: kept (' '), removed ('-') or added ('+').
+// from and to are the line's position in each input when the op is reached.
+type diffOp struct {
+	kind     byte
+	line     string
+	from, to int
+}
+
+// unifiedDiff returns the changes turning from into to in unified diff format, or "" if there are none
+func unifiedDiff(fromName, toName string, from, to []string) string {
+	ops := editScript(from, to)
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	// changes that are close enough to share context lines end up in the same hunk
+	for first := 0; first < len(changes); {
+		last := first
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContext+1 {
+			last++
+		}
+		start := max(0, changes[first]-diffContext)
+		end := min(len(ops), changes[last]+diffContext+1)
+		writeHunk(&b, ops[start:end])
+		first = last + 1
+	}
+	return b.String()
+}
+
+func writeHunk(b *strings.Builder, ops []diffOp) {
+	fromCount, toCount := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			fromCount++
+		}
+		if op.kind != '-' {
+			toCount++
+		}
+	}
+
+	// ranges are 1-based, except that an empty range names the line before it
+	fromStart, toStart := ops[0].from, ops[0].to
+	if fromCount > 0 {
+		fromStart++
+	}
+	if toCount > 0 {
+		toStart++
+	}
+
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+	for _, op := range ops {
+		fmt.Fprintf(b, "%c%s\n", op.kind, op.line)
+	}
+}
+
+// editScript computes a shortest edit script from a to b using their longest common subsequence.
+// Manifests are a few hundred lines at most, so the quadratic table is not a concern.
+func editScript(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
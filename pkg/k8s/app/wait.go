@@ -0,0 +1,315 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// WaitReady blocks until every resource of the application has converged:
+// PVCs are bound and the Deployment or StatefulSet has rolled out with all of its pods ready.
+// Progress is reported on msgChan. If ctx expires first, the returned error
+// describes why the application's pods aren't ready, based on their state and events.
+func (a *Application) WaitReady(ctx context.Context, msgChan chan<- string) error {
+	for _, obj := range a.Resources {
+		var err error
+		switch obj {
+		case PVC:
+			err = a.waitPvcBound(ctx, msgChan)
+		case Deployment:
+			err = a.waitDeploymentRolledOut(ctx, msgChan)
+		case StatefulSet:
+			err = a.waitStatefulSetRolledOut(ctx, msgChan)
+		}
+
+		if err != nil {
+			if wait.Interrupted(err) || errors.Is(err, watchtools.ErrWatchClosed) {
+				return fmt.Errorf("%s is not ready: %s", a.Config.Name, a.diagnose())
+			}
+			return err
+		}
+	}
+
+	msgChan <- fmt.Sprintf("%s is ready", a.Config.Name)
+	return nil
+}
+
+func (a *Application) waitPvcBound(ctx context.Context, msgChan chan<- string) error {
+	pvcCli := a.Client.CoreV1().PersistentVolumeClaims(a.Config.Namespace)
+	lw := nameListWatch(ctx, a.Config.Name,
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return pvcCli.List(ctx, opts)
+		},
+		func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			return pvcCli.Watch(ctx, opts)
+		},
+	)
+
+	progress := newProgress(msgChan)
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.PersistentVolumeClaim{}, nil, func(event watch.Event) (bool, error) {
+		pvc, ok := event.Object.(*corev1.PersistentVolumeClaim)
+		if !ok || pvc.Name != a.Config.Name {
+			return false, nil
+		}
+
+		switch pvc.Status.Phase {
+		case corev1.ClaimBound:
+			return true, nil
+		case corev1.ClaimLost:
+			return false, fmt.Errorf("pvc %s lost its underlying volume", pvc.Name)
+		default:
+			progress.report(fmt.Sprintf("waiting for pvc %s to be bound", pvc.Name))
+			return false, nil
+		}
+	})
+	return err
+}
+
+func (a *Application) waitDeploymentRolledOut(ctx context.Context, msgChan chan<- string) error {
+	dCli := a.Client.AppsV1().Deployments(a.Config.Namespace)
+	lw := nameListWatch(ctx, a.Config.Name,
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return dCli.List(ctx, opts)
+		},
+		func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			return dCli.Watch(ctx, opts)
+		},
+	)
+
+	progress := newProgress(msgChan)
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1.Deployment{}, nil, func(event watch.Event) (bool, error) {
+		d, ok := event.Object.(*v1.Deployment)
+		if !ok || d.Name != a.Config.Name {
+			return false, nil
+		}
+
+		done, status, err := rolloutStatus(d)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			progress.report(status)
+		}
+		return done, nil
+	})
+	return err
+}
+
+func (a *Application) waitStatefulSetRolledOut(ctx context.Context, msgChan chan<- string) error {
+	stsCli := a.Client.AppsV1().StatefulSets(a.Config.Namespace)
+	lw := nameListWatch(ctx, a.Config.Name,
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return stsCli.List(ctx, opts)
+		},
+		func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			return stsCli.Watch(ctx, opts)
+		},
+	)
+
+	progress := newProgress(msgChan)
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1.StatefulSet{}, nil, func(event watch.Event) (bool, error) {
+		sts, ok := event.Object.(*v1.StatefulSet)
+		if !ok || sts.Name != a.Config.Name {
+			return false, nil
+		}
+
+		done, status := statefulSetRolloutStatus(sts)
+		if !done {
+			progress.report(status)
+		}
+		return done, nil
+	})
+	return err
+}
+
+// statefulSetRolloutStatus mirrors the checks `kubectl rollout status` makes on a StatefulSet
+func statefulSetRolloutStatus(sts *v1.StatefulSet) (bool, string) {
+	if sts.Generation > sts.Status.ObservedGeneration {
+		return false, fmt.Sprintf("waiting for statefulset %s spec update to be observed", sts.Name)
+	}
+
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if sts.Status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("waiting for statefulset %s rollout: %d of %d pods ready", sts.Name, sts.Status.ReadyReplicas, replicas)
+	}
+
+	// pods are only replaced on delete, so there is no rollout to wait for
+	if sts.Spec.UpdateStrategy.Type == v1.OnDeleteStatefulSetStrategyType {
+		return true, ""
+	}
+	// with a partition only the pods from the partition's ordinal up are updated, the rest stay at the current revision
+	if ru := sts.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil && *ru.Partition > 0 {
+		if want := replicas - *ru.Partition; sts.Status.UpdatedReplicas < want {
+			return false, fmt.Sprintf("waiting for statefulset %s partitioned rollout: %d of %d pods updated", sts.Name, sts.Status.UpdatedReplicas, want)
+		}
+		return true, ""
+	}
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		return false, fmt.Sprintf("waiting for statefulset %s rolling update: %d pods at revision %s", sts.Name, sts.Status.UpdatedReplicas, sts.Status.UpdateRevision)
+	}
+	return true, ""
+}
+
+// rolloutStatus mirrors the checks `kubectl rollout status` makes on a Deployment
+func rolloutStatus(d *v1.Deployment) (bool, string, error) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, fmt.Sprintf("waiting for deployment %s spec update to be observed", d.Name), nil
+	}
+
+	for _, c := range d.Status.Conditions {
+		if c.Type == v1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return false, "", fmt.Errorf("deployment %s exceeded its progress deadline", d.Name)
+		}
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return false, fmt.Sprintf("waiting for deployment %s rollout: %d of %d updated replicas", d.Name, d.Status.UpdatedReplicas, replicas), nil
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("waiting for deployment %s rollout: %d old replicas pending termination", d.Name, d.Status.Replicas-d.Status.UpdatedReplicas), nil
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("waiting for deployment %s rollout: %d of %d updated replicas ready", d.Name, d.Status.AvailableReplicas, d.Status.UpdatedReplicas), nil
+	}
+	return true, "", nil
+}
+
+// diagnose explains why the application's pods aren't ready,
+// using the state of their containers and the warnings recorded against them.
+// It runs on its own short deadline since the caller's context has usually expired by now.
+func (a *Application) diagnose() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	selector := labels.SelectorFromSet(a.Config.Deployment.SelectorMatchLabels).String()
+	pods, err := a.Client.CoreV1().Pods(a.Config.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Sprintf("failed to list pods: %v", err)
+	}
+
+	var reasons []string
+	for _, pod := range pods.Items {
+		if podReady(pod) {
+			continue
+		}
+		reasons = append(reasons, a.podReasons(ctx, pod)...)
+	}
+	if len(reasons) == 0 {
+		return "timed out waiting for the rollout, no pod problems were reported"
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func (a *Application) podReasons(ctx context.Context, pod corev1.Pod) []string {
+	var reasons []string
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		if w := cs.State.Waiting; w != nil && w.Reason != "" {
+			reasons = append(reasons, fmt.Sprintf("pod %s: container %s is waiting: %s %s", pod.Name, cs.Name, w.Reason, w.Message))
+		}
+		if t := cs.LastTerminationState.Terminated; t != nil {
+			reasons = append(reasons, fmt.Sprintf("pod %s: container %s last terminated: %s (exit code %d) %s", pod.Name, cs.Name, t.Reason, t.ExitCode, t.Message))
+		}
+	}
+
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse {
+			reasons = append(reasons, fmt.Sprintf("pod %s is not scheduled: %s", pod.Name, c.Message))
+		}
+	}
+
+	fieldSelector := fields.OneTermEqualSelector("involvedObject.name", pod.Name).String()
+	events, err := a.Client.CoreV1().Events(a.Config.Namespace).List(ctx, metav1.ListOptions{FieldSelector: fieldSelector})
+	if err != nil {
+		return reasons
+	}
+
+	// only the most recent warnings, the full history is a `kubectl describe` away
+	var warnings []corev1.Event
+	for _, e := range events.Items {
+		if e.InvolvedObject.Name == pod.Name && e.Type == corev1.EventTypeWarning {
+			warnings = append(warnings, e)
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].LastTimestamp.Before(&warnings[j].LastTimestamp)
+	})
+	if len(warnings) > 3 {
+		warnings = warnings[len(warnings)-3:]
+	}
+	for _, e := range warnings {
+		reasons = append(reasons, fmt.Sprintf("pod %s: %s: %s", pod.Name, e.Reason, e.Message))
+	}
+
+	return reasons
+}
+
+func podReady(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// nameListWatch lists and watches the single object called name
+func nameListWatch(
+	ctx context.Context,
+	name string,
+	list func(context.Context, metav1.ListOptions) (runtime.Object, error),
+	watchFn func(context.Context, metav1.ListOptions) (watch.Interface, error),
+) *cache.ListWatch {
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = fieldSelector
+			return list(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = fieldSelector
+			return watchFn(ctx, opts)
+		},
+	}
+}
+
+// progress forwards status messages, skipping repeats of the last one
+// so that every watch event doesn't print the same line again
+type progress struct {
+	msgChan chan<- string
+	last    string
+}
+
+func newProgress(msgChan chan<- string) *progress {
+	return &progress{msgChan: msgChan}
+}
+
+func (p *progress) report(msg string) {
+	if msg == p.last {
+		return
+	}
+	p.last = msg
+	p.msgChan <- msg
+}
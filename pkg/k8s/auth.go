@@ -1,24 +1,20 @@
 package k8s
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/util/homedir"
 )
 
 // You should pass an empty string for kubeconfigPath if you expect to load the kubeconfig stored at the default path: ~/.kube/config.
 // If, however, you want to load a kubeconfig from a different path, pass the path to the kubeconfig file as the kubeconfigPath parameter.
 func GetKubeConfigFromHost(kubeconfigPath string) (*rest.Config, error) {
-	if kubeconfigPath == "" {
-		if home := homedir.HomeDir(); home != "" {
-			kubeconfigPath = filepath.Join(home, ".kube", "config")
-		}
-	}
-
-	data, err := os.ReadFile(kubeconfigPath)
+	data, err := os.ReadFile(resolveKubeConfigPath(kubeconfigPath))
 	if err != nil {
 		return nil, err
 	}
@@ -31,3 +27,142 @@ func GetKubeConfigFromHost(kubeconfigPath string) (*rest.Config, error) {
 
 	return config, nil
 }
+
+// GetNamespaceFromHost returns the namespace set on the kubeconfig's current context.
+// It follows the same kubeconfigPath rules as GetKubeConfigFromHost,
+// and returns an empty string if the current context doesn't set a namespace.
+func GetNamespaceFromHost(kubeconfigPath string) (string, error) {
+	config, err := clientcmd.LoadFromFile(resolveKubeConfigPath(kubeconfigPath))
+	if err != nil {
+		return "", err
+	}
+
+	if ctx, ok := config.Contexts[config.CurrentContext]; ok {
+		return ctx.Namespace, nil
+	}
+	return "", nil
+}
+
+// GetContextFromHost returns the name of the kubeconfig's current context.
+// It follows the same kubeconfigPath rules as GetKubeConfigFromHost.
+func GetContextFromHost(kubeconfigPath string) (string, error) {
+	config, err := clientcmd.LoadFromFile(resolveKubeConfigPath(kubeconfigPath))
+	if err != nil {
+		return "", err
+	}
+	return config.CurrentContext, nil
+}
+
+// PrivateKubeConfigPath returns the path of the kubeconfig tufin keeps apart from ~/.kube/config,
+// for clusters created with a private kubeconfig. It returns an empty string when the host has no config directory.
+func PrivateKubeConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tufin", "kubeconfig")
+}
+
+// DefaultKubeConfigPath returns the kubeconfig to use when none is given:
+// tufin's private kubeconfig once there is one, ~/.kube/config otherwise.
+func DefaultKubeConfigPath() string {
+	if private := PrivateKubeConfigPath(); private != "" {
+		if _, err := os.Stat(private); err == nil {
+			return private
+		}
+	}
+	return resolveKubeConfigPath("")
+}
+
+// MergeKubeConfig merges the clusters, users and contexts of kubeconfig into the kubeconfig at kubeconfigPath,
+// replacing the entries of the same name, and switches to kubeconfig's current context, which it returns.
+// A missing file is created, readable by the user only.
+func MergeKubeConfig(kubeconfigPath string, kubeconfig []byte) (string, error) {
+	incoming, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the kubeconfig: %w", err)
+	}
+	if _, ok := incoming.Contexts[incoming.CurrentContext]; !ok {
+		return "", fmt.Errorf("the kubeconfig has no current context to switch to")
+	}
+
+	path := resolveKubeConfigPath(kubeconfigPath)
+	config, err := loadKubeConfigOrEmpty(path)
+	if err != nil {
+		return "", err
+	}
+	for name, cluster := range incoming.Clusters {
+		config.Clusters[name] = cluster
+	}
+	for name, user := range incoming.AuthInfos {
+		config.AuthInfos[name] = user
+	}
+	for name, ctx := range incoming.Contexts {
+		config.Contexts[name] = ctx
+	}
+	config.CurrentContext = incoming.CurrentContext
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		return "", err
+	}
+	return incoming.CurrentContext, nil
+}
+
+// RemoveKubeConfigContext removes a context, along with the cluster and user it refers to, from the kubeconfig at kubeconfigPath,
+// and returns the number of contexts left. A missing file or context is not an error.
+func RemoveKubeConfigContext(kubeconfigPath, context string) (int, error) {
+	path := resolveKubeConfigPath(kubeconfigPath)
+	config, err := loadKubeConfigOrEmpty(path)
+	if err != nil {
+		return 0, err
+	}
+	ctx, ok := config.Contexts[context]
+	if !ok {
+		return len(config.Contexts), nil
+	}
+
+	// other contexts may share the cluster or user, those are kept for them
+	cluster, user := ctx.Cluster, ctx.AuthInfo
+	delete(config.Contexts, context)
+	shared := func(match func(*clientcmdapi.Context) bool) bool {
+		for _, other := range config.Contexts {
+			if match(other) {
+				return true
+			}
+		}
+		return false
+	}
+	if !shared(func(c *clientcmdapi.Context) bool { return c.Cluster == cluster }) {
+		delete(config.Clusters, cluster)
+	}
+	if !shared(func(c *clientcmdapi.Context) bool { return c.AuthInfo == user }) {
+		delete(config.AuthInfos, user)
+	}
+	if config.CurrentContext == context {
+		config.CurrentContext = ""
+	}
+
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		return 0, err
+	}
+	return len(config.Contexts), nil
+}
+
+func loadKubeConfigOrEmpty(path string) (*clientcmdapi.Config, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return clientcmdapi.NewConfig(), nil
+	}
+	return clientcmd.LoadFromFile(path)
+}
+
+func resolveKubeConfigPath(kubeconfigPath string) string {
+	if kubeconfigPath == "" {
+		if home := homedir.HomeDir(); home != "" {
+			kubeconfigPath = filepath.Join(home, ".kube", "config")
+		}
+	}
+	return kubeconfigPath
+}
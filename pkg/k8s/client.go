@@ -15,10 +15,13 @@ import (
 type Client struct {
 	kubernetes.Interface
 	Metrics metrics.Interface
+
+	// Config is what the clients were built from, port forwarding dials the API server with it directly
+	Config *rest.Config
 }
 
 func NewClient(config *rest.Config) (*Client, error) {
-	client := &Client{}
+	client := &Client{Config: config}
 
 	clientSet, err := kubernetes.NewForConfig(config)
 	if err != nil {
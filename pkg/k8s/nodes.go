@@ -0,0 +1,103 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// clusterPollInterval is how often WaitForCluster checks on the API server and the nodes
+const clusterPollInterval = 2 * time.Second
+
+// serverVersion asks the API server for its version like Discovery().ServerVersion(), which takes no context,
+// so a server that accepts the connection but never answers can't hold WaitForCluster past its deadline
+func serverVersion(ctx context.Context, cli kubernetes.Interface) (*version.Info, error) {
+	rc := cli.Discovery().RESTClient()
+	if rc == nil {
+		// fake clients don't talk to a server
+		return cli.Discovery().ServerVersion()
+	}
+	body, err := rc.Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	var info version.Info
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("unable to parse the server version: %w", err)
+	}
+	return &info, nil
+}
+
+// WaitForCluster waits until the API server answers and every node of the cluster is ready,
+// which a freshly created or started cluster takes a few seconds to get to.
+func WaitForCluster(ctx context.Context, msgChan chan<- string, cli kubernetes.Interface) error {
+	var lastErr error
+	for {
+		version, err := serverVersion(ctx, cli)
+		if err == nil {
+			msgChan <- fmt.Sprintf("API server is up, running Kubernetes %s", version.GitVersion)
+			break
+		}
+		lastErr = err
+		if err := sleep(ctx, clusterPollInterval); err != nil {
+			return fmt.Errorf("API server isn't answering: %w (last error: %v)", err, lastErr)
+		}
+	}
+
+	reported := -1
+	for {
+		nodes, err := cli.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err == nil && len(nodes.Items) > 0 {
+			ready := 0
+			for _, node := range nodes.Items {
+				if isNodeReady(node) {
+					ready++
+				}
+			}
+			if ready == len(nodes.Items) {
+				msgChan <- fmt.Sprintf("all %d nodes are ready", ready)
+				return nil
+			}
+			// only report progress, not every poll
+			if ready != reported {
+				msgChan <- fmt.Sprintf("%d of %d nodes are ready", ready, len(nodes.Items))
+				reported = ready
+			}
+		}
+		if err != nil {
+			lastErr = err
+		}
+
+		if err := sleep(ctx, clusterPollInterval); err != nil {
+			if lastErr != nil {
+				return fmt.Errorf("nodes aren't ready: %w (last error: %v)", err, lastErr)
+			}
+			return fmt.Errorf("nodes aren't ready: %w", err)
+		}
+	}
+}
+
+func isNodeReady(node v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// sleep waits for d, or returns the context's error if it's done first
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
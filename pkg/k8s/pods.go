@@ -10,8 +10,8 @@ import (
 	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
 )
 
-func (c *Client) Pods(namspace string) (*v1.PodList, error) {
-	pods, err := c.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+func (c *Client) Pods(namespace string) (*v1.PodList, error) {
+	pods, err := c.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,273 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// reconnectInterval is how long PortForward waits before looking for a pod again
+// after the forwarded one went away
+const reconnectInterval = time.Second
+
+// PortForwardOptions describes what PortForward forwards, and where to.
+type PortForwardOptions struct {
+	// Namespace the pods live in.
+	Namespace string
+
+	// Selector picks the pods that can be forwarded to, any ready one of them is used.
+	Selector map[string]string
+
+	// PodName narrows the pods the selector picks to the one of that name, e.g. a StatefulSet's primary,
+	// also when reconnecting. Empty forwards to any of them.
+	PodName string
+
+	// RemotePort is the port the pod listens on.
+	RemotePort int
+
+	// LocalPort is the port to listen on locally, zero picks a free one.
+	// The port stays the same across reconnects.
+	LocalPort int
+
+	// Address is the local address to listen on, empty means localhost.
+	Address string
+
+	// Ready is called with the local port every time the forward is (re)established, it may be nil.
+	Ready func(localPort int)
+}
+
+// PortForward forwards a local port to a ready pod matching the selector, and the pod name when given, over the API server's SPDY port-forward endpoint.
+// When the pod goes away, for example because a rollout replaced it, PortForward waits for another ready pod
+// and forwards to that one instead, on the same local port. It runs until ctx is done.
+func (c *Client) PortForward(ctx context.Context, msgChan chan<- string, opts PortForwardOptions) error {
+	if c.Config == nil {
+		return fmt.Errorf("port forwarding needs the client's REST config")
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(c.Config)
+	if err != nil {
+		return err
+	}
+
+	address := opts.Address
+	if address == "" {
+		address = "localhost"
+	}
+	localPort := opts.LocalPort
+	established := false
+
+	// waiting makes sense for pods that are still starting, not for something that isn't deployed at all
+	selector := labels.SelectorFromSet(opts.Selector).String()
+	pods, err := c.CoreV1().Pods(opts.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("no pods match %s in namespace %s", selector, opts.Namespace)
+	}
+
+	for {
+		pod, err := waitForPod(ctx, c, opts.Namespace, opts.Selector, opts.PodName)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		url := c.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(pod.Namespace).
+			Name(pod.Name).
+			SubResource("portforward").
+			URL()
+		dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+		stop := make(chan struct{})
+		ready := make(chan struct{})
+		ports := []string{fmt.Sprintf("%d:%d", localPort, opts.RemotePort)}
+		fw, err := portforward.NewOnAddresses(dialer, []string{address}, ports, stop, ready, io.Discard, io.Discard)
+		if err != nil {
+			return err
+		}
+
+		// closing stop ends the forward, either because we're done or because the pod went away
+		podCtx, cancelPod := context.WithCancel(ctx)
+		go func() {
+			watchPod(podCtx, c, pod)
+			close(stop)
+		}()
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- fw.ForwardPorts()
+		}()
+
+		select {
+		case <-ready:
+			forwarded, err := fw.GetPorts()
+			if err == nil && len(forwarded) > 0 {
+				// a port picked at random has to stay the same when we reconnect
+				localPort = int(forwarded[0].Local)
+			}
+			established = true
+			msgChan <- fmt.Sprintf("forwarding %s:%d to pod %s port %d", address, localPort, pod.Name, opts.RemotePort)
+			if opts.Ready != nil {
+				opts.Ready(localPort)
+			}
+			err = <-errs
+		case err = <-errs:
+		}
+		cancelPod()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		// failing to get going the first time, a taken local port for instance, won't fix itself
+		if !established {
+			return fmt.Errorf("failed to forward to pod %s: %w", pod.Name, err)
+		}
+
+		if err != nil {
+			msgChan <- fmt.Sprintf("lost connection to pod %s (%v), reconnecting", pod.Name, err)
+		} else {
+			msgChan <- fmt.Sprintf("pod %s went away, reconnecting", pod.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// WaitForReadyPod returns a ready pod matching the selector, waiting for one to become ready if there is none yet.
+// Pods that are being deleted don't count. When several are ready, the oldest is picked,
+// it is the one least likely to be replaced by a rollout that is still going on.
+func WaitForReadyPod(ctx context.Context, cli kubernetes.Interface, namespace string, selector map[string]string) (*v1.Pod, error) {
+	return waitForPod(ctx, cli, namespace, selector, "")
+}
+
+// WaitForPod returns the pod called name among those matching the selector, waiting for it to be ready.
+// A StatefulSet recreates its pods under the same name, so this waits across their replacement.
+func WaitForPod(ctx context.Context, cli kubernetes.Interface, namespace string, selector map[string]string, name string) (*v1.Pod, error) {
+	return waitForPod(ctx, cli, namespace, selector, name)
+}
+
+// waitForPod waits for a ready pod matching the selector, only the one called podName unless it's empty
+func waitForPod(ctx context.Context, cli kubernetes.Interface, namespace string, selector map[string]string, podName string) (*v1.Pod, error) {
+	listOpts := metav1.ListOptions{LabelSelector: labels.SelectorFromSet(selector).String()}
+	what := listOpts.LabelSelector
+	if podName != "" {
+		what = fmt.Sprintf("%s with name %s", what, podName)
+	}
+
+	for {
+		pods, err := cli.CoreV1().Pods(namespace).List(ctx, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		candidates := pods.Items
+		if podName != "" {
+			candidates = nil
+			for _, pod := range pods.Items {
+				if pod.Name == podName {
+					candidates = append(candidates, pod)
+				}
+			}
+		}
+		if pod := oldestReadyPod(candidates); pod != nil {
+			return pod, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no ready pod matches %s in namespace %s: %w", what, namespace, ctx.Err())
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+func oldestReadyPod(pods []v1.Pod) *v1.Pod {
+	var ready []v1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil && isPodReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		ti, tj := ready[i].CreationTimestamp, ready[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return ready[i].Name < ready[j].Name
+	})
+	return &ready[0]
+}
+
+func isPodReady(pod v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// watchPod returns once the pod is deleted, stops being ready or ctx is done.
+// A watch that ends early is restarted, so only the pod itself ends the forward.
+func watchPod(ctx context.Context, cli kubernetes.Interface, pod *v1.Pod) {
+	resourceVersion := pod.ResourceVersion
+	for ctx.Err() == nil {
+		w, err := cli.CoreV1().Pods(pod.Namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   "metadata.name=" + pod.Name,
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(reconnectInterval):
+			}
+			continue
+		}
+
+		for event := range w.ResultChan() {
+			switch event.Type {
+			case watch.Deleted:
+				w.Stop()
+				return
+			case watch.Added, watch.Modified:
+				p, ok := event.Object.(*v1.Pod)
+				if !ok {
+					continue
+				}
+				resourceVersion = p.ResourceVersion
+				if p.DeletionTimestamp != nil || !isPodReady(*p) {
+					w.Stop()
+					return
+				}
+			case watch.Error:
+				// most likely an expired resource version, start over from the current state
+				resourceVersion = ""
+			}
+		}
+		w.Stop()
+	}
+}
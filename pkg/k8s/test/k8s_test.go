@@ -1,11 +1,23 @@
 package k8s_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kol-ratner/tufin/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 func TestGetKubeConfigFromHost(t *testing.T) {
@@ -36,6 +48,160 @@ func TestGetKubeConfigFromHost(t *testing.T) {
 	}
 }
 
+func TestGetNamespaceFromHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		configPath string
+		want       string
+		wantError  bool
+	}{
+		{
+			name:       "context without namespace",
+			configPath: filepath.Join("testdata", "kubeconfig"),
+			want:       "",
+			wantError:  false,
+		},
+		{
+			name:       "context with namespace",
+			configPath: filepath.Join("testdata", "kubeconfig-namespace"),
+			want:       "team-a",
+			wantError:  false,
+		},
+		{
+			name:       "invalid path",
+			configPath: "/nonexistent/path",
+			want:       "",
+			wantError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k8s.GetNamespaceFromHost(tt.configPath)
+			if (err != nil) != tt.wantError {
+				t.Errorf("GetNamespaceFromHost() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("GetNamespaceFromHost() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetContextFromHost(t *testing.T) {
+	got, err := k8s.GetContextFromHost(filepath.Join("testdata", "kubeconfig"))
+	if err != nil {
+		t.Fatalf("GetContextFromHost() error = %v", err)
+	}
+	if got != "test-context" {
+		t.Errorf("GetContextFromHost() = %q, want %q", got, "test-context")
+	}
+}
+
+// k3dKubeconfig is a kubeconfig the way k3d kubeconfig get prints it
+func k3dKubeconfig(name string) []byte {
+	return []byte(`apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://0.0.0.0:6443
+  name: k3d-` + name + `
+contexts:
+- context:
+    cluster: k3d-` + name + `
+    user: admin@k3d-` + name + `
+  name: k3d-` + name + `
+current-context: k3d-` + name + `
+users:
+- name: admin@k3d-` + name + `
+  user:
+    token: secret
+`)
+}
+
+func TestMergeKubeConfig(t *testing.T) {
+	existing, err := os.ReadFile(filepath.Join("testdata", "kubeconfig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "kube", "config")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, existing, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	context, err := k8s.MergeKubeConfig(path, k3dKubeconfig("review"))
+	if err != nil {
+		t.Fatalf("MergeKubeConfig() error = %v", err)
+	}
+	if context != "k3d-review" {
+		t.Errorf("MergeKubeConfig() = %q, want k3d-review", context)
+	}
+
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CurrentContext != "k3d-review" {
+		t.Errorf("current context = %q, want k3d-review", config.CurrentContext)
+	}
+	for _, name := range []string{"test-context", "k3d-review"} {
+		if _, ok := config.Contexts[name]; !ok {
+			t.Errorf("context %s is missing after the merge", name)
+		}
+	}
+
+	// removing the cluster again leaves the other context, but not the current context pointing at nothing
+	left, err := k8s.RemoveKubeConfigContext(path, "k3d-review")
+	if err != nil {
+		t.Fatalf("RemoveKubeConfigContext() error = %v", err)
+	}
+	if left != 1 {
+		t.Errorf("RemoveKubeConfigContext() = %d contexts left, want 1", left)
+	}
+	if config, err = clientcmd.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.Clusters["k3d-review"]; ok {
+		t.Error("cluster k3d-review is still there")
+	}
+	if _, ok := config.AuthInfos["admin@k3d-review"]; ok {
+		t.Error("user admin@k3d-review is still there")
+	}
+	if _, ok := config.Clusters["test-cluster"]; !ok {
+		t.Error("cluster test-cluster was removed with the other context")
+	}
+	if config.CurrentContext != "" {
+		t.Errorf("current context = %q, want it cleared", config.CurrentContext)
+	}
+}
+
+func TestMergeKubeConfig_NewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tufin", "kubeconfig")
+
+	if _, err := k8s.MergeKubeConfig(path, k3dKubeconfig("review")); err != nil {
+		t.Fatalf("MergeKubeConfig() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("kubeconfig permissions = %o, want 600", perm)
+	}
+
+	if _, err := k8s.MergeKubeConfig(path, []byte("apiVersion: v1\nkind: Config\n")); err == nil {
+		t.Error("MergeKubeConfig() of a kubeconfig without a current context succeeded, want an error")
+	}
+
+	left, err := k8s.RemoveKubeConfigContext(filepath.Join(t.TempDir(), "missing"), "k3d-review")
+	if err != nil || left != 0 {
+		t.Errorf("RemoveKubeConfigContext() of a missing file = %d, %v, want 0, nil", left, err)
+	}
+}
+
 func TestNewClient(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -76,3 +242,217 @@ func TestNewClient(t *testing.T) {
 		})
 	}
 }
+
+func TestWaitForReadyPod(t *testing.T) {
+	selector := map[string]string{"app": "wordpress"}
+	now := metav1.Now()
+	pod := func(name string, age time.Duration, phase corev1.PodPhase, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				Labels:            selector,
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Status: corev1.PodStatus{
+				Phase:      phase,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+	terminating := pod("wordpress-terminating", time.Hour, corev1.PodRunning, corev1.ConditionTrue)
+	terminating.DeletionTimestamp = &now
+	terminating.Finalizers = []string{"example.com/block"}
+	otherApp := pod("mysql-0", time.Hour, corev1.PodRunning, corev1.ConditionTrue)
+	otherApp.Labels = map[string]string{"app": "mysql"}
+
+	tests := []struct {
+		name    string
+		pods    []runtime.Object
+		wantPod string
+	}{
+		{
+			name: "oldest ready pod",
+			pods: []runtime.Object{
+				pod("wordpress-new", time.Minute, corev1.PodRunning, corev1.ConditionTrue),
+				pod("wordpress-old", 10*time.Minute, corev1.PodRunning, corev1.ConditionTrue),
+			},
+			wantPod: "wordpress-old",
+		},
+		{
+			name: "unready and terminating pods are skipped",
+			pods: []runtime.Object{
+				terminating,
+				otherApp,
+				pod("wordpress-pending", time.Hour, corev1.PodPending, corev1.ConditionFalse),
+				pod("wordpress-unready", time.Hour, corev1.PodRunning, corev1.ConditionFalse),
+				pod("wordpress-ready", time.Minute, corev1.PodRunning, corev1.ConditionTrue),
+			},
+			wantPod: "wordpress-ready",
+		},
+		{
+			name: "no ready pod",
+			pods: []runtime.Object{
+				pod("wordpress-unready", time.Hour, corev1.PodRunning, corev1.ConditionFalse),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			got, err := k8s.WaitForReadyPod(ctx, fake.NewSimpleClientset(tt.pods...), "default", selector)
+			if tt.wantPod == "" {
+				if err == nil {
+					t.Fatalf("WaitForReadyPod() = %s, want an error", got.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WaitForReadyPod() error = %v", err)
+			}
+			if got.Name != tt.wantPod {
+				t.Errorf("WaitForReadyPod() = %s, want %s", got.Name, tt.wantPod)
+			}
+		})
+	}
+}
+
+func TestWaitForPod(t *testing.T) {
+	selector := map[string]string{"app": "mysql"}
+	now := metav1.Now()
+	pod := func(name string, age time.Duration, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				Labels:            selector,
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		pods    []runtime.Object
+		wantPod string
+	}{
+		{
+			name: "named pod, though another is older",
+			pods: []runtime.Object{
+				pod("mysql-0", time.Minute, corev1.ConditionTrue),
+				pod("mysql-1", time.Hour, corev1.ConditionTrue),
+			},
+			wantPod: "mysql-0",
+		},
+		{
+			name: "named pod not ready",
+			pods: []runtime.Object{
+				pod("mysql-0", time.Minute, corev1.ConditionFalse),
+				pod("mysql-1", time.Hour, corev1.ConditionTrue),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			got, err := k8s.WaitForPod(ctx, fake.NewSimpleClientset(tt.pods...), "default", selector, "mysql-0")
+			if tt.wantPod == "" {
+				if err == nil {
+					t.Fatalf("WaitForPod() = %s, want an error", got.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WaitForPod() error = %v", err)
+			}
+			if got.Name != tt.wantPod {
+				t.Errorf("WaitForPod() = %s, want %s", got.Name, tt.wantPod)
+			}
+		})
+	}
+}
+
+func TestWaitForCluster(t *testing.T) {
+	node := func(name string, ready corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		nodes     []runtime.Object
+		wantError string
+	}{
+		{
+			name:  "all nodes ready",
+			nodes: []runtime.Object{node("server-0", corev1.ConditionTrue), node("agent-0", corev1.ConditionTrue)},
+		},
+		{
+			name:      "a node isn't ready",
+			nodes:     []runtime.Object{node("server-0", corev1.ConditionTrue), node("agent-0", corev1.ConditionFalse)},
+			wantError: "nodes aren't ready",
+		},
+		{
+			name:      "no nodes registered yet",
+			wantError: "nodes aren't ready",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			err := k8s.WaitForCluster(ctx, make(chan string, 10), fake.NewClientset(tt.nodes...))
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("WaitForCluster() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("WaitForCluster() error = %v, want it to contain %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestWaitForCluster_UnansweredVersion(t *testing.T) {
+	// the server takes the connection but never answers, until the client gives up on it
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- k8s.WaitForCluster(ctx, make(chan string, 10), cli) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "API server isn't answering") {
+			t.Errorf("WaitForCluster() error = %v, want it to contain %q", err, "API server isn't answering")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForCluster() kept waiting on the version past its context's deadline")
+	}
+}